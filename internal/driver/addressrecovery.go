@@ -0,0 +1,266 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	wsdiscovery "github.com/IOTechSystems/onvif/ws-discovery"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// resolveMessageTemplate is a ws-discovery Resolve message, which asks the camera with the specified
+	// endpoint reference to respond with its current transport addresses
+	resolveMessageTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+  <s:Header>
+    <a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Resolve</a:Action>
+    <a:MessageID>uuid:%s</a:MessageID>
+    <a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>
+  </s:Header>
+  <s:Body>
+    <d:Resolve>
+      <a:EndpointReference>
+        <a:Address>urn:uuid:%s</a:Address>
+      </a:EndpointReference>
+    </d:Resolve>
+  </s:Body>
+</s:Envelope>`
+
+	// addressRecoveryInterval is the minimum amount of time between two attempts to locate the same unreachable device,
+	// since the multicast Resolve waits for the responses of every camera on the network
+	addressRecoveryInterval = 5 * time.Minute
+)
+
+// probeEnvelope is the minimal representation of a ws-discovery ProbeMatches response
+type probeEnvelope struct {
+	Body struct {
+		ProbeMatches struct {
+			ProbeMatch []struct {
+				XAddrs string
+			}
+		}
+	}
+}
+
+// arpTablePath is the location of the kernel's ARP table, which is used to lookup the address of a MAC address
+var arpTablePath = "/proc/net/arp"
+
+// resolveEnvelope is the minimal representation of a ws-discovery ResolveMatches response
+type resolveEnvelope struct {
+	Body struct {
+		ResolveMatches struct {
+			ResolveMatch []struct {
+				EndpointReference struct {
+					Address string
+				}
+				XAddrs string
+			}
+		}
+	}
+}
+
+// recoverDeviceAddress tries to find the current network address of an unreachable device, in case the camera's
+// address was changed by DHCP. A ws-discovery Resolve message is sent for the EndpointRefAddress of the device,
+// and if the camera does not respond, its MAC address is looked up with locateByMAC. The protocol properties of the
+// device are updated if a different address is found, which re-creates the onvif client via the UpdateDevice callback.
+// Returns true if the address was updated.
+func (d *Driver) recoverDeviceAddress(device models.Device) bool {
+	if !d.allowAddressRecovery(device.Name, time.Now()) {
+		d.lc.Tracef("Skip locating the unreachable device %s, it was attempted less than %v ago", device.Name, addressRecoveryInterval)
+		return false
+	}
+
+	protocol := device.Protocols[OnvifProtocol]
+	existAddr := protocolValue(protocol, Address)
+	existPort := protocolValue(protocol, Port)
+
+	d.configMu.RLock()
+	discoveryEthernetInterface := d.config.AppCustom.DiscoveryEthernetInterface
+	d.configMu.RUnlock()
+
+	addr, port := "", existPort
+	if endpointRefAddr := protocolValue(protocol, EndpointRefAddress); endpointRefAddr != "" {
+		msg := fmt.Sprintf(resolveMessageTemplate, uuid.NewString(), endpointRefAddr)
+		addr, port = parseResolveMatches(wsdiscovery.SendUDPMulticast(msg, discoveryEthernetInterface), endpointRefAddr)
+		if port == "" {
+			port = existPort
+		}
+	}
+	d.configMu.RLock()
+	timeout := time.Duration(d.config.AppCustom.ProbeTimeoutMillis) * time.Millisecond
+	d.configMu.RUnlock()
+	if addr == "" {
+		if mac := protocolValue(protocol, MACAddress); mac != "" {
+			addr = locateByMAC(mac, discoveryEthernetInterface, timeout)
+		}
+	}
+
+	if addr == "" || (addr == existAddr && port == existPort) {
+		d.lc.Debugf("Unable to find a new network address for the unreachable device %s", device.Name)
+		return false
+	}
+
+	// verify a service is listening at the new address before updating the device
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(addr, port), timeout)
+	if err != nil {
+		d.lc.Debugf("Device %s was located at %s:%s, but the address is not reachable: %s", device.Name, addr, port, err.Error())
+		return false
+	}
+	_ = conn.Close()
+
+	// lookup device from cache to ensure we are updating the latest version
+	unlock := d.lockDeviceProtocols(device.Name)
+	defer unlock()
+	latest, err := d.latestDevice(device.Name)
+	if err != nil {
+		d.lc.Warnf("Unable to get device %s from cache while trying to update its network address: %s", device.Name, err.Error())
+		return false
+	}
+
+	d.lc.Infof("Unreachable device %s has been located at a different network address. Old: %s, New: %s",
+		latest.Name, existAddr+":"+existPort, addr+":"+port)
+	latest.Protocols[OnvifProtocol][Address] = addr
+	latest.Protocols[OnvifProtocol][Port] = port
+	if err = d.patchDeviceProtocols(latest.Name, latest.Protocols); err != nil {
+		d.lc.Errorf("There was an error updating the network address for device %s: %s", latest.Name, err.Error())
+		return false
+	}
+	return true
+}
+
+// allowAddressRecovery returns whether the address of the specified device may be looked up at the specified time,
+// and records the attempt if so
+func (d *Driver) allowAddressRecovery(deviceName string, now time.Time) bool {
+	d.addressRecoveryMu.Lock()
+	defer d.addressRecoveryMu.Unlock()
+	if d.addressRecoveries == nil {
+		d.addressRecoveries = make(map[string]time.Time)
+	}
+	if last, ok := d.addressRecoveries[deviceName]; ok && now.Sub(last) < addressRecoveryInterval {
+		return false
+	}
+	d.addressRecoveries[deviceName] = now
+	return true
+}
+
+// parseResolveMatches returns the address and port of the first XAddr of the ResolveMatch with the specified
+// endpoint reference address
+func parseResolveMatches(responses []string, endpointRefAddr string) (string, string) {
+	for _, resp := range responses {
+		envelope := resolveEnvelope{}
+		if err := xml.Unmarshal([]byte(resp), &envelope); err != nil {
+			continue
+		}
+		for _, match := range envelope.Body.ResolveMatches.ResolveMatch {
+			uuidElements := strings.Split(strings.TrimSpace(match.EndpointReference.Address), ":")
+			if uuidElements[len(uuidElements)-1] != endpointRefAddr {
+				continue
+			}
+			for _, xaddr := range strings.Fields(match.XAddrs) {
+				u, err := url.Parse(xaddr)
+				if err != nil || u.Hostname() == "" {
+					continue
+				}
+				return u.Hostname(), u.Port()
+			}
+		}
+	}
+	return "", ""
+}
+
+// locateByMAC returns the address of the camera with the specified MAC address, or an empty string if it is not found.
+// The ARP table only holds the new address of a camera once it exchanged traffic with the device service, so the
+// cameras which respond to a multicast ws-discovery probe are contacted before the ARP table is searched again.
+func locateByMAC(mac, ethernetInterface string, timeout time.Duration) string {
+	if addr := lookupAddressByMAC(mac); addr != "" {
+		return addr
+	}
+	probe := wsdiscovery.BuildProbeMessage(uuid.NewString(), nil, []string{"dn:NetworkVideoTransmitter"},
+		map[string]string{"dn": "http://www.onvif.org/ver10/network/wsdl"})
+	var wg sync.WaitGroup
+	for _, host := range parseProbeMatchHosts(wsdiscovery.SendUDPMulticast(probe.String(), ethernetInterface)) {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			// the connection resolves the MAC address of the camera, whether or not it succeeds
+			if conn, err := net.DialTimeout("tcp", host, timeout); err == nil {
+				_ = conn.Close()
+			}
+		}(host)
+	}
+	wg.Wait()
+	return lookupAddressByMAC(mac)
+}
+
+// parseProbeMatchHosts returns the host:port of the first XAddr of every ProbeMatch
+func parseProbeMatchHosts(responses []string) []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, resp := range responses {
+		envelope := probeEnvelope{}
+		if err := xml.Unmarshal([]byte(resp), &envelope); err != nil {
+			continue
+		}
+		for _, match := range envelope.Body.ProbeMatches.ProbeMatch {
+			for _, xaddr := range strings.Fields(match.XAddrs) {
+				u, err := url.Parse(xaddr)
+				if err != nil || u.Hostname() == "" {
+					continue
+				}
+				host := u.Host
+				if u.Port() == "" {
+					host = net.JoinHostPort(u.Hostname(), "80")
+				}
+				if !seen[host] {
+					seen[host] = true
+					hosts = append(hosts, host)
+				}
+				break
+			}
+		}
+	}
+	return hosts
+}
+
+// lookupAddressByMAC returns the IP address of a complete ARP table entry for the specified MAC address
+func lookupAddressByMAC(mac string) string {
+	sanitized, err := SanitizeMACAddress(mac)
+	if err != nil {
+		return ""
+	}
+
+	file, err := os.Open(arpTablePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // skip the header line
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] == "0x0" { // 0x0 flags indicates an incomplete entry
+			continue
+		}
+		if entryMAC, err := SanitizeMACAddress(fields[3]); err == nil && entryMAC == sanitized {
+			return fields[0]
+		}
+	}
+	return ""
+}
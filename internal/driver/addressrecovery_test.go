@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProbeMatchHosts(t *testing.T) {
+	response := `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+  <SOAP-ENV:Body>
+    <d:ProbeMatches>
+      <d:ProbeMatch>
+        <d:XAddrs>http://192.168.1.23:10000/onvif/device_service http://[fe80::1]/onvif/device_service</d:XAddrs>
+      </d:ProbeMatch>
+      <d:ProbeMatch>
+        <d:XAddrs>http://192.168.1.24/onvif/device_service</d:XAddrs>
+      </d:ProbeMatch>
+    </d:ProbeMatches>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+	assert.Equal(t, []string{"192.168.1.23:10000", "192.168.1.24:80"}, parseProbeMatchHosts([]string{"invalid", response, response}))
+	assert.Empty(t, parseProbeMatchHosts(nil))
+}
+
+func TestParseResolveMatches(t *testing.T) {
+	response := `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+  <SOAP-ENV:Header>
+    <wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ResolveMatches</wsa:Action>
+  </SOAP-ENV:Header>
+  <SOAP-ENV:Body>
+    <d:ResolveMatches>
+      <d:ResolveMatch>
+        <wsa:EndpointReference>
+          <wsa:Address>urn:uuid:793dfb2-28b0-11ed-a261-0242ac120002</wsa:Address>
+        </wsa:EndpointReference>
+        <d:XAddrs>http://192.168.1.23:10000/onvif/device_service http://[fe80::1]/onvif/device_service</d:XAddrs>
+      </d:ResolveMatch>
+    </d:ResolveMatches>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+	tests := []struct {
+		name            string
+		responses       []string
+		endpointRefAddr string
+		expectedAddr    string
+		expectedPort    string
+	}{
+		{
+			name:            "matching endpoint reference",
+			responses:       []string{"invalid", response},
+			endpointRefAddr: "793dfb2-28b0-11ed-a261-0242ac120002",
+			expectedAddr:    "192.168.1.23",
+			expectedPort:    "10000",
+		},
+		{
+			name:            "different endpoint reference",
+			responses:       []string{response},
+			endpointRefAddr: "8a4b5e12-28b0-11ed-a261-0242ac120002",
+		},
+		{
+			name:            "no responses",
+			endpointRefAddr: "793dfb2-28b0-11ed-a261-0242ac120002",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			addr, port := parseResolveMatches(test.responses, test.endpointRefAddr)
+			assert.Equal(t, test.expectedAddr, addr)
+			assert.Equal(t, test.expectedPort, port)
+		})
+	}
+}
+
+func TestLookupAddressByMAC(t *testing.T) {
+	arpTable := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.1      0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+192.168.1.23     0x1         0x2         02:42:c0:a8:90:0e     *        eth0
+192.168.1.50     0x1         0x0         11:22:33:44:55:66     *        eth0
+`
+	path := filepath.Join(t.TempDir(), "arp")
+	require.NoError(t, os.WriteFile(path, []byte(arpTable), 0600))
+
+	original := arpTablePath
+	arpTablePath = path
+	defer func() { arpTablePath = original }()
+
+	assert.Equal(t, "192.168.1.23", lookupAddressByMAC("02:42:C0:A8:90:0E"))
+	assert.Equal(t, "192.168.1.23", lookupAddressByMAC("02-42-c0-a8-90-0e"))
+	assert.Equal(t, "", lookupAddressByMAC("11:22:33:44:55:66"), "incomplete entries should be ignored")
+	assert.Equal(t, "", lookupAddressByMAC("ab:cd:ef:12:34:56"))
+	assert.Equal(t, "", lookupAddressByMAC("invalid"))
+}
+
+func TestDriver_allowAddressRecovery(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	now := time.Now()
+
+	assert.True(t, driver.allowAddressRecovery(testDeviceName, now))
+	assert.False(t, driver.allowAddressRecovery(testDeviceName, now.Add(time.Minute)))
+	// the attempts are limited per device
+	assert.True(t, driver.allowAddressRecovery("other-device", now.Add(time.Minute)))
+	assert.True(t, driver.allowAddressRecovery(testDeviceName, now.Add(addressRecoveryInterval)))
+}
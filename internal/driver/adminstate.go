@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+// updateAdminState applies the admin state of a device to its onvif client. The event subscriptions of a
+// camera are torn down when the device is locked, and re-created with the same requests when it is unlocked. The
+// metadata stream is paused while the device is locked. The subscriptions are changed in the background, since
+// they require requests to the camera.
+func (d *Driver) updateAdminState(device models.Device) {
+	onvifClient, ok := d.getOnvifClient(device.Name)
+	if !ok {
+		return
+	}
+
+	locked := device.AdminState == models.Locked
+	if onvifClient.locked.Swap(locked) == locked {
+		return
+	}
+	go d.applyAdminState(onvifClient, device, locked)
+}
+
+// applyAdminState suspends or resumes the event subscriptions of the camera. A change which was superseded by a
+// later admin state change is skipped, since the later change applies the current state.
+func (d *Driver) applyAdminState(onvifClient *OnvifClient, device models.Device, locked bool) {
+	if locked {
+		onvifClient.adminStateMu.Lock()
+		defer onvifClient.adminStateMu.Unlock()
+		if !onvifClient.locked.Load() {
+			return
+		}
+		d.lc.Infof("Device %s is locked, suspending its event subscriptions", device.Name)
+		onvifClient.pullPointManager.Suspend()
+		onvifClient.baseNotificationManager.Suspend()
+		onvifClient.pauseMetadataStream()
+		return
+	}
+
+	d.lc.Infof("Device %s is unlocked, resuming its event subscriptions", device.Name)
+	d.checkStatusOfDevice(device)
+	onvifClient.resumeSubscriptions()
+}
+
+// resumeSubscriptions re-creates the suspended event subscriptions of an unlocked camera. The subscriptions which can
+// not be re-created stay suspended, and are retried by the next status check.
+func (onvifClient *OnvifClient) resumeSubscriptions() {
+	onvifClient.adminStateMu.Lock()
+	defer onvifClient.adminStateMu.Unlock()
+	if onvifClient.locked.Load() {
+		return
+	}
+	onvifClient.pullPointManager.Resume(onvifClient)
+	onvifClient.baseNotificationManager.Resume(onvifClient)
+}
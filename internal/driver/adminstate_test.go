@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_updateAdminState_locked(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	driver.onvifClients[testDeviceName] = client
+
+	// simulate a running subscriber, which removes itself when it is stopped
+	request := &SubscriptionRequest{}
+	sub := &Subscriber{Name: "CameraEvent", subscriptionRequest: request, Stopped: make(chan bool), done: make(chan struct{})}
+	client.pullPointManager.subscribers["CameraEvent"] = sub
+	go func() {
+		<-sub.Stopped
+		client.pullPointManager.removeSubscriber(sub)
+		close(sub.done)
+	}()
+
+	device := createTestDevice()
+	device.AdminState = models.Locked
+	driver.updateAdminState(device)
+
+	assert.True(t, client.locked.Load())
+	// the subscriptions are suspended in the background
+	require.Eventually(t, func() bool {
+		return len(client.pullPointManager.listSubscribers()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	suspended, ok := client.pullPointManager.request("CameraEvent")
+	require.True(t, ok)
+	assert.Same(t, request, suspended)
+
+	// locking the device again is a no-op
+	driver.updateAdminState(device)
+	assert.Len(t, client.pullPointManager.subscriptions(), 1)
+
+	// status checks are skipped, so no calls are made to the mock device
+	driver.checkStatusOfDevice(device)
+}
+
+func TestOnvifClient_resumeSubscriptions(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	driver.config.AppCustom.RequestTimeout = 1
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	// nothing listens at the address, so the subscription can not be re-created
+	mockDevice.On("GetDeviceParams").Return(onvif.DeviceParams{Xaddr: "127.0.0.1:1"})
+	request := &SubscriptionRequest{}
+	client.pullPointManager.suspend("CameraEvent", request)
+
+	client.locked.Store(true)
+	client.resumeSubscriptions()
+	mockDevice.AssertNotCalled(t, "GetDeviceParams")
+
+	client.locked.Store(false)
+	client.resumeSubscriptions()
+	resumed, ok := client.pullPointManager.request("CameraEvent")
+	require.True(t, ok, "the subscription stays suspended when it can not be re-created")
+	assert.Same(t, request, resumed)
+	assert.Empty(t, client.pullPointManager.listSubscribers())
+}
@@ -7,11 +7,12 @@
 package driver
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
@@ -20,7 +21,6 @@ import (
 
 	"github.com/IOTechSystems/onvif"
 	"github.com/IOTechSystems/onvif/event"
-	"github.com/IOTechSystems/onvif/gosoap"
 	"github.com/IOTechSystems/onvif/xsd"
 )
 
@@ -31,68 +31,168 @@ type Consumer struct {
 	onvifClient *OnvifClient
 	manager     *BaseNotificationManager
 
+	// token is the secret which authenticates the notifications of the subscription, it is embedded in the consumer
+	// reference address sent to the camera
+	token string
 	// subscriptionRequest is used to create the BaseNotification subscription
 	subscriptionRequest *SubscriptionRequest
 	// SubscriptionAddress is the reference for the event producer
 	SubscriptionAddress string
+	// terminationDeadline is the local time at which the subscription terminates
+	terminationDeadline time.Time
+	// mu protects the subscriptionRequest, SubscriptionAddress and terminationDeadline, which change when the
+	// subscription is re-created or its filters are updated
+	mu sync.Mutex
+	// health tracks the state and the errors of the subscription
+	health subscriptionHealth
 	// Stopped indicates the Consumer should stop the subscription
 	Stopped chan bool
+	// done is closed when the renew loop has exited
+	done chan struct{}
+	// resubscribeRequests hands the requests to re-create the subscription to the renew loop, which sends the result
+	// to the reply channel
+	resubscribeRequests chan chan errors.EdgeX
 }
 
-// StartRenewLoop renews the subscription before termination time
+// newSubscriptionToken generates the secret token of a BaseNotification subscription
+func newSubscriptionToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// StartRenewLoop renews the subscription before its termination time, and unsubscribes it from the camera when the
+// consumer is stopped. A subscription which can not be renewed is re-created, with exponential backoff between the
+// attempts. A subscription without AutoRenew is removed when it terminates. The subscription is only replaced by this
+// loop, the requests of other goroutines are handed to it with requestResubscribe.
 func (consumer *Consumer) StartRenewLoop() {
 	consumer.lc.Infof("Consumer starts the Renew loop for '%s'", consumer.Name)
-	// Remove self when subscription finished or renew failed
+	defer close(consumer.done)
+	// Remove self when the subscription is stopped or terminated
 	defer consumer.manager.removeConsumer(consumer)
 
-	duration, err := ParseISO8601(*consumer.subscriptionRequest.InitialTerminationTime)
-	if err != nil {
-		consumer.lc.Infof("invalid Initial termination time, %v", err)
-		return
-	}
-
-	// Send Renew request every ten second before termination time
-	renewTime := duration - 10*time.Second
-	renewTicker := time.NewTicker(renewTime)
+	timer := time.NewTimer(consumer.nextDelay(time.Now()))
+	defer timer.Stop()
+	attempt := 0
 	for {
 		select {
 		case <-consumer.Stopped:
 			consumer.lc.Infof("Stopping the subscription '%s'", consumer.Name)
+			if edgexErr := consumer.unsubscribe(); edgexErr != nil {
+				consumer.lc.Warnf("Failed to unsubscribe the subscription '%s' from the camera, %v", consumer.Name, edgexErr)
+			}
 			return
-		case <-renewTicker.C:
-			consumer.lc.Debugf("Renewing the subscription from '%s' for resource '%s'", consumer.SubscriptionAddress, consumer.Name)
-			renewRequest := consumer.createRawRequest()
-			renewRequestData, err := xml.Marshal(renewRequest)
-			if err != nil {
-				consumer.lc.Errorf("Failed to marshal subscription request for resource '%s', %v", consumer.Name, err)
-				return
+		case reply := <-consumer.resubscribeRequests:
+			if consumer.onvifClient.inMaintenance() {
+				// the subscription is re-created when the maintenance window ends
+				reply <- nil
+				continue
 			}
-
-			servResp, err := consumer.onvifClient.onvifDevice.SendSoap(consumer.SubscriptionAddress, string(renewRequestData))
-			if err != nil {
-				consumer.lc.Warnf("Failed to send the renew request from '%s' for resource '%s', %v. The pull point expired or dropped, try to create a new one.", consumer.SubscriptionAddress, consumer.Name, err)
-				err = consumer.subscribe()
-				if err != nil {
-					consumer.lc.Errorf("Failed to subscribe again for resource '%s', %v", consumer.Name, err)
-					return
+			edgexErr := consumer.resubscribe()
+			reply <- edgexErr
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
-			} else if servResp.StatusCode >= http.StatusBadRequest {
-				response, err := renewResponse(servResp)
-				if err != nil {
-					consumer.lc.Errorf("Failed to parse response for '%s', %v", consumer.Name, err)
-					return
+			}
+			if edgexErr == nil {
+				attempt = 0
+				consumer.health.setState(SubscriptionActive)
+				timer.Reset(consumer.nextDelay(time.Now()))
+				continue
+			}
+			consumer.health.failed(edgexErr)
+			attempt++
+			delay := retryBackoff(attempt)
+			consumer.lc.Errorf("Failed to subscribe again for resource '%s', retrying in %v, %v", consumer.Name, delay, edgexErr)
+			timer.Reset(delay)
+		case <-timer.C:
+			now := time.Now()
+			if !consumer.request().autoRenew() {
+				if delay := consumer.nextDelay(now); delay > 0 {
+					timer.Reset(delay)
+					continue
 				}
-				consumer.lc.Warnf("Failed to renew the subscription from '%s' for resource '%s', status code: %s, err: %v. The pull point expired or dropped, try to create a new one.", consumer.SubscriptionAddress, consumer.Name, response.Body.Fault.String())
-				err = consumer.subscribe()
-				if err != nil {
-					consumer.lc.Errorf("Failed to subscribe again for resource '%s', %v", consumer.Name, err)
-					return
+				consumer.lc.Infof("The subscription '%s' has terminated", consumer.Name)
+				return
+			}
+			if delay := consumer.nextDelay(now); attempt == 0 && delay > minRenewInterval {
+				// the subscription was re-created since the timer was set
+				timer.Reset(delay)
+				continue
+			}
+			if consumer.onvifClient.inMaintenance() {
+				// the subscription is re-created when the maintenance window ends
+				consumer.lc.Debugf("Skip renewing the subscription for resource '%s' during the maintenance window", consumer.Name)
+				consumer.health.setState(SubscriptionPaused)
+				timer.Reset(maintenancePollInterval)
+				continue
+			}
+
+			if attempt == 0 {
+				consumer.lc.Debugf("Renewing the subscription from '%s' for resource '%s'", consumer.address(), consumer.Name)
+				edgexErr := consumer.renew()
+				if edgexErr == nil {
+					consumer.health.setState(SubscriptionActive)
+					timer.Reset(consumer.nextDelay(time.Now()))
+					continue
 				}
+				consumer.health.failed(edgexErr)
+				consumer.lc.Warnf("Failed to renew the subscription for resource '%s', %v. The subscription expired or dropped, try to create a new one.", consumer.Name, edgexErr)
+			}
+
+			edgexErr := consumer.resubscribe()
+			if edgexErr == nil {
+				attempt = 0
+				consumer.health.setState(SubscriptionActive)
+				timer.Reset(consumer.nextDelay(time.Now()))
+				continue
 			}
+			consumer.health.failed(edgexErr)
+			attempt++
+			delay := retryBackoff(attempt)
+			consumer.lc.Errorf("Failed to subscribe again for resource '%s', retrying in %v, %v", consumer.Name, delay, edgexErr)
+			timer.Reset(delay)
 		}
 	}
 }
 
+// nextDelay returns the delay until the subscription should be renewed, or until it terminates if AutoRenew is disabled
+func (consumer *Consumer) nextDelay(now time.Time) time.Duration {
+	deadline := consumer.deadline()
+	if consumer.request().autoRenew() {
+		return renewDelay(now, deadline)
+	}
+	return deadline.Sub(now)
+}
+
+func (consumer *Consumer) request() *SubscriptionRequest {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	return consumer.subscriptionRequest
+}
+
+func (consumer *Consumer) address() string {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	return consumer.SubscriptionAddress
+}
+
+func (consumer *Consumer) deadline() time.Time {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	return consumer.terminationDeadline
+}
+
+// requestedLifetime returns the remaining lifetime requested by the InitialTerminationTime of the request
+func (consumer *Consumer) requestedLifetime() time.Duration {
+	duration, _ := consumer.request().lifetime(time.Now())
+	return duration
+}
+
 func (consumer *Consumer) subscribe() errors.EdgeX {
 	subscribe := consumer.subscribeRequest()
 	subscribeData, err := json.Marshal(subscribe)
@@ -103,63 +203,128 @@ func (consumer *Consumer) subscribe() errors.EdgeX {
 	functionName := onvif.Subscribe
 	respContent, edgexErr := consumer.onvifClient.callOnvifFunction(serviceName, functionName, subscribeData)
 	if edgexErr != nil {
-		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to subscribe again for resource '%s', %v", consumer.Name, err), edgexErr)
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to subscribe for resource '%s'", consumer.Name), edgexErr)
 	}
 	subscribeResponse, ok := respContent.(*event.SubscribeResponse)
 	if !ok {
-		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid SubscribeResponse of type %T for the camera %s", respContent, consumer.onvifClient.DeviceName), nil)
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid SubscribeResponse of type %T for the camera %s", respContent, consumer.onvifClient.deviceName()), nil)
+	}
+
+	var currentTime, terminationTime string
+	if subscribeResponse.CurrentTime != nil {
+		currentTime = string(*subscribeResponse.CurrentTime)
 	}
+	if subscribeResponse.TerminationTime != nil {
+		terminationTime = string(*subscribeResponse.TerminationTime)
+	}
+	now := time.Now()
+	consumer.onvifClient.clock.update(currentTime, now)
+	deadline := terminationDeadline(now, currentTime, terminationTime, consumer.requestedLifetime())
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
 	consumer.SubscriptionAddress = fmt.Sprint(subscribeResponse.SubscriptionReference.Address)
+	consumer.terminationDeadline = deadline
 	return nil
 }
 
-func (consumer *Consumer) createRawRequest() *event.Renew {
-	terminationTime := xsd.String(*consumer.subscriptionRequest.InitialTerminationTime)
-	return &event.Renew{
-		TerminationTime: terminationTime,
+// requestResubscribe hands a request to re-create the subscription to the renew loop. If wait is true, it waits for
+// the subscription to be re-created and returns the result, otherwise the request is dropped if one is already pending.
+func (consumer *Consumer) requestResubscribe(wait bool) errors.EdgeX {
+	reply := make(chan errors.EdgeX, 1)
+	if !wait {
+		select {
+		case consumer.resubscribeRequests <- reply:
+		default:
+			// a request is already pending
+		}
+		return nil
+	}
+	select {
+	case consumer.resubscribeRequests <- reply:
+	case <-consumer.done:
+		// the subscription has already terminated
+		return nil
+	}
+	select {
+	case edgexErr := <-reply:
+		return edgexErr
+	case <-consumer.done:
+		return nil
 	}
 }
 
-func renewResponse(servResp *http.Response) (*gosoap.SOAPEnvelope, errors.EdgeX) {
-	defer servResp.Body.Close()
+// resubscribe replaces the subscription. The previous subscription is unsubscribed first, so that the camera does not
+// keep sending notifications for it until it terminates. It must only be called by the renew loop, see
+// requestResubscribe.
+func (consumer *Consumer) resubscribe() errors.EdgeX {
+	if edgexErr := consumer.unsubscribe(); edgexErr != nil {
+		consumer.lc.Debugf("Failed to unsubscribe the previous subscription for resource '%s', %v", consumer.Name, edgexErr)
+	}
+	if edgexErr := consumer.subscribe(); edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	consumer.synchronize()
+	return nil
+}
 
-	rsp, err := io.ReadAll(servResp.Body)
-	if err != nil {
-		return nil, errors.NewCommonEdgeXWrapper(err)
+// setSynchronizationPoint requests the subscription to send the current state of the properties which match its filter.
+// The consumer must be registered by the manager first, otherwise the notifications of the camera are rejected.
+func (consumer *Consumer) setSynchronizationPoint() errors.EdgeX {
+	if edgexErr := sendSetSynchronizationPoint(consumer.onvifClient.device(), consumer.address()); edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to set the synchronization point of '%s'", consumer.Name), edgexErr)
 	}
-	response := &event.RenewResponse{}
-	responseEnvelope := gosoap.NewSOAPEnvelope(response)
-	err = xml.Unmarshal(rsp, responseEnvelope)
-	if err != nil {
-		return nil, errors.NewCommonEdgeXWrapper(err)
+	return nil
+}
+
+// synchronize requests the initial state of a new subscription. Not every camera supports it, so a failure is only logged.
+func (consumer *Consumer) synchronize() {
+	if edgexErr := consumer.setSynchronizationPoint(); edgexErr != nil {
+		consumer.lc.Warnf("Failed to request the initial state for resource '%s', %v", consumer.Name, edgexErr)
 	}
-	return responseEnvelope, nil
 }
 
-func (consumer *Consumer) subscribeRequest() *event.Subscribe {
-	filter := &event.FilterType{}
-	if *consumer.subscriptionRequest.TopicFilter != "" {
-		filter.TopicExpression = &event.TopicExpressionType{TopicKinds: xsd.String(*consumer.subscriptionRequest.TopicFilter)}
+func (consumer *Consumer) renew() errors.EdgeX {
+	currentTime, terminationTime, edgexErr := sendRenew(consumer.onvifClient.device(), consumer.address(), consumer.request().terminationTime(time.Now()))
+	if edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
 	}
-	if *consumer.subscriptionRequest.MessageContentFilter != "" {
-		filter.MessageContent = &event.QueryExpressionType{MessageKind: xsd.String(*consumer.subscriptionRequest.MessageContentFilter)}
+	now := time.Now()
+	consumer.onvifClient.clock.update(currentTime, now)
+	deadline := terminationDeadline(now, currentTime, terminationTime, consumer.requestedLifetime())
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.terminationDeadline = deadline
+	return nil
+}
+
+func (consumer *Consumer) unsubscribe() errors.EdgeX {
+	address := consumer.address()
+	if edgexErr := sendUnsubscribe(consumer.onvifClient.device(), address); edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to unsubscribe '%s'", consumer.Name), edgexErr)
 	}
-	InitialTerminationTime := xsd.String(*consumer.subscriptionRequest.InitialTerminationTime)
-	subscriptionPolicy := xsd.String(*consumer.subscriptionRequest.SubscriptionPolicy)
+	consumer.lc.Debugf("Unsubscribe the subscription '%s' from %s", consumer.Name, address)
+	return nil
+}
+
+func (consumer *Consumer) subscribeRequest() *event.Subscribe {
+	request := consumer.request()
+	InitialTerminationTime := xsd.String(request.terminationTime(time.Now()))
 
-	consumer.onvifClient.driver.configMu.RLock()
-	baseNotificationURL := consumer.onvifClient.driver.config.AppCustom.BaseNotificationURL
-	consumer.onvifClient.driver.configMu.RUnlock()
+	baseNotificationURL := consumer.onvifClient.driver.baseNotificationURL(consumer.onvifClient.device().GetDeviceParams().Xaddr)
 
-	address := fmt.Sprintf("%s%s/%s/%s/%s",
-		baseNotificationURL, common.ApiBase, OnvifEventRestPath, consumer.onvifClient.DeviceName, consumer.onvifClient.CameraEventResource.Name)
+	query := url.Values{}
+	query.Set(subscriptionQueryParam, consumer.Name)
+	query.Set(tokenQueryParam, consumer.token)
+	address := fmt.Sprintf("%s%s/%s/%s/%s?%s",
+		baseNotificationURL, common.ApiBase, OnvifEventRestPath, consumer.onvifClient.deviceName(), consumer.onvifClient.CameraEventResource.Name,
+		query.Encode())
 	consumerReference := &event.EndpointReferenceType{
 		Address: event.AttributedURIType(address),
 	}
 	return &event.Subscribe{
 		ConsumerReference:  consumerReference,
-		Filter:             filter,
+		Filter:             eventFilterType(request.TopicFilter, request.MessageContentFilter),
 		TerminationTime:    &InitialTerminationTime,
-		SubscriptionPolicy: &subscriptionPolicy,
+		SubscriptionPolicy: request.subscriptionPolicy(),
 	}
 }
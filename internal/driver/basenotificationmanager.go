@@ -7,8 +7,10 @@
 package driver
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
@@ -19,6 +21,8 @@ type BaseNotificationManager struct {
 	lc        logger.LoggingClient
 	lock      *sync.RWMutex
 	consumers map[string]*Consumer
+	// suspended holds the subscription requests of the consumers stopped by Suspend
+	suspended map[string]*SubscriptionRequest
 }
 
 // NewBaseNotificationManager create the new BaseNotificationManager entity
@@ -26,39 +30,51 @@ func NewBaseNotificationManager(lc logger.LoggingClient) *BaseNotificationManage
 	return &BaseNotificationManager{
 		lc:        lc,
 		consumers: make(map[string]*Consumer),
+		suspended: make(map[string]*SubscriptionRequest),
 		lock:      new(sync.RWMutex),
 	}
 }
 
 // NewConsumer create the new NewConsumer entity and send the subscription request to the camera
-func (manager *BaseNotificationManager) NewConsumer(onvifClient *OnvifClient, resourceName string, attributes map[string]interface{}, data []byte) errors.EdgeX {
-	_, ok := manager.consumers[resourceName]
-	if ok {
-		manager.lc.Warnf("'%s' resource's base notification consumer already exists, skip adding new subscriber.", resourceName)
-		return nil
-	}
-
-	request, edgexErr := newSubscriptionRequest(attributes, data)
+func (manager *BaseNotificationManager) NewConsumer(onvifClient *OnvifClient, name string, request *SubscriptionRequest) errors.EdgeX {
+	release, edgexErr := onvifClient.reserveSubscription(name)
 	if edgexErr != nil {
 		return errors.NewCommonEdgeXWrapper(edgexErr)
 	}
+	defer release()
+	return manager.createConsumer(onvifClient, name, request)
+}
 
+// createConsumer creates the consumer of a name reserved by reserveSubscription
+func (manager *BaseNotificationManager) createConsumer(onvifClient *OnvifClient, name string, request *SubscriptionRequest) errors.EdgeX {
+	token, err := newSubscriptionToken()
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to generate the subscription token", err)
+	}
 	consumer := &Consumer{
-		Name:                resourceName,
+		Name:                name,
+		token:               token,
 		lc:                  onvifClient.lc,
 		onvifClient:         onvifClient,
 		manager:             manager,
 		subscriptionRequest: request,
 		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+		resubscribeRequests: make(chan chan errors.EdgeX, 1),
 	}
-	edgexErr = consumer.subscribe()
+	// the consumer is registered first, so that the notifications which the camera sends as soon as it is subscribed
+	// are not rejected
+	manager.addConsumer(consumer)
+	edgexErr := consumer.subscribe()
 	if edgexErr != nil {
+		manager.removeConsumer(consumer)
+		// the renew loop never runs, so the consumer is marked as terminated for stopConsumer
+		close(consumer.done)
 		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to create the BaseNotification for resource '%s'", consumer.Name), edgexErr)
 	}
-	manager.addConsumer(consumer)
-	if *request.AutoRenew {
-		go consumer.StartRenewLoop()
-	}
+	consumer.synchronize()
+	// the loop also runs without AutoRenew, to remove the consumer when the subscription terminates
+	go consumer.StartRenewLoop()
 	return nil
 }
 
@@ -68,16 +84,206 @@ func (manager *BaseNotificationManager) addConsumer(consumer *Consumer) {
 	manager.consumers[consumer.Name] = consumer
 }
 
+// health returns the health of the subscriptions by resource name
+func (manager *BaseNotificationManager) health() map[string]SubscriptionHealth {
+	result := make(map[string]SubscriptionHealth)
+	for _, consumer := range manager.listConsumers() {
+		result[consumer.Name] = consumer.health.snapshot()
+	}
+	return result
+}
+
+// authenticate returns the consumer of the named subscription if the token matches its secret token
+func (manager *BaseNotificationManager) authenticate(name, token string) (*Consumer, bool) {
+	manager.lock.RLock()
+	consumer, ok := manager.consumers[name]
+	manager.lock.RUnlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(consumer.token), []byte(token)) != 1 {
+		return nil, false
+	}
+	return consumer, true
+}
+
+func (manager *BaseNotificationManager) listConsumers() []*Consumer {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	consumers := make([]*Consumer, 0, len(manager.consumers))
+	for _, consumer := range manager.consumers {
+		consumers = append(consumers, consumer)
+	}
+	return consumers
+}
+
 func (manager *BaseNotificationManager) removeConsumer(consumer *Consumer) {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
 	delete(manager.consumers, consumer.Name)
 }
 
+// ResubscribeAll replaces the subscription of every consumer, which is required when the consumer reference changes.
+// The subscriptions are re-created by the renew loops, which retry with backoff if they can not be re-created now.
+func (manager *BaseNotificationManager) ResubscribeAll() {
+	consumers := manager.listConsumers()
+	for _, consumer := range consumers {
+		_ = consumer.requestResubscribe(false)
+	}
+}
+
 func (manager *BaseNotificationManager) UnsubscribeAll() {
-	for _, consumer := range manager.consumers {
-		// consumer will stop to renew the subscription when receiving the Stopped signal
-		consumer.Stopped <- true
+	consumers := manager.listConsumers()
+	for _, consumer := range consumers {
+		manager.stopConsumer(consumer)
 	}
 	manager.lc.Debug("Unsubscribe all subscriptions")
 }
+
+// Suspend stops all subscriptions and keeps their requests, so that they can be re-created by Resume
+func (manager *BaseNotificationManager) Suspend() {
+	consumers := manager.listConsumers()
+	for _, consumer := range consumers {
+		manager.suspend(consumer.Name, consumer.request())
+	}
+	manager.UnsubscribeAll()
+}
+
+// Unsubscribe stops the named subscription, and returns false if there is no active or suspended subscription
+// with the name
+func (manager *BaseNotificationManager) Unsubscribe(name string) bool {
+	manager.lock.Lock()
+	consumer, active := manager.consumers[name]
+	_, suspended := manager.suspended[name]
+	delete(manager.suspended, name)
+	manager.lock.Unlock()
+
+	if active {
+		manager.stopConsumer(consumer)
+	}
+	return active || suspended
+}
+
+// SetSynchronizationPoint requests the current state from the named active subscription, or from every active
+// subscription if the name is empty. Returns false if there is no matching active subscription.
+func (manager *BaseNotificationManager) SetSynchronizationPoint(name string) (bool, errors.EdgeX) {
+	found := false
+	for _, consumer := range manager.listConsumers() {
+		if name != "" && consumer.Name != name {
+			continue
+		}
+		found = true
+		if edgexErr := consumer.setSynchronizationPoint(); edgexErr != nil {
+			return true, errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+	}
+	return found, nil
+}
+
+// stopConsumer stops the renew loop of the consumer, which unsubscribes from the camera
+func (manager *BaseNotificationManager) stopConsumer(consumer *Consumer) {
+	select {
+	case consumer.Stopped <- true:
+		<-consumer.done
+	case <-consumer.done:
+		// the subscription has already terminated
+	}
+}
+
+// UpdateRequest replaces the request of the named subscription, and re-creates the subscription on the camera.
+// Returns false if there is no active or suspended subscription with the name.
+func (manager *BaseNotificationManager) UpdateRequest(name string, request *SubscriptionRequest) (bool, errors.EdgeX) {
+	manager.lock.Lock()
+	consumer, active := manager.consumers[name]
+	_, suspended := manager.suspended[name]
+	if suspended {
+		manager.suspended[name] = request
+	}
+	manager.lock.Unlock()
+
+	if !active {
+		return suspended, nil
+	}
+	consumer.mu.Lock()
+	consumer.subscriptionRequest = request
+	consumer.mu.Unlock()
+	if edgexErr := consumer.requestResubscribe(true); edgexErr != nil {
+		return true, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	return true, nil
+}
+
+// request returns the request of the named active or suspended subscription
+func (manager *BaseNotificationManager) request(name string) (*SubscriptionRequest, bool) {
+	manager.lock.RLock()
+	consumer, active := manager.consumers[name]
+	request, suspended := manager.suspended[name]
+	manager.lock.RUnlock()
+	if active {
+		return consumer.request(), true
+	}
+	return request, suspended
+}
+
+// subscriptions returns the active and suspended subscriptions
+func (manager *BaseNotificationManager) subscriptions() []SubscriptionInfo {
+	var result []SubscriptionInfo
+	for _, consumer := range manager.listConsumers() {
+		result = append(result, newSubscriptionInfo(consumer.Name, BaseNotification, consumer.request(), consumer.address(), consumer.deadline(), consumer.health.snapshot()))
+	}
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	for name, request := range manager.suspended {
+		result = append(result, newSubscriptionInfo(name, BaseNotification, request, "", time.Time{}, SubscriptionHealth{State: SubscriptionSuspended}))
+	}
+	return result
+}
+
+// hasSubscription returns true if there is an active or suspended subscription with the name
+func (manager *BaseNotificationManager) hasSubscription(name string) bool {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	_, active := manager.consumers[name]
+	_, suspended := manager.suspended[name]
+	return active || suspended
+}
+
+// suspend keeps the request of a subscription, which is created when Resume is called
+func (manager *BaseNotificationManager) suspend(name string, request *SubscriptionRequest) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.suspended[name] = request
+}
+
+// Resume re-creates the subscriptions stopped by Suspend. The subscriptions which can not be re-created stay
+// suspended, so that they can be resumed again.
+func (manager *BaseNotificationManager) Resume(onvifClient *OnvifClient) {
+	for _, name := range manager.suspendedNames() {
+		request, release, ok := onvifClient.reserveSuspendedSubscription(name, manager.takeSuspended)
+		if !ok {
+			continue
+		}
+		if edgexErr := manager.createConsumer(onvifClient, name, request); edgexErr != nil {
+			manager.lc.Errorf("Failed to resume the BaseNotification subscription for resource '%s', %v", name, edgexErr)
+			manager.suspend(name, request)
+		}
+		release()
+	}
+}
+
+// suspendedNames returns the names of the suspended subscriptions
+func (manager *BaseNotificationManager) suspendedNames() []string {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	names := make([]string, 0, len(manager.suspended))
+	for name := range manager.suspended {
+		names = append(names, name)
+	}
+	return names
+}
+
+// takeSuspended removes the named subscription from the suspended subscriptions and returns its request
+func (manager *BaseNotificationManager) takeSuspended(name string) (*SubscriptionRequest, bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	request, ok := manager.suspended[name]
+	delete(manager.suspended, name)
+	return request, ok
+}
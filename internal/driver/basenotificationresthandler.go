@@ -8,40 +8,55 @@
 package driver
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/edgexfoundry/device-sdk-go/v3/pkg/interfaces"
-	"github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
-	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
 
-	"github.com/IOTechSystems/onvif/event"
-	"github.com/IOTechSystems/onvif/gosoap"
-
 	"github.com/labstack/echo/v4"
 )
 
 const (
 	OnvifEventRestPath = "onvifevent"
 	apiResourceRoute   = common.ApiBase + "/" + OnvifEventRestPath + "/:deviceName/:resourceName"
+	// subscriptionQueryParam is the query parameter of the consumer reference, which identifies the subscription
+	subscriptionQueryParam = "subscription"
+	// tokenQueryParam is the query parameter of the consumer reference, which holds the secret token of the subscription
+	tokenQueryParam = "token"
+
+	soapContentType   = "application/soap+xml; charset=utf-8"
+	soapFaultTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope">
+  <env:Body>
+    <env:Fault>
+      <env:Code><env:Value>%s</env:Value></env:Code>
+      <env:Reason><env:Text xml:lang="en">%s</env:Text></env:Reason>
+    </env:Fault>
+  </env:Body>
+</env:Envelope>`
 )
 
 // RestNotificationHandler handle the notification from the camera and send to async value channel
 type RestNotificationHandler struct {
 	sdkService interfaces.DeviceServiceSDK
 	lc         logger.LoggingClient
+	driver     *Driver
 }
 
 // NewRestNotificationHandler create a new RestNotificationHandler entity
-func NewRestNotificationHandler(service interfaces.DeviceServiceSDK) *RestNotificationHandler {
+func NewRestNotificationHandler(service interfaces.DeviceServiceSDK, driver *Driver) *RestNotificationHandler {
 	handler := RestNotificationHandler{
 		sdkService: service,
 		lc:         service.LoggingClient(),
+		driver:     driver,
 	}
 	return &handler
 }
@@ -56,54 +71,104 @@ func (handler RestNotificationHandler) AddRoute() errors.EdgeX {
 	return nil
 }
 
-// processAsyncRequest receives notification from Onvif camera and sends to the async reading channel
+// processAsyncRequest receives notification from Onvif camera and sends to the async reading channel. The notification
+// is only accepted if it is sent from the address of the camera, for an active subscription with a matching token.
 func (handler RestNotificationHandler) processAsyncRequest(c echo.Context) error {
 	deviceName := c.Param(common.DeviceName)
 	resourceName := c.Param(common.ResourceName)
 
 	handler.lc.Debugf("Received POST for Device=%s Resource=%s", deviceName, resourceName)
 
-	_, err := handler.sdkService.GetDeviceByName(deviceName)
+	device, err := handler.sdkService.GetDeviceByName(deviceName)
 	if err != nil {
 		handler.lc.Errorf("Incoming reading ignored. Device '%s' not found", deviceName)
-		return c.String(http.StatusBadRequest, fmt.Sprintf("Device '%s' not found", deviceName))
+		return soapFault(c, http.StatusNotFound, fmt.Sprintf("Device '%s' not found", deviceName))
+	}
+
+	handler.driver.configMu.RLock()
+	verifySource := handler.driver.config.AppCustom.VerifyNotificationSource
+	handler.driver.configMu.RUnlock()
+	deviceAddress := protocolValue(device.Protocols[OnvifProtocol], Address)
+	if verifySource && !verifyNotificationSource(c.Request().RemoteAddr, deviceAddress) {
+		handler.lc.Warnf("Incoming reading ignored. The notification for Device=%s was sent from %s instead of the device address %s", deviceName, c.Request().RemoteAddr, deviceAddress)
+		return soapFault(c, http.StatusForbidden, "The notification source does not match the device address")
+	}
+
+	onvifClient, ok := handler.driver.getOnvifClient(deviceName)
+	if !ok || onvifClient.baseNotificationManager == nil {
+		handler.lc.Errorf("Incoming reading ignored. Device '%s' has no onvif client", deviceName)
+		return soapFault(c, http.StatusNotFound, fmt.Sprintf("Device '%s' not found", deviceName))
+	}
+	subscriptionName := c.QueryParam(subscriptionQueryParam)
+	consumer, ok := onvifClient.baseNotificationManager.authenticate(subscriptionName, c.QueryParam(tokenQueryParam))
+	if !ok {
+		handler.lc.Warnf("Incoming reading ignored. Unknown subscription '%s' for Device=%s", subscriptionName, deviceName)
+		return soapFault(c, http.StatusForbidden, "Unknown subscription")
 	}
 
 	deviceResource, ok := handler.sdkService.DeviceResource(deviceName, resourceName)
 	if !ok {
 		handler.lc.Errorf("Incoming reading ignored. Resource '%s' not found", resourceName)
-		return c.String(http.StatusBadRequest, fmt.Sprintf("Resource '%s' not found", resourceName))
+		return soapFault(c, http.StatusNotFound, fmt.Sprintf("Resource '%s' not found", resourceName))
 	}
 
 	data, err := handler.readBody(c.Request())
 	if err != nil {
 		handler.lc.Errorf("Incoming reading ignored. Unable to read request body: %s", err.Error())
-		return c.String(http.StatusBadRequest, err.Error())
+		return soapFault(c, http.StatusBadRequest, err.Error())
 	}
 
-	notify := &event.Notify{}
-	responseEnvelope := gosoap.NewSOAPEnvelope(notify)
-	err = xml.Unmarshal(data, responseEnvelope)
-	if err != nil {
-		handler.lc.Errorf("Failed to create to the subscribe response for Device=%s Resource=%s, %s", deviceName, resourceName, err.Error())
-		return c.String(http.StatusBadRequest, err.Error())
+	readings, edgexErr := parseEventReadings(data)
+	if edgexErr != nil {
+		handler.lc.Errorf("Failed to parse the notification for Device=%s Resource=%s, %s", deviceName, resourceName, edgexErr.Error())
+		return soapFault(c, http.StatusBadRequest, edgexErr.Error())
 	}
 
-	cv, err := sdkModel.NewCommandValue(deviceResource.Name, common.ValueTypeObject, notify)
+	handler.lc.Debugf("Incoming readings received: Device=%s Resource=%s Count=%d", deviceName, resourceName, len(readings))
+	consumer.health.succeeded(len(readings), time.Now())
+
+	handler.driver.publishEventReadings(deviceName, consumer.request().eventRoute(deviceResource.Name), readings)
+
+	// Notify is a one-way operation, so the request is acknowledged without a response envelope
+	return c.NoContent(http.StatusAccepted)
+}
+
+// verifyNotificationSource returns true if the remote address of the request is an address of the device
+func verifyNotificationSource(remoteAddr, deviceAddress string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
-		handler.lc.Errorf("Failed to create to the commandValue for Device=%s Resource=%s, %s", deviceName, resourceName, err.Error())
-		return c.String(http.StatusInternalServerError, err.Error())
+		host = remoteAddr
 	}
-	asyncValues := &models.AsyncValues{
-		DeviceName:    deviceName,
-		CommandValues: []*models.CommandValue{cv},
+	source := net.ParseIP(host)
+	if source == nil || deviceAddress == "" {
+		return false
 	}
+	if ip := net.ParseIP(deviceAddress); ip != nil {
+		return ip.Equal(source)
+	}
+	addrs, err := net.LookupHost(deviceAddress)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.Equal(source) {
+			return true
+		}
+	}
+	return false
+}
 
-	handler.lc.Debugf("Incoming reading received: Device=%s Resource=%s", deviceName, resourceName)
-
-	handler.sdkService.AsyncValuesChannel() <- asyncValues
-
-	return nil
+// soapFault responds with a SOAP 1.2 fault. Errors of the device service are reported as Receiver faults, and
+// invalid or rejected notifications as Sender faults.
+func soapFault(c echo.Context, status int, reason string) error {
+	code := "env:Sender"
+	if status >= http.StatusInternalServerError {
+		code = "env:Receiver"
+	}
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(reason))
+	body := fmt.Sprintf(soapFaultTemplate, code, escaped.String())
+	return c.Blob(status, soapContentType, []byte(body))
 }
 
 func (handler RestNotificationHandler) readBody(request *http.Request) ([]byte, error) {
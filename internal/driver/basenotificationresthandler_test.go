@@ -0,0 +1,249 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testNotifyMessage = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <env:Body>
+    <wsnt:Notify>
+      <wsnt:NotificationMessage>
+        <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:VideoSource/MotionAlarm</wsnt:Topic>
+        <wsnt:Message>
+          <tt:Message UtcTime="2023-09-21T08:00:04Z" PropertyOperation="Changed">
+            <tt:Data>
+              <tt:SimpleItem Name="State" Value="true"/>
+            </tt:Data>
+          </tt:Message>
+        </wsnt:Message>
+      </wsnt:NotificationMessage>
+    </wsnt:Notify>
+  </env:Body>
+</env:Envelope>`
+
+func TestRestNotificationHandler_processAsyncRequest(t *testing.T) {
+	const token = "0123456789abcdef"
+	tests := []struct {
+		name           string
+		deviceName     string
+		remoteAddr     string
+		subscription   string
+		token          string
+		body           string
+		verifySource   bool
+		expectedStatus int
+	}{
+		{
+			name:           "valid notification",
+			deviceName:     testDeviceName,
+			remoteAddr:     "192.168.1.10:41234",
+			subscription:   CameraEvent,
+			token:          token,
+			body:           testNotifyMessage,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "invalid token",
+			deviceName:     testDeviceName,
+			remoteAddr:     "192.168.1.10:41234",
+			subscription:   CameraEvent,
+			token:          "invalid",
+			body:           testNotifyMessage,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unknown subscription",
+			deviceName:     testDeviceName,
+			remoteAddr:     "192.168.1.10:41234",
+			subscription:   "unknown",
+			token:          token,
+			body:           testNotifyMessage,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "different source address",
+			deviceName:     testDeviceName,
+			remoteAddr:     "192.168.1.66:41234",
+			subscription:   CameraEvent,
+			token:          token,
+			body:           testNotifyMessage,
+			verifySource:   true,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			// the source address is replaced by the gateway of the Docker bridge network
+			name:           "different source address without verification",
+			deviceName:     testDeviceName,
+			remoteAddr:     "172.17.0.1:41234",
+			subscription:   CameraEvent,
+			token:          token,
+			body:           testNotifyMessage,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "unknown device",
+			deviceName:     "unknown",
+			remoteAddr:     "192.168.1.10:41234",
+			subscription:   CameraEvent,
+			token:          token,
+			body:           testNotifyMessage,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid message",
+			deviceName:     testDeviceName,
+			remoteAddr:     "192.168.1.10:41234",
+			subscription:   CameraEvent,
+			token:          token,
+			body:           "invalid",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			driver, mockService := createDriverWithMockService()
+			driver.config.AppCustom.VerifyNotificationSource = test.verifySource
+			client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+			client.baseNotificationManager = NewBaseNotificationManager(logger.NewMockClient())
+			resource := CameraEvent
+			client.baseNotificationManager.consumers[CameraEvent] = &Consumer{
+				Name:                CameraEvent,
+				token:               token,
+				subscriptionRequest: &SubscriptionRequest{Resource: &resource},
+			}
+			driver.onvifClients = map[string]*OnvifClient{testDeviceName: client}
+
+			device := createTestDevice()
+			device.Protocols[OnvifProtocol][Address] = "192.168.1.10"
+			mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+			mockService.On("GetDeviceByName", "unknown").Return(models.Device{}, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, "not found", nil))
+			mockService.On("DeviceResource", testDeviceName, CameraEvent).Return(models.DeviceResource{Name: CameraEvent}, true)
+			asyncCh := make(chan *sdkModel.AsyncValues, 1)
+			mockService.On("AsyncValuesChannel").Return(asyncCh).Maybe()
+
+			target := fmt.Sprintf("%s/%s/%s/%s?%s=%s&%s=%s", common.ApiBase, OnvifEventRestPath, test.deviceName, CameraEvent,
+				subscriptionQueryParam, test.subscription, tokenQueryParam, test.token)
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(test.body))
+			req.RemoteAddr = test.remoteAddr
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.SetParamNames(common.DeviceName, common.ResourceName)
+			c.SetParamValues(test.deviceName, CameraEvent)
+
+			handler := NewRestNotificationHandler(mockService, driver)
+			require.NoError(t, handler.processAsyncRequest(c))
+			assert.Equal(t, test.expectedStatus, rec.Code)
+
+			if test.expectedStatus != http.StatusAccepted {
+				assert.Contains(t, rec.Body.String(), "env:Fault")
+				assert.Empty(t, asyncCh)
+				return
+			}
+			require.Len(t, asyncCh, 1)
+			assert.Equal(t, uint64(1), client.baseNotificationManager.consumers[CameraEvent].health.snapshot().MessageCount)
+		})
+	}
+}
+
+func TestRestNotificationHandler_synchronizationPoint(t *testing.T) {
+	const token = "0123456789abcdef"
+	driver, mockService := createDriverWithMockService()
+	// the defaults of configuration.yaml
+	driver.config.AppCustom.SuppressDuplicateEvents = true
+	driver.config.AppCustom.EventTimeToleranceSeconds = 300
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.baseNotificationManager = NewBaseNotificationManager(logger.NewMockClient())
+	resource := CameraEvent
+	client.baseNotificationManager.addConsumer(&Consumer{
+		Name:                CameraEvent,
+		lc:                  client.lc,
+		onvifClient:         client,
+		manager:             client.baseNotificationManager,
+		token:               token,
+		subscriptionRequest: &SubscriptionRequest{Resource: &resource},
+		SubscriptionAddress: "http://192.168.1.10/onvif/Subscription?Idx=0",
+		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+	})
+	driver.onvifClients = map[string]*OnvifClient{testDeviceName: client}
+
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][Address] = "192.168.1.10"
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	mockService.On("DeviceResource", testDeviceName, CameraEvent).Return(models.DeviceResource{Name: CameraEvent}, true)
+	asyncCh := make(chan *sdkModel.AsyncValues, 10)
+	mockService.On("AsyncValuesChannel").Return(asyncCh)
+	handler := NewRestNotificationHandler(mockService, driver)
+	notify := func(body string) {
+		target := fmt.Sprintf("%s/%s/%s/%s?%s=%s&%s=%s", common.ApiBase, OnvifEventRestPath, testDeviceName, CameraEvent,
+			subscriptionQueryParam, CameraEvent, tokenQueryParam, token)
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.RemoteAddr = "192.168.1.10:41234"
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames(common.DeviceName, common.ResourceName)
+		c.SetParamValues(testDeviceName, CameraEvent)
+		require.NoError(t, handler.processAsyncRequest(c))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+	}
+
+	// the state table holds the current state of the topic, so that a repeated change is a duplicate
+	notify(testNotifyMessage)
+	require.Len(t, asyncCh, 1)
+	<-asyncCh
+	notify(testNotifyMessage)
+	assert.Empty(t, asyncCh)
+
+	isSynchronizationPoint := mock.MatchedBy(func(body string) bool { return strings.Contains(body, "tev:SetSynchronizationPoint") })
+	mockDevice.On("SendSoap", "http://192.168.1.10/onvif/Subscription?Idx=0", isSynchronizationPoint).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil).Once()
+	require.NoError(t, client.setSynchronizationPoint([]byte(`{}`)))
+	mockDevice.AssertExpectations(t)
+
+	// the camera answers the synchronization point with the current state, which is published although it is unchanged
+	notify(strings.Replace(testNotifyMessage, `PropertyOperation="Changed"`, `PropertyOperation="Initialized"`, 1))
+	require.Len(t, asyncCh, 1)
+	values := <-asyncCh
+	require.Len(t, values.CommandValues, 1)
+	reading, ok := values.CommandValues[0].Value.(EventReading)
+	require.True(t, ok)
+	assert.Equal(t, PropertyInitialized, reading.PropertyOperation)
+	assert.Equal(t, "true", reading.Data["State"])
+}
+
+func TestVerifyNotificationSource(t *testing.T) {
+	assert.True(t, verifyNotificationSource("192.168.1.10:41234", "192.168.1.10"))
+	assert.True(t, verifyNotificationSource("127.0.0.1:41234", "localhost"))
+	assert.False(t, verifyNotificationSource("192.168.1.66:41234", "192.168.1.10"))
+	assert.False(t, verifyNotificationSource("192.168.1.10:41234", ""))
+	assert.False(t, verifyNotificationSource("invalid", "192.168.1.10"))
+}
+
+func TestNewDriver_verifyNotificationSource(t *testing.T) {
+	// the notification source is verified unless the configuration disables it
+	assert.True(t, NewDriver().config.AppCustom.VerifyNotificationSource)
+}
@@ -9,13 +9,13 @@ package driver
 
 import (
 	"fmt"
-	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
 	"net"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/IOTechSystems/onvif"
+	onvifdevice "github.com/IOTechSystems/onvif/device"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
 )
 
@@ -43,6 +43,14 @@ func (d *Driver) checkStatuses() {
 
 // checkStatusOfDevice checks the status of an individual device
 func (d *Driver) checkStatusOfDevice(device models.Device) {
+	if device.AdminState == models.Locked {
+		d.lc.Debugf("skip checking status of locked device %s", device.Name)
+		return
+	}
+	if onvifClient, ok := d.getOnvifClient(device.Name); ok && onvifClient.inMaintenance() {
+		d.lc.Debugf("skip checking status of device %s during its maintenance window", device.Name)
+		return
+	}
 	d.lc.Debugf("checking status of device %s", device.Name)
 
 	// if device is unknown, and missing a MAC Address, try and determine the MAC address via the endpoint reference
@@ -60,7 +68,7 @@ func (d *Driver) checkStatusOfDevice(device models.Device) {
 		}
 	}
 
-	status := d.testConnectionMethods(device)
+	status, devInfo := d.testConnectionMethods(device)
 	if statusChanged, updateDeviceStatusErr := d.updateDeviceStatus(device.Name, status); updateDeviceStatusErr != nil {
 		d.lc.Warnf("Could not update device status for device %s: %s", device.Name, updateDeviceStatusErr.Error())
 
@@ -72,45 +80,58 @@ func (d *Driver) checkStatusOfDevice(device models.Device) {
 					device.Name, refreshErr.Error())
 			}
 		}()
+	} else if status == Unreachable {
+		// the camera may have been assigned a new address by DHCP, so try to locate it without waiting
+		// for the next discovery
+		d.recoverDeviceAddress(device)
+	} else if status == UpWithAuth && devInfo != nil {
+		// the device information is only refreshed on status changes, so check whether the camera
+		// was reflashed, replaced or rebooted since the last status check
+		d.checkDeviceChanges(device.Name, devInfo)
+	}
+
+	if status == UpWithAuth {
+		d.reconcileDefaultSubscriptions(device.Name)
+		d.reconcileMetadataStream(device.Name)
 	}
 
 	d.lc.Debugf("device %s status is %s", device.Name, status)
 }
 
 // testConnectionMethods will try to determine the state using different device calls
-// and return the most accurate status
+// and return the most accurate status, along with the device information if the device is UpWithAuth
 // Higher degrees of connection are tested first, because if they
 // succeed, the lower levels of connection will too
-func (d *Driver) testConnectionMethods(device models.Device) (status string) {
+func (d *Driver) testConnectionMethods(device models.Device) (status string, devInfo *onvifdevice.GetDeviceInformationResponse) {
 	devClient, err := d.getOrCreateOnvifClient(device)
 	if err != nil {
 		d.lc.Warnf("Error getting onvif client for device %s", device.Name)
 		// if we do not have a valid onvif client, lets just tcp probe it
 		if d.tcpProbe(device) {
-			return Reachable
+			return Reachable, nil
 		}
-		return Unreachable
+		return Unreachable, nil
 	}
 
 	// sends GetDeviceInformation command to device (requires authentication)
-	_, edgexErr := devClient.callOnvifFunction(onvif.DeviceWebService, onvif.GetDeviceInformation, []byte{})
+	devInfo, edgexErr := devClient.getDeviceInformation(device)
 	if edgexErr == nil {
-		return UpWithAuth // we are authenticated
+		return UpWithAuth, devInfo // we are authenticated
 	}
 	d.lc.Debugf("%s command failed for device %s when using authentication: %s", onvif.GetDeviceInformation, device.Name, edgexErr.Message())
 
 	// sends GetSystemDateAndTime command to device (does not require authentication)
 	_, edgexErr = devClient.callOnvifFunction(onvif.DeviceWebService, onvif.GetSystemDateAndTime, []byte{})
 	if edgexErr == nil {
-		return UpWithoutAuth // non-authenticated onvif command is working
+		return UpWithoutAuth, nil // non-authenticated onvif command is working
 	}
 	d.lc.Debugf("%s command failed for device %s without using authentication: %s", onvif.GetSystemDateAndTime, device.Name, edgexErr.Message())
 
 	// onvif commands are not working, so let us probe it
 	if d.tcpProbe(device) {
-		return Reachable
+		return Reachable, nil
 	}
-	return Unreachable
+	return Unreachable, nil
 }
 
 // tcpProbe attempts to make a connection to a specific ip and port list to determine
@@ -138,10 +159,12 @@ func (d *Driver) updateDeviceStatus(deviceName string, status string) (bool, err
 	shouldUpdate := false
 
 	// lookup device from cache to ensure we are updating the latest version
-	device, err := d.sdkService.GetDeviceByName(deviceName)
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
 	if err != nil {
 		d.lc.Errorf("Unable to get device %s from cache while trying to update its status to %s. Error: %s",
-			device.Name, status, err.Error())
+			deviceName, status, err.Error())
 		return false, err
 	}
 
@@ -160,16 +183,14 @@ func (d *Driver) updateDeviceStatus(deviceName string, status string) (bool, err
 	}
 
 	if shouldUpdate {
-		return statusChanged, d.sdkService.PatchDevice(dtos.UpdateDevice{
-			Name:      &deviceName,
-			Protocols: dtos.FromProtocolModelsToDTOs(device.Protocols),
-		})
+		return statusChanged, d.patchDeviceProtocols(deviceName, device.Protocols)
 	}
 
 	return statusChanged, nil
 }
 
-// taskLoop manages all of our custom background tasks such as checking camera statuses at regular intervals
+// taskLoop manages all of our custom background tasks such as checking camera statuses and persisting the last
+// message times of the subscriptions at regular intervals
 func (d *Driver) taskLoop() {
 	d.configMu.RLock()
 	interval := d.config.AppCustom.CheckStatusInterval
@@ -191,6 +212,7 @@ func (d *Driver) taskLoop() {
 			return
 		case <-statusTicker.C:
 			d.checkStatuses() // checks the status of every device
+			d.saveAllLastMessageTimes()
 		}
 	}
 }
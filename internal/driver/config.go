@@ -24,6 +24,17 @@ type CustomConfig struct {
 	DiscoveryEthernetInterface string
 	// BaseNotificationURL indicates the device service network location
 	BaseNotificationURL string
+	// NotificationListenerPort indicates the port of the dedicated listener for camera notifications, or zero to
+	// receive the notifications on the REST API of the device service. When the listener is enabled, the notification
+	// URL sent to each camera is derived from the local address on the camera's subnet instead of BaseNotificationURL.
+	NotificationListenerPort int
+	// NotificationListenerInterface indicates the network interface the notification listener is bound to, or empty
+	// to listen on all interfaces.
+	NotificationListenerInterface string
+	// VerifyNotificationSource indicates if the notifications which are not sent from the address of their device are
+	// rejected, it is enabled by default. It should be disabled when the notifications pass through a NAT, such as the
+	// Docker bridge network, in which case the secret token of each subscription is the only check of the source.
+	VerifyNotificationSource bool
 
 	// DiscoveryMode indicates mode used to discovery devices on the network.
 	DiscoveryMode DiscoveryMode
@@ -41,6 +52,37 @@ type CustomConfig struct {
 	// CheckStatusInterval indicates the interval in seconds at which the device service will check device statuses
 	CheckStatusInterval int
 
+	// MaintenanceWindowSeconds indicates the default length of a camera's maintenance window, which is started when the
+	// device service reboots, resets or upgrades the camera, or by the SetMaintenanceMode command
+	MaintenanceWindowSeconds int
+
+	// EventDebounceWindows is a comma separated list of Topic=Milliseconds pairs. An event is dropped when the previous
+	// event of the same topic and source was published within the window. The topic * applies to all other topics.
+	EventDebounceWindows string
+	// SuppressDuplicateEvents indicates if property events which repeat the current state of their topic and source are dropped
+	SuppressDuplicateEvents bool
+	// MaxEventsPerMinute indicates the maximum number of events published per device, or zero for no limit
+	MaxEventsPerMinute int
+
+	// EventTimeToleranceSeconds indicates the maximum difference between the time of an event, corrected by the
+	// offset of the camera clock, and the time it was received. The readings of an event outside the tolerance are
+	// stamped with the received time and flagged with a tag. Zero disables the check.
+	EventTimeToleranceSeconds int
+
+	// AnalyticsFrameIntervalMillis indicates the minimum interval between two published analytics frames with objects
+	// of a video source of the metadata stream, or zero for no limit
+	AnalyticsFrameIntervalMillis int
+
+	// EventBufferSize indicates the maximum number of events buffered per device while the message bus is unavailable,
+	// or zero to send the events directly to the message bus
+	EventBufferSize int
+	// EventBufferDropPolicy indicates which events are dropped when the event buffer of a device is full, either
+	// "oldest" to drop the oldest buffered event or "newest" to drop the received event
+	EventBufferDropPolicy string
+	// EventBufferDir indicates the directory the event buffers are persisted to, so that the buffered events are
+	// published after a restart, or empty to keep the event buffers in memory only
+	EventBufferDir string
+
 	// CredentialsMap is a map of SecretName -> Comma separated list of mac addresses
 	CredentialsMap map[string]string
 }
@@ -66,12 +66,22 @@ const (
 	DefaultMessageTimeout = "defaultMessageTimeout"
 	// DefaultMessageLimit specify the MessageLimit for PullMessage. Upper limit for the number of messages to return at once, For example, 10
 	DefaultMessageLimit = "defaultMessageLimit"
+	// EventTopic is resource attribute and indicates the event readings of the topic are sent to the resource. For example, tns1:VideoSource/MotionAlarm
+	EventTopic = "eventTopic"
+	// EventItem is resource attribute and selects the SimpleItem of an eventTopic message which is sent as a typed reading. For example, Data/IsMotion
+	EventItem = "eventItem"
+	// EventSnapshot is resource attribute and indicates the snapshot of the event's video source is attached to the event readings of an eventTopic resource
+	EventSnapshot = "eventSnapshot"
 
 	Manufacturer    = "Manufacturer"
 	Model           = "Model"
 	FirmwareVersion = "FirmwareVersion"
 	SerialNumber    = "SerialNumber"
 	HardwareId      = "HardwareId"
+	// DiscoveryInstanceId is the ws-discovery AppSequence InstanceId last reported by the camera, used to detect reboots
+	DiscoveryInstanceId = "DiscoveryInstanceId"
 
 	UnknownDevicePrefix = "unknown_unknown_"
+	// PreviousName is the name the device had before it was renamed from an unknown device
+	PreviousName = "PreviousName"
 )
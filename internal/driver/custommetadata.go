@@ -61,7 +61,7 @@ func (onvifClient *OnvifClient) getCustomMetadata(device contract.Device, data [
 	// if an array of fields is provided, return those specific fields
 	metadataObj, err := onvifClient.getSpecificCustomMetadata(device, data)
 	if err != nil {
-		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get specific metadata for device %s", onvifClient.DeviceName), err)
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get specific metadata for device %s", onvifClient.deviceName()), err)
 	}
 	return metadataObj, nil
 }
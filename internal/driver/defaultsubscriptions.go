@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+// DefaultSubscriptions is the device property which declares the event subscriptions that should always exist for
+// the camera. The value maps the name of a subscribing resource, such as PullPointSubscription, to the body of its
+// subscription request, or to a list of named requests, and can be set in a device definition or in the
+// discoveredDevice of a provision watcher.
+const DefaultSubscriptions = "DefaultSubscriptions"
+
+// parseDefaultSubscriptions returns the json request bodies of the subscriptions declared by the device properties,
+// keyed by the name of the subscribing resource
+func parseDefaultSubscriptions(properties map[string]any) (map[string][]json.RawMessage, errors.EdgeX) {
+	value, ok := properties[DefaultSubscriptions]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid %s device property", DefaultSubscriptions), err)
+		}
+	}
+
+	declared := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &declared); err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid %s device property", DefaultSubscriptions), err)
+	}
+	subscriptions := make(map[string][]json.RawMessage, len(declared))
+	for resourceName, request := range declared {
+		requests := []json.RawMessage{request}
+		if bytes.HasPrefix(bytes.TrimSpace(request), []byte("[")) {
+			if err := json.Unmarshal(request, &requests); err != nil {
+				return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid %s device property", DefaultSubscriptions), err)
+			}
+		}
+		for i := range requests {
+			if string(requests[i]) == "null" {
+				requests[i] = json.RawMessage("{}")
+			}
+		}
+		subscriptions[resourceName] = requests
+	}
+	return subscriptions, nil
+}
+
+// defaultSubscription is an event subscription declared by the DefaultSubscriptions device property
+type defaultSubscription struct {
+	name          string
+	subscribeType string
+	request       *SubscriptionRequest
+}
+
+// declaredSubscriptions returns the valid subscriptions declared by the DefaultSubscriptions property of the device
+func (d *Driver) declaredSubscriptions(device models.Device, topics func() *eventTopicCatalog) []defaultSubscription {
+	subscriptions, edgexErr := parseDefaultSubscriptions(device.Properties)
+	if edgexErr != nil {
+		d.lc.Warnf("Unable to read the default subscriptions of device %s, %v", device.Name, edgexErr)
+		return nil
+	}
+
+	var declared []defaultSubscription
+	for resourceName, requests := range subscriptions {
+		resource, ok := d.sdkService.DeviceResource(device.Name, resourceName)
+		if !ok || fmt.Sprint(resource.Attributes[SetFunction]) != SubscribeCameraEvent {
+			d.lc.Warnf("Default subscription '%s' of device %s is not a %s resource of its profile", resourceName, device.Name, SubscribeCameraEvent)
+			continue
+		}
+		subscribeType, edgexErr := attributeByKey(resource.Attributes, SubscribeType)
+		if edgexErr != nil {
+			d.lc.Warnf("Invalid default subscription '%s' of device %s, %v", resourceName, device.Name, edgexErr)
+			continue
+		}
+		for _, data := range requests {
+			request, edgexErr := newSubscriptionRequest(resource.Attributes, data, topics)
+			if edgexErr != nil {
+				d.lc.Warnf("Invalid default subscription '%s' of device %s, %v", resourceName, device.Name, edgexErr)
+				continue
+			}
+			declared = append(declared, defaultSubscription{
+				name:          request.subscriptionName(resourceName),
+				subscribeType: subscribeType,
+				request:       request,
+			})
+		}
+	}
+	return declared
+}
+
+// reconcileDefaultSubscriptions creates the declared subscriptions of the device which do not exist yet. It is called
+// on every successful status check, so a subscription which was lost is re-created automatically. The subscriptions
+// cancelled with the CancelSubscription or UnsubscribeCameraEvent commands are skipped until they are subscribed again.
+// The suspended subscriptions of an unlocked device are resumed as well.
+func (d *Driver) reconcileDefaultSubscriptions(deviceName string) {
+	onvifClient, ok := d.getOnvifClient(deviceName)
+	if !ok || onvifClient.pullPointManager == nil || onvifClient.baseNotificationManager == nil {
+		return
+	}
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		d.lc.Debugf("Unable to get device %s from cache while reconciling its default subscriptions: %s", deviceName, err.Error())
+		return
+	}
+	cancelled := loadCancelledSubscriptions(device)
+	lastMessageTimes := loadLastMessageTimes(device)
+	// the subscriptions which could not be resumed when the device was unlocked are retried
+	onvifClient.resumeSubscriptions()
+
+	for _, subscription := range d.declaredSubscriptions(device, onvifClient.topicValidator) {
+		if cancelled[subscription.name] {
+			continue
+		}
+		if _, _, ok := onvifClient.findSubscription(subscription.name); ok {
+			continue
+		}
+
+		d.lc.Infof("Creating the default subscription '%s' of device %s", subscription.name, deviceName)
+		edgexErr := onvifClient.subscribe(subscription.name, persistedSubscription{SubscribeType: subscription.subscribeType, Request: subscription.request})
+		if edgexErr != nil {
+			d.lc.Errorf("Failed to create the default subscription '%s' of device %s, %v", subscription.name, deviceName, edgexErr)
+			continue
+		}
+		// the events may have been lost since the last message of the subscription, which was lost or not restored yet
+		onvifClient.recordDowntimeGap(subscription.name, lastMessageTimes[subscription.name], time.Now())
+	}
+}
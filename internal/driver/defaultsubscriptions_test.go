@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDefaultSubscriptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		properties    map[string]any
+		expected      map[string][]json.RawMessage
+		errorExpected bool
+	}{
+		{
+			name: "not declared",
+		},
+		{
+			name: "yaml object",
+			properties: map[string]any{DefaultSubscriptions: map[string]any{
+				"PullPointSubscription": map[string]any{"TopicFilter": "tns1:VideoSource/MotionAlarm"},
+			}},
+			expected: map[string][]json.RawMessage{"PullPointSubscription": {json.RawMessage(`{"TopicFilter":"tns1:VideoSource/MotionAlarm"}`)}},
+		},
+		{
+			name: "named subscriptions",
+			properties: map[string]any{DefaultSubscriptions: map[string]any{
+				"PullPointSubscription": []any{
+					map[string]any{"Name": "Motion", "TopicFilter": "tns1:RuleEngine/CellMotionDetector/Motion"},
+					map[string]any{"Name": "Tamper", "TopicFilter": "tns1:RuleEngine/TamperDetector/Tamper"},
+				},
+			}},
+			expected: map[string][]json.RawMessage{"PullPointSubscription": {
+				json.RawMessage(`{"Name":"Motion","TopicFilter":"tns1:RuleEngine/CellMotionDetector/Motion"}`),
+				json.RawMessage(`{"Name":"Tamper","TopicFilter":"tns1:RuleEngine/TamperDetector/Tamper"}`),
+			}},
+		},
+		{
+			name:       "json string",
+			properties: map[string]any{DefaultSubscriptions: `{"BaseNotificationSubscription": null}`},
+			expected:   map[string][]json.RawMessage{"BaseNotificationSubscription": {json.RawMessage(`{}`)}},
+		},
+		{
+			name:          "invalid",
+			properties:    map[string]any{DefaultSubscriptions: "PullPointSubscription"},
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			subscriptions, err := parseDefaultSubscriptions(test.properties)
+			if test.errorExpected {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, subscriptions, len(test.expected))
+			for name, requests := range test.expected {
+				require.Len(t, subscriptions[name], len(requests))
+				for i, request := range requests {
+					assert.JSONEq(t, string(request), string(subscriptions[name][i]))
+				}
+			}
+		})
+	}
+}
+
+func TestDriver_reconcileDefaultSubscriptions(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	driver.onvifClients[testDeviceName] = client
+
+	// the pull point subscription already exists, and the other resource is not a subscription
+	client.pullPointManager.suspend("PullPointSubscription", &SubscriptionRequest{})
+	device := createTestDevice()
+	device.Properties = map[string]any{DefaultSubscriptions: map[string]any{
+		"PullPointSubscription": map[string]any{},
+		"CameraEvent":           map[string]any{},
+	}}
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	mockService.On("DeviceResource", testDeviceName, "CameraEvent").
+		Return(models.DeviceResource{Name: "CameraEvent", Attributes: map[string]any{GetFunction: CameraEvent}}, true)
+	mockService.On("DeviceResource", testDeviceName, "PullPointSubscription").
+		Return(models.DeviceResource{Name: "PullPointSubscription", Attributes: map[string]any{
+			SetFunction:                   SubscribeCameraEvent,
+			SubscribeType:                 PullPoint,
+			DefaultInitialTerminationTime: "PT1H",
+		}}, true)
+
+	driver.reconcileDefaultSubscriptions(testDeviceName)
+
+	assert.Len(t, client.pullPointManager.suspended, 1)
+	assert.Empty(t, client.pullPointManager.subscribers)
+	assert.Empty(t, client.baseNotificationManager.consumers)
+}
+
+func TestDriver_reconcileDefaultSubscriptions_cancelled(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	driver.onvifClients[testDeviceName] = client
+	client.pullPointManager.suspend("Tamper", &SubscriptionRequest{})
+
+	device := createTestDevice()
+	device.Properties = map[string]any{DefaultSubscriptions: map[string]any{
+		"PullPointSubscription": []any{map[string]any{"Name": "Motion"}, map[string]any{"Name": "Tamper"}},
+	}}
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"Custom":{"SubscribeType":"PullPoint","Request":{}}}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(func(string) models.Device {
+		return device
+	}, nil)
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		device.Protocols = dtos.ToProtocolModels(args.Get(0).(dtos.UpdateDevice).Protocols)
+	}).Return(nil)
+	mockService.On("DeviceResource", testDeviceName, "PullPointSubscription").
+		Return(models.DeviceResource{Name: "PullPointSubscription", Attributes: map[string]any{
+			SetFunction:                   SubscribeCameraEvent,
+			SubscribeType:                 PullPoint,
+			DefaultInitialTerminationTime: "PT1H",
+		}}, true)
+
+	// only the default subscriptions are recorded as cancelled
+	require.NoError(t, driver.cancelSubscriptions(testDeviceName, "Motion", "Custom"))
+	assert.Equal(t, `["Motion"]`, device.Protocols[OnvifProtocol][CancelledSubscriptions])
+	subscriptions, err := loadSubscriptions(device)
+	require.NoError(t, err)
+	assert.Empty(t, subscriptions)
+
+	// the cancelled subscription is not re-created
+	driver.reconcileDefaultSubscriptions(testDeviceName)
+	assert.Empty(t, client.pullPointManager.subscribers)
+
+	// until it is subscribed again
+	require.NoError(t, driver.saveSubscription(testDeviceName, "Motion", &persistedSubscription{SubscribeType: PullPoint, Request: &SubscriptionRequest{}}))
+	assert.NotContains(t, device.Protocols[OnvifProtocol], CancelledSubscriptions)
+
+	// cancelling every subscription records all default subscriptions
+	require.NoError(t, driver.cancelSubscriptions(testDeviceName))
+	assert.Equal(t, `["Motion","Tamper"]`, device.Protocols[OnvifProtocol][CancelledSubscriptions])
+	assert.NotContains(t, device.Protocols[OnvifProtocol], EventSubscriptions)
+}
@@ -0,0 +1,219 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+
+	onvifdevice "github.com/IOTechSystems/onvif/device"
+)
+
+const (
+	// DeviceFirmwareChanged is the getFunction of the resource used to publish firmware version changes
+	DeviceFirmwareChanged = "DeviceFirmwareChanged"
+	// DeviceHardwareChanged is the getFunction of the resource used to publish serial number or hardware id
+	// changes, which indicate the camera at the address has been swapped
+	DeviceHardwareChanged = "DeviceHardwareChanged"
+	// DeviceRebooted is the getFunction of the resource used to publish the detection of a camera reboot
+	DeviceRebooted = "DeviceRebooted"
+	// DeviceRenamed is the getFunction of the resource used to publish the mapping from the old name of a renamed
+	// device to the new one
+	DeviceRenamed = "DeviceRenamed"
+)
+
+// discoveryEnvelope is the minimal representation of a ws-discovery response needed to read the AppSequence
+type discoveryEnvelope struct {
+	Header struct {
+		AppSequence struct {
+			// InstanceId must be incremented by the camera each time it restarts, see the WS-Discovery specification
+			InstanceId    string `xml:"InstanceId,attr"`
+			MessageNumber string `xml:"MessageNumber,attr"`
+		}
+	}
+}
+
+// detectDeviceChanges compares the device information reported by the camera with the values stored in the
+// protocol properties. A DeviceFirmwareChanged event is published when the firmware version differs, and a
+// DeviceHardwareChanged event is published when the serial number or hardware id differs. The protocol properties
+// are updated in place, and true is returned if any of the values changed.
+func (d *Driver) detectDeviceChanges(device models.Device, devInfo *onvifdevice.GetDeviceInformationResponse) bool {
+	protocol := device.Protocols[OnvifProtocol]
+	previous := map[string]string{
+		Manufacturer:    protocolValue(protocol, Manufacturer),
+		Model:           protocolValue(protocol, Model),
+		FirmwareVersion: protocolValue(protocol, FirmwareVersion),
+		SerialNumber:    protocolValue(protocol, SerialNumber),
+		HardwareId:      protocolValue(protocol, HardwareId),
+	}
+	current := map[string]string{
+		Manufacturer:    devInfo.Manufacturer,
+		Model:           devInfo.Model,
+		FirmwareVersion: devInfo.FirmwareVersion,
+		SerialNumber:    devInfo.SerialNumber,
+		HardwareId:      devInfo.HardwareId,
+	}
+
+	isChanged := false
+	for key, value := range current {
+		if _, ok := protocol[key]; !ok || previous[key] != value {
+			protocol[key] = value
+			isChanged = true
+		}
+	}
+
+	// changes from unset values are the result of the first query of the device information, not a change to the camera
+	if isHardwareChanged(previous, current) {
+		d.lc.Warnf("The serial number or hardware id of device %s at address %v has changed from %s/%s to %s/%s, the camera has been replaced.",
+			device.Name, protocol[Address], previous[SerialNumber], previous[HardwareId], current[SerialNumber], current[HardwareId])
+		d.publishDeviceChangeEvent(device.Name, DeviceHardwareChanged, map[string]interface{}{
+			Address:    protocolValue(protocol, Address),
+			"Previous": previous,
+			"Current":  current,
+		})
+	} else if previous[FirmwareVersion] != "" && previous[FirmwareVersion] != current[FirmwareVersion] {
+		d.lc.Warnf("The firmware version of device %s has changed from %s to %s.",
+			device.Name, previous[FirmwareVersion], current[FirmwareVersion])
+		d.publishDeviceChangeEvent(device.Name, DeviceFirmwareChanged, map[string]interface{}{
+			"PreviousFirmwareVersion": previous[FirmwareVersion],
+			FirmwareVersion:           current[FirmwareVersion],
+		})
+	}
+
+	return isChanged
+}
+
+func isHardwareChanged(previous, current map[string]string) bool {
+	return (previous[SerialNumber] != "" && previous[SerialNumber] != current[SerialNumber]) ||
+		(previous[HardwareId] != "" && previous[HardwareId] != current[HardwareId])
+}
+
+// detectReboot sends a unicast ws-discovery probe to the camera and compares the AppSequence InstanceId of the
+// response with the one stored in the protocol properties. A DeviceRebooted event is published when it differs.
+// The protocol properties are updated in place, and true is returned if the InstanceId changed.
+func (d *Driver) detectReboot(device models.Device) bool {
+	protocol := device.Protocols[OnvifProtocol]
+	address := protocolValue(protocol, Address)
+	if address == "" {
+		return false
+	}
+
+	d.configMu.RLock()
+	timeout := time.Duration(d.config.AppCustom.ProbeTimeoutMillis) * time.Millisecond
+	d.configMu.RUnlock()
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(address, wsDiscoveryPort), timeout)
+	if err != nil {
+		d.lc.Debugf("Unable to dial ws-discovery port of device %s: %s", device.Name, err.Error())
+		return false
+	}
+	defer conn.Close()
+
+	// the camera is the only responder of a unicast probe, so the first response with an InstanceId is enough
+	responses, err := sendRawProbeUntil(conn, timeout, d.lc, func(response string) bool {
+		return parseInstanceId([]string{response}) != ""
+	})
+	if err != nil {
+		d.lc.Debugf("Unable to probe device %s: %s", device.Name, err.Error())
+		return false
+	}
+	instanceId := parseInstanceId(responses)
+	if instanceId == "" {
+		d.lc.Debugf("No ws-discovery AppSequence was returned by device %s, unable to detect reboots", device.Name)
+		return false
+	}
+
+	previous := protocolValue(protocol, DiscoveryInstanceId)
+	if previous == instanceId {
+		return false
+	}
+	protocol[DiscoveryInstanceId] = instanceId
+
+	// an unset value is the result of the first probe of the device, not a reboot
+	if previous != "" {
+		d.lc.Infof("Device %s has rebooted since the last status check (InstanceId %s -> %s)", device.Name, previous, instanceId)
+		d.publishDeviceChangeEvent(device.Name, DeviceRebooted, map[string]interface{}{
+			"PreviousInstanceId": previous,
+			DiscoveryInstanceId:  instanceId,
+		})
+	}
+	return true
+}
+
+// protocolValue returns the string value of a protocol property, or an empty string if it is not set
+func protocolValue(protocol models.ProtocolProperties, key string) string {
+	if v, ok := protocol[key]; ok && v != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return ""
+}
+
+// parseInstanceId returns the AppSequence InstanceId of the first ws-discovery response which contains one
+func parseInstanceId(responses []string) string {
+	for _, resp := range responses {
+		envelope := discoveryEnvelope{}
+		if err := xml.Unmarshal([]byte(resp), &envelope); err != nil {
+			continue
+		}
+		if envelope.Header.AppSequence.InstanceId != "" {
+			return envelope.Header.AppSequence.InstanceId
+		}
+	}
+	return ""
+}
+
+// checkDeviceChanges detects firmware, hardware and reboot changes of a device which is already known to be
+// UpWithAuth, and patches the protocol properties if anything changed
+func (d *Driver) checkDeviceChanges(deviceName string, devInfo *onvifdevice.GetDeviceInformationResponse) {
+	// lookup device from cache to ensure we are updating the latest version
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		d.lc.Warnf("Unable to get device %s from cache while checking for device changes: %s", deviceName, err.Error())
+		return
+	}
+
+	isChanged := d.detectDeviceChanges(device, devInfo)
+	if d.detectReboot(device) {
+		isChanged = true
+	}
+	if !isChanged {
+		return
+	}
+
+	if err := d.patchDeviceProtocols(device.Name, device.Protocols); err != nil {
+		d.lc.Errorf("Unable to update the device information of device %s: %s", device.Name, err.Error())
+	}
+}
+
+// publishDeviceChangeEvent sends a reading to the device resource with the specified getFunction. Devices
+// whose profile does not define the resource are skipped.
+func (d *Driver) publishDeviceChangeEvent(deviceName string, functionName string, details map[string]interface{}) {
+	resource, edgexErr := d.getDeviceResourceByGetFunction(deviceName, functionName)
+	if edgexErr != nil {
+		d.lc.Debugf("Skip publishing the %s event for device %s: %s", functionName, deviceName, edgexErr.Message())
+		return
+	}
+
+	cv, err := sdkModel.NewCommandValue(resource.Name, common.ValueTypeObject, details)
+	if err != nil {
+		d.lc.Errorf("Failed to create the %s commandValue for device %s: %s", functionName, deviceName, err.Error())
+		return
+	}
+
+	d.sendAsyncValues(deviceName, []*sdkModel.AsyncValues{{
+		DeviceName:    deviceName,
+		CommandValues: []*sdkModel.CommandValue{cv},
+	}})
+}
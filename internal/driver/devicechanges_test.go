@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	onvifdevice "github.com/IOTechSystems/onvif/device"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProfileName = "test-profile"
+
+const testProbeMatchResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+  <SOAP-ENV:Header>
+    <wsa:MessageID>uuid:f3d577a3-431f-4807-a7e1-c6f1dd6a3bd2</wsa:MessageID>
+    <d:AppSequence InstanceId="1695280187" MessageNumber="3"></d:AppSequence>
+  </SOAP-ENV:Header>
+  <SOAP-ENV:Body>
+    <d:ProbeMatches></d:ProbeMatches>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+func createTestDeviceWithDeviceInfo(firmware, serial, hardwareId string) models.Device {
+	return models.Device{
+		Name:        testDeviceName,
+		ProfileName: testProfileName,
+		Protocols: map[string]models.ProtocolProperties{
+			OnvifProtocol: {
+				Address:         "192.168.1.10",
+				Manufacturer:    "Intel",
+				Model:           "SimCamera",
+				FirmwareVersion: firmware,
+				SerialNumber:    serial,
+				HardwareId:      hardwareId,
+			},
+		},
+	}
+}
+
+func createTestDeviceChangeProfile() models.DeviceProfile {
+	profile := models.DeviceProfile{Name: testProfileName}
+	for _, function := range []string{DeviceFirmwareChanged, DeviceHardwareChanged, DeviceRebooted} {
+		profile.DeviceResources = append(profile.DeviceResources, models.DeviceResource{
+			Name:       function,
+			Attributes: map[string]interface{}{Service: EdgeXWebService, GetFunction: function},
+		})
+	}
+	return profile
+}
+
+func TestDriver_detectDeviceChanges(t *testing.T) {
+	tests := []struct {
+		name             string
+		device           models.Device
+		devInfo          *onvifdevice.GetDeviceInformationResponse
+		expectedChanged  bool
+		expectedResource string
+	}{
+		{
+			name:    "unchanged",
+			device:  createTestDeviceWithDeviceInfo("2.4a", "46d1ab8d", "1.0"),
+			devInfo: &onvifdevice.GetDeviceInformationResponse{Manufacturer: "Intel", Model: "SimCamera", FirmwareVersion: "2.4a", SerialNumber: "46d1ab8d", HardwareId: "1.0"},
+		},
+		{
+			name:            "first query",
+			device:          models.Device{Name: testDeviceName, Protocols: map[string]models.ProtocolProperties{OnvifProtocol: {}}},
+			devInfo:         &onvifdevice.GetDeviceInformationResponse{Manufacturer: "Intel", Model: "SimCamera", FirmwareVersion: "2.4a", SerialNumber: "46d1ab8d", HardwareId: "1.0"},
+			expectedChanged: true,
+		},
+		{
+			name:             "firmware changed",
+			device:           createTestDeviceWithDeviceInfo("2.4a", "46d1ab8d", "1.0"),
+			devInfo:          &onvifdevice.GetDeviceInformationResponse{Manufacturer: "Intel", Model: "SimCamera", FirmwareVersion: "2.5a", SerialNumber: "46d1ab8d", HardwareId: "1.0"},
+			expectedChanged:  true,
+			expectedResource: DeviceFirmwareChanged,
+		},
+		{
+			name:             "serial number changed",
+			device:           createTestDeviceWithDeviceInfo("2.4a", "46d1ab8d", "1.0"),
+			devInfo:          &onvifdevice.GetDeviceInformationResponse{Manufacturer: "Intel", Model: "SimCamera", FirmwareVersion: "2.5a", SerialNumber: "9a32410c", HardwareId: "1.0"},
+			expectedChanged:  true,
+			expectedResource: DeviceHardwareChanged,
+		},
+		{
+			name:             "hardware id changed",
+			device:           createTestDeviceWithDeviceInfo("2.4a", "46d1ab8d", "1.0"),
+			devInfo:          &onvifdevice.GetDeviceInformationResponse{Manufacturer: "Intel", Model: "SimCamera", FirmwareVersion: "2.4a", SerialNumber: "46d1ab8d", HardwareId: "2.0"},
+			expectedChanged:  true,
+			expectedResource: DeviceHardwareChanged,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			driver, mockService := createDriverWithMockService()
+			asyncCh := make(chan *sdkModel.AsyncValues, 1)
+			mockService.On("GetDeviceByName", testDeviceName).Return(test.device, nil).Maybe()
+			mockService.On("GetProfileByName", testProfileName).Return(createTestDeviceChangeProfile(), nil).Maybe()
+			mockService.On("AsyncValuesChannel").Return(asyncCh).Maybe()
+
+			changed := driver.detectDeviceChanges(test.device, test.devInfo)
+			assert.Equal(t, test.expectedChanged, changed)
+			assert.Equal(t, test.devInfo.FirmwareVersion, test.device.Protocols[OnvifProtocol][FirmwareVersion])
+			assert.Equal(t, test.devInfo.SerialNumber, test.device.Protocols[OnvifProtocol][SerialNumber])
+
+			if test.expectedResource == "" {
+				assert.Len(t, asyncCh, 0)
+				return
+			}
+			require.Len(t, asyncCh, 1)
+			asyncValues := <-asyncCh
+			assert.Equal(t, testDeviceName, asyncValues.DeviceName)
+			require.Len(t, asyncValues.CommandValues, 1)
+			assert.Equal(t, test.expectedResource, asyncValues.CommandValues[0].DeviceResourceName)
+		})
+	}
+}
+
+func TestParseInstanceId(t *testing.T) {
+	response := testProbeMatchResponse
+
+	assert.Equal(t, "1695280187", parseInstanceId([]string{"invalid", response}))
+	assert.Equal(t, "", parseInstanceId([]string{"invalid"}))
+	assert.Equal(t, "", parseInstanceId(nil))
+}
+
+func TestSendRawProbeUntil(t *testing.T) {
+	camera, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer camera.Close()
+	go func() {
+		buf := make([]byte, bufSize)
+		_, addr, err := camera.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		_, _ = camera.WriteTo([]byte("invalid"), addr)
+		_, _ = camera.WriteTo([]byte(testProbeMatchResponse), addr)
+	}()
+
+	conn, err := net.Dial("udp", camera.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Now()
+	responses, err := sendRawProbeUntil(conn, 5*time.Second, logger.MockLogger{}, func(response string) bool {
+		return parseInstanceId([]string{response}) != ""
+	})
+	require.NoError(t, err)
+	assert.Len(t, responses, 2)
+	assert.Less(t, time.Since(start), 5*time.Second, "the probe should return on the first matching response")
+}
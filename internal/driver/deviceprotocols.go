@@ -0,0 +1,147 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+// protocolWriteTimeout is the amount of time the protocol properties written by the device service are preferred
+// over the ones of the device cache, which is updated asynchronously once core-metadata has applied the change
+const protocolWriteTimeout = 5 * time.Second
+
+// protocolWrite is the last protocol properties written for a device
+type protocolWrite struct {
+	protocols map[string]models.ProtocolProperties
+	written   time.Time
+}
+
+// lockDeviceProtocols serializes the read-modify-write cycles of the protocol properties of a device, which are
+// written by the status checks, the event subscriptions, the maintenance window and the device commands. The
+// device must be read with latestDevice while the lock is held. Returns the function which releases the lock.
+func (d *Driver) lockDeviceProtocols(deviceName string) func() {
+	d.protocolsMu.Lock()
+	if d.protocolLocks == nil {
+		d.protocolLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := d.protocolLocks[deviceName]
+	if !ok {
+		lock = &sync.Mutex{}
+		d.protocolLocks[deviceName] = lock
+	}
+	d.protocolsMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// forgetDeviceProtocols discards the protocol lock and the last protocol write of a device which was removed or
+// renamed
+func (d *Driver) forgetDeviceProtocols(deviceName string) {
+	d.protocolsMu.Lock()
+	defer d.protocolsMu.Unlock()
+	delete(d.protocolLocks, deviceName)
+	delete(d.protocolWrites, deviceName)
+}
+
+// latestDevice returns the device from the cache, with the protocol properties of the last write of the device
+// service if the cache does not reflect them yet
+func (d *Driver) latestDevice(deviceName string) (models.Device, error) {
+	device, err := d.sdkService.GetDeviceByName(deviceName)
+	if err != nil {
+		return device, err
+	}
+	return d.withProtocolWrites(device), nil
+}
+
+// withProtocolWrites returns the device with the protocol properties of the last write of the device service,
+// if the specified device does not reflect them yet
+func (d *Driver) withProtocolWrites(device models.Device) models.Device {
+	d.protocolsMu.Lock()
+	defer d.protocolsMu.Unlock()
+	write, ok := d.protocolWrites[device.Name]
+	if !ok {
+		return device
+	}
+	if time.Since(write.written) > protocolWriteTimeout || protocolsEqual(device.Protocols, write.protocols) {
+		delete(d.protocolWrites, device.Name)
+		return device
+	}
+	device.Protocols = copyProtocols(write.protocols)
+	return device
+}
+
+// recordProtocolWrite keeps the protocol properties written for a device until the cache reflects them
+func (d *Driver) recordProtocolWrite(deviceName string, protocols map[string]models.ProtocolProperties) {
+	d.protocolsMu.Lock()
+	defer d.protocolsMu.Unlock()
+	if d.protocolWrites == nil {
+		d.protocolWrites = make(map[string]protocolWrite)
+	}
+	d.protocolWrites[deviceName] = protocolWrite{protocols: copyProtocols(protocols), written: time.Now()}
+}
+
+// patchDeviceProtocols updates the protocol properties of a device. It should be called while the lock of
+// lockDeviceProtocols is held.
+func (d *Driver) patchDeviceProtocols(deviceName string, protocols map[string]models.ProtocolProperties) error {
+	err := d.sdkService.PatchDevice(dtos.UpdateDevice{
+		Name:      &deviceName,
+		Protocols: dtos.FromProtocolModelsToDTOs(protocols),
+	})
+	if err == nil {
+		d.recordProtocolWrite(deviceName, protocols)
+	}
+	return err
+}
+
+// updateDevice updates a device whose protocol properties were changed. It should be called while the lock of
+// lockDeviceProtocols is held.
+func (d *Driver) updateDevice(device models.Device) error {
+	err := d.sdkService.UpdateDevice(device)
+	if err == nil {
+		d.recordProtocolWrite(device.Name, device.Protocols)
+	}
+	return err
+}
+
+func copyProtocols(protocols map[string]models.ProtocolProperties) map[string]models.ProtocolProperties {
+	copied := make(map[string]models.ProtocolProperties, len(protocols))
+	for name, properties := range protocols {
+		copiedProperties := make(models.ProtocolProperties, len(properties))
+		for key, value := range properties {
+			copiedProperties[key] = value
+		}
+		copied[name] = copiedProperties
+	}
+	return copied
+}
+
+// protocolsEqual compares the string values of two sets of protocol properties, since the values of the cache
+// are decoded from json
+func protocolsEqual(a, b map[string]models.ProtocolProperties) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, properties := range a {
+		other, ok := b[name]
+		if !ok || len(properties) != len(other) {
+			return false
+		}
+		for key, value := range properties {
+			otherValue, ok := other[key]
+			if !ok || fmt.Sprint(value) != fmt.Sprint(otherValue) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDriver_lockDeviceProtocols verifies that concurrent writers of the protocol properties do not overwrite each
+// other's changes, while the device cache is not updated yet
+func TestDriver_lockDeviceProtocols(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+
+	// the cache returns the stale device until it is updated by core-metadata
+	mockService.On("GetDeviceByName", testDeviceName).Return(func(string) models.Device {
+		return createTestDevice()
+	}, nil)
+	var mu sync.Mutex
+	var saved map[string]models.ProtocolProperties
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = dtos.ToProtocolModels(args.Get(0).(dtos.UpdateDevice).Protocols)
+	}).Return(nil)
+
+	until := time.Now().Add(time.Hour)
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, driver.saveSubscription(testDeviceName, "PullPointSubscription", &persistedSubscription{SubscribeType: PullPoint, Request: &SubscriptionRequest{}}))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, driver.startMaintenance(client, until))
+	}()
+	go func() {
+		defer wg.Done()
+		_, err := driver.updateDeviceStatus(testDeviceName, UpWithAuth)
+		assert.NoError(t, err)
+	}()
+	wg.Wait()
+	defer client.discardMaintenance()
+
+	require.NotNil(t, saved)
+	assert.Contains(t, saved[OnvifProtocol], EventSubscriptions)
+	assert.Equal(t, until.Format(time.RFC3339), saved[OnvifProtocol][MaintenanceUntil])
+	assert.Equal(t, UpWithAuth, saved[OnvifProtocol][DeviceStatus])
+}
+
+func TestDriver_withProtocolWrites(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	device := createTestDevice()
+	assert.Equal(t, device, driver.withProtocolWrites(device))
+
+	written := createTestDevice()
+	written.Protocols[OnvifProtocol][DeviceStatus] = UpWithAuth
+	driver.recordProtocolWrite(testDeviceName, written.Protocols)
+	assert.Equal(t, UpWithAuth, driver.withProtocolWrites(device).Protocols[OnvifProtocol][DeviceStatus])
+
+	// the write is dropped once the cache reflects it
+	assert.Equal(t, written, driver.withProtocolWrites(written))
+	assert.Empty(t, driver.protocolWrites)
+
+	// or once it is expired, in case the device was changed by another client
+	driver.recordProtocolWrite(testDeviceName, written.Protocols)
+	write := driver.protocolWrites[testDeviceName]
+	write.written = time.Now().Add(-protocolWriteTimeout - time.Second)
+	driver.protocolWrites[testDeviceName] = write
+	assert.Equal(t, device, driver.withProtocolWrites(device))
+}
+
+func TestDriver_forgetDeviceProtocols(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	unlock := driver.lockDeviceProtocols(testDeviceName)
+	driver.recordProtocolWrite(testDeviceName, createTestDevice().Protocols)
+	unlock()
+
+	driver.removeOnvifClient(testDeviceName)
+	assert.Empty(t, driver.protocolLocks)
+	assert.Empty(t, driver.protocolWrites)
+}
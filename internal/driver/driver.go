@@ -12,7 +12,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/edgexfoundry/go-mod-bootstrap/v3/bootstrap/secret"
-	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +37,11 @@ const (
 	// discoverDebounceDuration is the amount of time to wait for additional changes to discover
 	// configuration before auto-triggering a discovery
 	discoverDebounceDuration = 10 * time.Second
+
+	// renameTimeout is the amount of time to wait for a renamed device to be added to the cache
+	renameTimeout = 10 * time.Second
+	// renamePollInterval is the interval at which the cache is checked for a renamed device
+	renamePollInterval = 100 * time.Millisecond
 )
 
 // Driver implements the sdkModel.ProtocolDriver interface for
@@ -51,6 +55,8 @@ type Driver struct {
 
 	config   *ServiceConfig
 	configMu sync.RWMutex
+	// debounceWindows is the parsed EventDebounceWindows configuration, see updateDebounceWindows
+	debounceWindows map[string]time.Duration
 
 	macAddressMapper *MACAddressMapper
 
@@ -58,6 +64,19 @@ type Driver struct {
 	debounceTimer *time.Timer
 	debounceMu    sync.Mutex
 
+	// protocolLocks serializes the writes of the protocol properties per device, and protocolWrites holds the last
+	// protocol properties written per device, see lockDeviceProtocols
+	protocolLocks  map[string]*sync.Mutex
+	protocolWrites map[string]protocolWrite
+	protocolsMu    sync.Mutex
+
+	// addressRecoveries holds the time of the last attempt to locate each unreachable device
+	addressRecoveries map[string]time.Time
+	addressRecoveryMu sync.Mutex
+
+	// notificationListener is the dedicated listener for camera notifications, or nil if it is not enabled
+	notificationListener *notificationListener
+
 	// taskCh is used to send signals to the taskLoop
 	taskCh chan struct{}
 	wg     sync.WaitGroup
@@ -66,8 +85,9 @@ type Driver struct {
 func NewDriver() *Driver {
 	return &Driver{
 		onvifClients: make(map[string]*OnvifClient),
-		config:       &ServiceConfig{},
-		taskCh:       make(chan struct{}),
+		// the notification source is verified if the configuration does not disable it
+		config: &ServiceConfig{AppCustom: CustomConfig{VerifyNotificationSource: true}},
+		taskCh: make(chan struct{}),
 	}
 }
 
@@ -96,17 +116,26 @@ func (d *Driver) Initialize(sdk interfaces.DeviceServiceSDK) error {
 	}
 
 	d.macAddressMapper.UpdateMappings(d.config.AppCustom.CredentialsMap)
+	d.updateDebounceWindows(d.config.AppCustom.EventDebounceWindows)
+	d.validateEventBufferDropPolicy(d.config.AppCustom.EventBufferDropPolicy)
 
 	err = d.sdkService.ListenForCustomConfigChanges(&d.config.AppCustom, "AppCustom", d.updateWritableConfig)
 	if err != nil {
 		return errors.NewCommonEdgeX(errors.KindServerError, "failed to listen to custom config changes", err)
 	}
 
-	handler := NewRestNotificationHandler(d.sdkService)
+	handler := NewRestNotificationHandler(d.sdkService, d)
 	edgexErr := handler.AddRoute()
 	if edgexErr != nil {
 		return errors.NewCommonEdgeXWrapper(edgexErr)
 	}
+	if d.config.AppCustom.NotificationListenerPort > 0 {
+		d.notificationListener, edgexErr = startNotificationListener(d.lc, handler,
+			d.config.AppCustom.NotificationListenerInterface, d.config.AppCustom.NotificationListenerPort)
+		if edgexErr != nil {
+			return errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+	}
 
 	d.lc.Info("Driver initialized.")
 	return nil
@@ -123,12 +152,13 @@ func (d *Driver) Start() error {
 			defer wg.Done()
 
 			d.lc.Infof("Initializing onvif client for '%s' camera", device.Name)
-			_, err := d.getOrCreateOnvifClient(device)
+			onvifClient, err := d.getOrCreateOnvifClient(device)
 			if err != nil {
 				d.lc.Errorf("failed to initialize onvif client for '%s' camera, skipping this device.", device.Name)
 				return
 			}
 			d.checkStatusOfDevice(device)
+			d.restoreSubscriptions(onvifClient, device)
 		}()
 	}
 	wg.Wait()
@@ -156,34 +186,66 @@ func (d *Driver) Start() error {
 // for closing any in-use channels, including the channel used to send async
 // readings (if supported).
 func (d *Driver) Stop(force bool) error {
+	// the clients are stopped without holding the lock, since the subscription loops and the metadata streams read it
+	d.clientsMu.RLock()
+	clients := make([]*OnvifClient, 0, len(d.onvifClients))
+	for _, client := range d.onvifClients {
+		clients = append(clients, client)
+	}
+	d.clientsMu.RUnlock()
+	// the events lost while the service is stopped are replayed once the subscriptions are restored
+	d.saveAllLastMessageTimes()
+	for _, client := range clients {
+		if client.pullPointManager != nil {
+			client.pullPointManager.UnsubscribeAll()
+		}
+		if client.baseNotificationManager != nil {
+			client.baseNotificationManager.UnsubscribeAll()
+		}
+	}
+	for _, client := range clients {
+		// the pending events are discarded before the async values channel is closed
+		client.eventFilter.stop()
+		client.stopSnapshotQueue()
+		client.stopMetadataStream()
+		// the event buffers are stopped and persisted before the async values channel is closed
+		if client.eventBuffer != nil {
+			client.eventBuffer.stop(true)
+		}
+	}
 	if d.sdkService.AsyncValuesChannel() != nil {
 		close(d.sdkService.AsyncValuesChannel())
 	}
 
 	d.clientsMu.Lock()
-	for _, client := range d.onvifClients {
-		client.pullPointManager.UnsubscribeAll()
-		client.baseNotificationManager.UnsubscribeAll()
-	}
 	d.onvifClients = make(map[string]*OnvifClient)
 	d.clientsMu.Unlock()
 
 	close(d.taskCh) // send signal for taskLoop to finish
 	d.wg.Wait()     // wait for taskLoop goroutine to return
 
+	if d.notificationListener != nil {
+		d.notificationListener.stop()
+	}
+
 	return nil
 }
 
 // AddDevice is a callback function that is invoked
 // when a new Device associated with this Device Service is added
 func (d *Driver) AddDevice(deviceName string, protocols map[string]models.ProtocolProperties, adminState models.AdminState) error {
-	_, err := d.getOrCreateOnvifClient(models.Device{Name: deviceName, Protocols: protocols})
+	device := models.Device{Name: deviceName, Protocols: protocols, AdminState: adminState}
+	onvifClient, err := d.getOrCreateOnvifClient(device)
 	if err != nil {
 		d.lc.Errorf("Failed to initialize onvif client for camera '%s'", deviceName)
 		return errors.NewCommonEdgeXWrapper(err)
 	}
+	// the client may already exist when the device is renamed
+	d.updateAdminState(device)
 	// check the status of the newly added device
-	d.checkStatusOfDevice(models.Device{Name: deviceName, Protocols: protocols})
+	d.checkStatusOfDevice(device)
+	// re-establish the subscriptions of a device which was re-added, without blocking the callback
+	go d.restoreSubscriptions(onvifClient, device)
 	return nil
 }
 
@@ -191,11 +253,14 @@ func (d *Driver) AddDevice(deviceName string, protocols map[string]models.Protoc
 // when a Device associated with this Device Service is updated
 func (d *Driver) UpdateDevice(deviceName string, protocols map[string]models.ProtocolProperties, adminState models.AdminState) error {
 	// Invoke the updateOnvifClient func to update the old onvif client if needed
-	err := d.updateOnvifClient(models.Device{Name: deviceName, Protocols: protocols})
+	device := models.Device{Name: deviceName, Protocols: protocols, AdminState: adminState}
+	err := d.updateOnvifClient(device)
 	if err != nil {
 		d.lc.Errorf("Unable to update onvif device client for device %s, %v", deviceName, err)
+		return err
 	}
-	return err
+	d.updateAdminState(device)
+	return nil
 }
 
 // RemoveDevice is a callback function that is invoked
@@ -430,9 +495,18 @@ func (d *Driver) updateWritableConfig(rawWritableConfig interface{}) {
 
 	d.configMu.Lock()
 	oldSubnets := d.config.AppCustom.DiscoverySubnets
+	oldDebounceWindows := d.config.AppCustom.EventDebounceWindows
+	oldDropPolicy := d.config.AppCustom.EventBufferDropPolicy
 	d.config.AppCustom = *updated
 	d.configMu.Unlock()
 
+	if updated.EventDebounceWindows != oldDebounceWindows {
+		d.updateDebounceWindows(updated.EventDebounceWindows)
+	}
+	if updated.EventBufferDropPolicy != oldDropPolicy {
+		d.validateEventBufferDropPolicy(updated.EventBufferDropPolicy)
+	}
+
 	if updated.DiscoverySubnets != oldSubnets {
 		d.lc.Info("Discover configuration has changed! Discovery will be triggered momentarily.")
 		d.debouncedDiscover()
@@ -471,8 +545,10 @@ func (d *Driver) refreshDevice(device models.Device) error {
 	}
 
 	// update device to latest version in cache to prevent race conditions and ensure we have all associated metadata
-	device, edgeXErr := d.sdkService.GetDeviceByName(device.Name)
-	if err != nil {
+	unlock := d.lockDeviceProtocols(device.Name)
+	defer unlock()
+	device, edgeXErr := d.latestDevice(device.Name)
+	if edgeXErr != nil {
 		return edgeXErr
 	}
 
@@ -492,17 +568,11 @@ func (d *Driver) refreshDevice(device models.Device) error {
 		isChanged = true
 	}
 
-	if devInfo.Manufacturer != device.Protocols[OnvifProtocol][Manufacturer] ||
-		devInfo.Model != device.Protocols[OnvifProtocol][Model] ||
-		devInfo.FirmwareVersion != device.Protocols[OnvifProtocol][FirmwareVersion] ||
-		devInfo.SerialNumber != device.Protocols[OnvifProtocol][SerialNumber] ||
-		devInfo.HardwareId != device.Protocols[OnvifProtocol][HardwareId] {
-
-		device.Protocols[OnvifProtocol][Manufacturer] = devInfo.Manufacturer
-		device.Protocols[OnvifProtocol][Model] = devInfo.Model
-		device.Protocols[OnvifProtocol][FirmwareVersion] = devInfo.FirmwareVersion
-		device.Protocols[OnvifProtocol][SerialNumber] = devInfo.SerialNumber
-		device.Protocols[OnvifProtocol][HardwareId] = devInfo.HardwareId
+	// publish the firmware, hardware and reboot changes instead of silently overwriting the values
+	if d.detectDeviceChanges(device, devInfo) {
+		isChanged = true
+	}
+	if d.detectReboot(device) {
 		isChanged = true
 	}
 
@@ -526,29 +596,77 @@ func (d *Driver) renameOrPatchDevice(device models.Device, deviceInfo *onvifdevi
 	}
 }
 
+// renameDevice replaces an unknown device with a device named after its device information. EdgeX device names
+// cannot be changed, so the renamed device is added before the unknown device is removed. The labels, custom metadata,
+// auto events and event subscriptions are carried over, and the mapping from the old name to the new one is stored
+// in the protocol properties and published to the DeviceRenamed resource.
 func (d *Driver) renameDevice(device models.Device, deviceInfo *onvifdevice.GetDeviceInformationResponse) error {
-	d.lc.Infof("Removing device '%s' to update device with the updated name", device.Name)
-	err := d.sdkService.RemoveDeviceByName(device.Name)
-	if err != nil {
-		d.lc.Warnf("An error occurred while removing the device %s: %s",
-			device.Name, err)
-	}
-
-	device.Id = ""
-	device.Name = buildDeviceName(
+	oldName, oldId := device.Name, device.Id
+	newName := buildDeviceName(
 		deviceInfo.Manufacturer,
 		deviceInfo.Model,
 		fmt.Sprintf("%v", device.Protocols[OnvifProtocol][EndpointRefAddress]),
 	)
+	if d.sdkService.DeviceExistsForName(newName) {
+		return errors.NewCommonEdgeX(errors.KindDuplicateName,
+			fmt.Sprintf("unable to rename device '%s', a device named '%s' already exists", oldName, newName), nil)
+	}
 
-	d.lc.Infof("Adding device back with the updated name '%s'", device.Name)
-	_, err = d.sdkService.AddDevice(device)
-	return err
-}
+	// use the latest version in cache so that changes made since the device information was queried, such as
+	// custom metadata, are not lost
+	latest, err := d.latestDevice(oldName)
+	if err == nil {
+		latest.Protocols[OnvifProtocol] = device.Protocols[OnvifProtocol]
+		device = latest
+	}
+
+	device.Id = ""
+	device.Name = newName
+	device.Protocols[OnvifProtocol][PreviousName] = oldName
+
+	// the onvif client and its subscriptions are moved to the new name before adding the device, so that
+	// the AddDevice callback re-uses them instead of creating a new client
+	d.moveOnvifClient(oldName, newName)
+
+	d.lc.Infof("Adding device '%s' with the updated name '%s'", oldName, newName)
+	newId, err := d.sdkService.AddDevice(device)
+	if err != nil {
+		d.moveOnvifClient(newName, oldName)
+		return err
+	}
+
+	if !d.waitForDevice(newName, renameTimeout) {
+		d.lc.Warnf("Device '%s' was not added to the cache within %v", newName, renameTimeout)
+	}
 
-func (d *Driver) patchDeviceProtocols(deviceName string, protocols map[string]models.ProtocolProperties) error {
-	return d.sdkService.PatchDevice(dtos.UpdateDevice{
-		Name:      &deviceName,
-		Protocols: dtos.FromProtocolModelsToDTOs(protocols),
+	if onvifClient, ok := d.getOnvifClient(newName); ok && onvifClient.baseNotificationManager != nil {
+		// the consumer reference of base notification subscriptions contains the device name
+		onvifClient.baseNotificationManager.ResubscribeAll()
+	}
+
+	d.publishDeviceChangeEvent(newName, DeviceRenamed, map[string]interface{}{
+		PreviousName: oldName,
+		"PreviousId": oldId,
+		"Name":       newName,
+		"Id":         newId,
 	})
+
+	d.lc.Infof("Removing device '%s' which has been renamed to '%s'", oldName, newName)
+	err = d.sdkService.RemoveDeviceByName(oldName)
+	if err != nil {
+		d.lc.Warnf("An error occurred while removing the device %s: %s", oldName, err)
+	}
+	return nil
+}
+
+// waitForDevice waits for a newly added device to be available in the cache
+func (d *Driver) waitForDevice(deviceName string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !d.sdkService.DeviceExistsForName(deviceName) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(renamePollInterval)
+	}
+	return true
 }
@@ -8,11 +8,13 @@
 package driver
 
 import (
+	"fmt"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/IOTechSystems/onvif"
 	"github.com/IOTechSystems/onvif/xsd"
@@ -250,8 +252,10 @@ func TestRenameOrPatchDevice(t *testing.T) {
 		device  models.Device
 		devInfo *device.GetDeviceInformationResponse
 
+		latestDevice             models.Device
 		expectedDevice           models.Device
 		errorExpected            bool
+		newDeviceExistsExpected  bool
 		updateDeviceExpected     bool
 		addDeviceExpected        bool
 		removeDeviceExpected     bool
@@ -281,6 +285,19 @@ func TestRenameOrPatchDevice(t *testing.T) {
 						EndpointRefAddress: "793dfb2-28b0-11ed-a261-0242ac120002",
 					},
 				}},
+			latestDevice: contract.Device{
+				Id:     "3e8c1ef6-1bd4-4c12-9c5b-f8bc2ba1ed1e",
+				Name:   UnknownDevicePrefix + "device",
+				Labels: []string{"auto-discovery", "lobby"},
+				AutoEvents: []models.AutoEvent{
+					{SourceName: "Snapshot", Interval: "10s"},
+				},
+				Protocols: map[string]models.ProtocolProperties{
+					OnvifProtocol: map[string]interface{}{},
+					CustomMetadata: map[string]interface{}{
+						"Location": "Front Door",
+					},
+				}},
 			devInfo: &device.GetDeviceInformationResponse{
 				Manufacturer:    "Intel",
 				Model:           "SimCamera",
@@ -289,14 +306,40 @@ func TestRenameOrPatchDevice(t *testing.T) {
 				HardwareId:      "1.0",
 			},
 			expectedDevice: contract.Device{
-				Name: "Intel-SimCamera-793dfb2-28b0-11ed-a261-0242ac120002",
+				Name:   "Intel-SimCamera-793dfb2-28b0-11ed-a261-0242ac120002",
+				Labels: []string{"auto-discovery", "lobby"},
+				AutoEvents: []models.AutoEvent{
+					{SourceName: "Snapshot", Interval: "10s"},
+				},
 				Protocols: map[string]models.ProtocolProperties{
 					OnvifProtocol: map[string]interface{}{
 						EndpointRefAddress: "793dfb2-28b0-11ed-a261-0242ac120002",
+						PreviousName:       UnknownDevicePrefix + "device",
+					},
+					CustomMetadata: map[string]interface{}{
+						"Location": "Front Door",
 					},
 				},
 			},
 		},
+		{
+			errorExpected:           true,
+			newDeviceExistsExpected: true,
+			device: contract.Device{
+				Name: UnknownDevicePrefix + "existing",
+				Protocols: map[string]models.ProtocolProperties{
+					OnvifProtocol: map[string]interface{}{
+						EndpointRefAddress: "8a4b5e12-28b0-11ed-a261-0242ac120002",
+					},
+				}},
+			devInfo: &device.GetDeviceInformationResponse{
+				Manufacturer: "Intel",
+				Model:        "SimCamera",
+			},
+			expectedDevice: contract.Device{
+				Name: "Intel-SimCamera-8a4b5e12-28b0-11ed-a261-0242ac120002",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -318,8 +361,17 @@ func TestRenameOrPatchDevice(t *testing.T) {
 				}).Return(nil).Once()
 			}
 
+			if test.newDeviceExistsExpected {
+				mockService.On("DeviceExistsForName", test.expectedDevice.Name).Return(true).Once()
+			}
+
 			if test.addDeviceExpected {
+				mockService.On("DeviceExistsForName", test.expectedDevice.Name).Return(false).Once()
+				mockService.On("GetDeviceByName", test.device.Name).Return(test.latestDevice, nil).Once()
 				mockService.On("AddDevice", test.expectedDevice).Return(test.expectedDevice.Name, nil).Once()
+				mockService.On("DeviceExistsForName", test.expectedDevice.Name).Return(true).Once()
+				// the renamed event is skipped, because the new device has no profile in this test
+				mockService.On("GetDeviceByName", test.expectedDevice.Name).Return(models.Device{}, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, "unit test error", nil)).Once()
 			}
 
 			err := driver.renameOrPatchDevice(test.device, test.devInfo)
@@ -352,3 +404,75 @@ func TestDriver_RemoveDevice(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, driver.onvifClients, 0)
 }
+
+// TestDriver_moveOnvifClient verifies the renamed client is re-keyed while its name is read concurrently
+func TestDriver_moveOnvifClient(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	driver.onvifClients = map[string]*OnvifClient{testDeviceName: client}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = client.deviceName()
+		}
+	}()
+	driver.moveOnvifClient(testDeviceName, "renamed-device")
+	<-done
+
+	assert.Equal(t, "renamed-device", client.deviceName())
+	_, ok := driver.getOnvifClient("renamed-device")
+	assert.True(t, ok)
+	_, ok = driver.getOnvifClient(testDeviceName)
+	assert.False(t, ok)
+}
+
+// TestDriver_Stop verifies that the subscriptions are stopped before the async values channel is closed, without
+// holding the clients lock which the subscription loops need to unsubscribe from the camera
+func TestDriver_Stop(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	asyncCh := make(chan *sdkModel.AsyncValues, 1)
+	mockService.On("AsyncValuesChannel").Return(asyncCh)
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	consumer := &Consumer{
+		Name:                CameraEvent,
+		lc:                  client.lc,
+		manager:             client.baseNotificationManager,
+		onvifClient:         client,
+		subscriptionRequest: &SubscriptionRequest{},
+		terminationDeadline: time.Now().Add(time.Hour),
+		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+		resubscribeRequests: make(chan chan errors.EdgeX, 1),
+	}
+	unsubscribed := make(chan struct{})
+	mockDevice.On("SendSoap", mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+		select {
+		case <-asyncCh:
+			t.Error("the async values channel was closed before the subscription was stopped")
+		default:
+		}
+		close(unsubscribed)
+	}).Return(nil, fmt.Errorf("unavailable")).Once()
+	client.baseNotificationManager.addConsumer(consumer)
+	go consumer.StartRenewLoop()
+	driver.onvifClients[testDeviceName] = client
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- driver.Stop(false)
+	}()
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+	<-unsubscribed
+	_, ok := <-asyncCh
+	assert.False(t, ok)
+	assert.Empty(t, driver.onvifClients)
+}
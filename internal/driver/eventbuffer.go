@@ -0,0 +1,456 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	// DropOldestEvent is the EventBufferDropPolicy which drops the oldest buffered event when the buffer is full
+	DropOldestEvent = "oldest"
+	// DropNewestEvent is the EventBufferDropPolicy which drops the received event when the buffer is full
+	DropNewestEvent = "newest"
+
+	// eventBufferFlushInterval is the interval at which a changed event buffer is persisted
+	eventBufferFlushInterval = time.Second
+)
+
+// eventBufferConfig is the event buffer configuration of the device service
+type eventBufferConfig struct {
+	size       int
+	dropOldest bool
+}
+
+// eventBuffer queues the async values of a camera, so that the camera subscriptions keep being serviced while the
+// message bus is unavailable. The values are forwarded in order to the async values channel of the SDK by a single
+// goroutine, and the buffer is optionally persisted to a file so that the backlog survives a restart.
+type eventBuffer struct {
+	lc logger.LoggingClient
+	ch chan<- *sdkModel.AsyncValues
+
+	mu         sync.Mutex
+	deviceName string
+	// path is the file the buffer is persisted to, or empty if the buffer is kept in memory only
+	path       string
+	queue      []*sdkModel.AsyncValues
+	dirty      bool
+	overflowed uint64
+	// overflowing indicates events were dropped since the buffer was last drained
+	overflowing bool
+
+	notify   chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// persistedAsyncValues is the file format of the buffered async values
+type persistedAsyncValues struct {
+	DeviceName    string
+	SourceName    string `json:",omitempty"`
+	CommandValues []persistedCommandValue
+}
+
+type persistedCommandValue struct {
+	DeviceResourceName string
+	Type               string
+	Value              json.RawMessage
+	Origin             int64
+	Tags               map[string]string `json:",omitempty"`
+}
+
+func newEventBuffer(lc logger.LoggingClient, deviceName string, ch chan<- *sdkModel.AsyncValues, dir string) *eventBuffer {
+	return &eventBuffer{
+		lc:         lc,
+		ch:         ch,
+		deviceName: deviceName,
+		path:       eventBufferPath(dir, deviceName),
+		notify:     make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// eventBufferPath returns the file the event buffer of the device is persisted to, or empty if dir is empty
+func eventBufferPath(dir, deviceName string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, url.PathEscape(deviceName)+".json")
+}
+
+// eventBufferConfig returns the event buffer configuration of the device service. An unknown drop policy is
+// replaced by the default policy, which drops the oldest events, see validateEventBufferDropPolicy.
+func (d *Driver) eventBufferConfig() eventBufferConfig {
+	d.configMu.RLock()
+	size := d.config.AppCustom.EventBufferSize
+	policy := d.config.AppCustom.EventBufferDropPolicy
+	d.configMu.RUnlock()
+
+	dropOldest, _ := parseEventBufferDropPolicy(policy)
+	return eventBufferConfig{size: size, dropOldest: dropOldest}
+}
+
+// parseEventBufferDropPolicy returns true if the policy drops the oldest events, and false if the policy is unknown
+func parseEventBufferDropPolicy(policy string) (dropOldest bool, ok bool) {
+	switch strings.ToLower(policy) {
+	case DropOldestEvent, "":
+		return true, true
+	case DropNewestEvent:
+		return false, true
+	default:
+		return true, false
+	}
+}
+
+// validateEventBufferDropPolicy warns about an unknown EventBufferDropPolicy when the configuration is loaded or
+// changed, rather than for every buffered event
+func (d *Driver) validateEventBufferDropPolicy(policy string) {
+	if _, ok := parseEventBufferDropPolicy(policy); !ok {
+		d.lc.Warnf("Ignoring the unknown EventBufferDropPolicy '%s', the oldest events are dropped", policy)
+	}
+}
+
+// newEventBuffer creates the event buffer of a camera with the configured persistence directory
+func (d *Driver) newEventBuffer(deviceName string) *eventBuffer {
+	d.configMu.RLock()
+	dir := d.config.AppCustom.EventBufferDir
+	d.configMu.RUnlock()
+	return newEventBuffer(d.lc, deviceName, d.sdkService.AsyncValuesChannel(), dir)
+}
+
+// sendAsyncValues sends the async values of a camera to north bound through the event buffer of the camera, so that
+// the caller is not blocked by an unavailable message bus. The values are sent directly to the async values channel
+// if the camera has no event buffer or the buffer is disabled.
+func (d *Driver) sendAsyncValues(deviceName string, asyncValues []*sdkModel.AsyncValues) {
+	config := d.eventBufferConfig()
+	if onvifClient, ok := d.getOnvifClient(deviceName); ok && onvifClient.eventBuffer != nil && config.size > 0 {
+		for _, values := range asyncValues {
+			onvifClient.eventBuffer.enqueue(values, config)
+		}
+		return
+	}
+	for _, values := range asyncValues {
+		d.sdkService.AsyncValuesChannel() <- values
+	}
+}
+
+// start restores the persisted backlog and starts forwarding the buffered values
+func (buffer *eventBuffer) start() {
+	if edgexErr := buffer.restore(); edgexErr != nil {
+		buffer.lc.Errorf("Unable to restore the buffered events of device %s, %v", buffer.deviceName, edgexErr)
+	}
+	buffer.wg.Add(1)
+	go func() {
+		defer buffer.wg.Done()
+		buffer.forward()
+	}()
+}
+
+// stop stops forwarding the buffered values, and persists the remaining values or discards them. Values enqueued
+// after the buffer is stopped are kept in memory only.
+func (buffer *eventBuffer) stop(persist bool) {
+	buffer.stopOnce.Do(func() {
+		close(buffer.stopCh)
+	})
+	buffer.wg.Wait()
+
+	if persist {
+		buffer.flush()
+		return
+	}
+	buffer.mu.Lock()
+	discarded := len(buffer.queue)
+	buffer.queue = nil
+	buffer.dirty = false
+	path := buffer.path
+	buffer.mu.Unlock()
+	if discarded > 0 {
+		buffer.lc.Warnf("Discarded %d buffered events of device %s", discarded, buffer.deviceName)
+	}
+	if path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			buffer.lc.Warnf("Unable to remove the event buffer file %s, %v", path, err)
+		}
+	}
+}
+
+// enqueue adds the values to the buffer. When the buffer is full, either the oldest buffered values or the
+// received values are dropped according to the drop policy.
+func (buffer *eventBuffer) enqueue(values *sdkModel.AsyncValues, config eventBufferConfig) {
+	buffer.mu.Lock()
+	if len(buffer.queue) >= config.size {
+		if !buffer.overflowing {
+			buffer.overflowing = true
+			policy := DropNewestEvent
+			if config.dropOldest {
+				policy = DropOldestEvent
+			}
+			buffer.lc.Warnf("The event buffer of device %s is full with %d events, dropping the %s events until the message bus recovers",
+				buffer.deviceName, len(buffer.queue), policy)
+		}
+		if !config.dropOldest {
+			buffer.overflowed++
+			buffer.mu.Unlock()
+			return
+		}
+		// more than one event is dropped if the buffer size was reduced by a configuration change
+		drop := len(buffer.queue) - config.size + 1
+		buffer.overflowed += uint64(drop)
+		buffer.queue = append(buffer.queue[:0], buffer.queue[drop:]...)
+	}
+	buffer.queue = append(buffer.queue, values)
+	buffer.dirty = true
+	buffer.mu.Unlock()
+
+	select {
+	case buffer.notify <- struct{}{}:
+	default:
+	}
+}
+
+// forward sends the buffered values in order to the async values channel until the buffer is stopped
+func (buffer *eventBuffer) forward() {
+	ticker := time.NewTicker(eventBufferFlushInterval)
+	defer ticker.Stop()
+	for {
+		values, ok := buffer.head()
+		if !ok {
+			select {
+			case <-buffer.notify:
+			case <-ticker.C:
+				buffer.flush()
+			case <-buffer.stopCh:
+				return
+			}
+			continue
+		}
+
+		select {
+		case buffer.ch <- values:
+			buffer.sent(values)
+		case <-ticker.C:
+			buffer.flush()
+		case <-buffer.stopCh:
+			return
+		}
+	}
+}
+
+func (buffer *eventBuffer) head() (*sdkModel.AsyncValues, bool) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if len(buffer.queue) == 0 {
+		return nil, false
+	}
+	return buffer.queue[0], true
+}
+
+// sent removes the values from the head of the buffer after they are sent. The values are not at the head
+// anymore if they were dropped while the channel was blocked.
+func (buffer *eventBuffer) sent(values *sdkModel.AsyncValues) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	if len(buffer.queue) == 0 || buffer.queue[0] != values {
+		return
+	}
+	buffer.queue[0] = nil
+	buffer.queue = buffer.queue[1:]
+	buffer.dirty = true
+	if len(buffer.queue) == 0 && buffer.overflowing {
+		buffer.overflowing = false
+		buffer.lc.Infof("The event buffer of device %s is drained, %d events were dropped in total", buffer.deviceName, buffer.overflowed)
+	}
+}
+
+// rename updates the device name of the buffered values of a renamed device, and moves the persisted buffer
+func (buffer *eventBuffer) rename(newName string) {
+	buffer.mu.Lock()
+	oldPath := buffer.path
+	buffer.deviceName = newName
+	if oldPath != "" {
+		buffer.path = eventBufferPath(filepath.Dir(oldPath), newName)
+	}
+	for _, values := range buffer.queue {
+		values.DeviceName = newName
+	}
+	buffer.dirty = true
+	buffer.mu.Unlock()
+
+	if oldPath != "" {
+		if err := os.Remove(oldPath); err != nil && !os.IsNotExist(err) {
+			buffer.lc.Warnf("Unable to remove the event buffer file %s, %v", oldPath, err)
+		}
+	}
+}
+
+// overflowedEvents returns the number of events dropped because the buffer was full
+func (buffer *eventBuffer) overflowedEvents() uint64 {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.overflowed
+}
+
+// flush persists the buffer if it changed since it was last persisted. The file is removed when the buffer is empty.
+func (buffer *eventBuffer) flush() {
+	buffer.mu.Lock()
+	if buffer.path == "" || !buffer.dirty {
+		buffer.mu.Unlock()
+		return
+	}
+	path := buffer.path
+	queue := make([]*sdkModel.AsyncValues, len(buffer.queue))
+	copy(queue, buffer.queue)
+	buffer.dirty = false
+	buffer.mu.Unlock()
+
+	if edgexErr := buffer.persist(path, queue); edgexErr != nil {
+		buffer.lc.Errorf("Unable to persist the buffered events of device %s, %v", buffer.deviceName, edgexErr)
+		buffer.mu.Lock()
+		buffer.dirty = true
+		buffer.mu.Unlock()
+	}
+}
+
+func (buffer *eventBuffer) persist(path string, queue []*sdkModel.AsyncValues) errors.EdgeX {
+	if len(queue) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.NewCommonEdgeX(errors.KindIOError, fmt.Sprintf("failed to remove the event buffer file %s", path), err)
+		}
+		return nil
+	}
+
+	persisted := make([]persistedAsyncValues, 0, len(queue))
+	for _, values := range queue {
+		item := persistedAsyncValues{DeviceName: values.DeviceName, SourceName: values.SourceName}
+		for _, cv := range values.CommandValues {
+			value, err := json.Marshal(cv.Value)
+			if err != nil {
+				buffer.lc.Warnf("Unable to persist the buffered %s reading of device %s, %v", cv.DeviceResourceName, values.DeviceName, err)
+				continue
+			}
+			item.CommandValues = append(item.CommandValues, persistedCommandValue{
+				DeviceResourceName: cv.DeviceResourceName,
+				Type:               cv.Type,
+				Value:              value,
+				Origin:             cv.Origin,
+				Tags:               cv.Tags,
+			})
+		}
+		persisted = append(persisted, item)
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to encode the buffered events", err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return errors.NewCommonEdgeX(errors.KindIOError, fmt.Sprintf("failed to create the event buffer directory of %s", path), err)
+	}
+	// the buffer is written to a temporary file first, so that a crash never leaves a truncated buffer behind
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return errors.NewCommonEdgeX(errors.KindIOError, fmt.Sprintf("failed to write the event buffer file %s", tmpPath), err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return errors.NewCommonEdgeX(errors.KindIOError, fmt.Sprintf("failed to replace the event buffer file %s", path), err)
+	}
+	return nil
+}
+
+// restore loads the persisted buffer in front of the buffered values
+func (buffer *eventBuffer) restore() errors.EdgeX {
+	if buffer.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(buffer.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindIOError, fmt.Sprintf("failed to read the event buffer file %s", buffer.path), err)
+	}
+	var persisted []persistedAsyncValues
+	if err = json.Unmarshal(data, &persisted); err != nil {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("failed to decode the event buffer file %s", buffer.path), err)
+	}
+
+	restored := make([]*sdkModel.AsyncValues, 0, len(persisted))
+	for _, item := range persisted {
+		values := &sdkModel.AsyncValues{DeviceName: buffer.deviceName, SourceName: item.SourceName}
+		for _, p := range item.CommandValues {
+			cv, edgexErr := p.commandValue()
+			if edgexErr != nil {
+				buffer.lc.Warnf("Unable to restore the buffered %s reading of device %s, %v", p.DeviceResourceName, buffer.deviceName, edgexErr)
+				continue
+			}
+			values.CommandValues = append(values.CommandValues, cv)
+		}
+		if len(values.CommandValues) > 0 {
+			restored = append(restored, values)
+		}
+	}
+
+	buffer.mu.Lock()
+	buffer.queue = append(restored, buffer.queue...)
+	buffer.mu.Unlock()
+	if len(restored) > 0 {
+		buffer.lc.Infof("Restored %d buffered events of device %s", len(restored), buffer.deviceName)
+	}
+	return nil
+}
+
+// commandValue decodes the persisted value according to its value type
+func (p persistedCommandValue) commandValue() (*sdkModel.CommandValue, errors.EdgeX) {
+	var value any
+	switch p.Type {
+	case common.ValueTypeBinary:
+		var binary []byte
+		if err := json.Unmarshal(p.Value, &binary); err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to decode the binary value", err)
+		}
+		value = binary
+	case common.ValueTypeString:
+		var str string
+		if err := json.Unmarshal(p.Value, &str); err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to decode the string value", err)
+		}
+		value = str
+	case common.ValueTypeObject:
+		if err := json.Unmarshal(p.Value, &value); err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to decode the object value", err)
+		}
+	default:
+		// the bool and numeric values are encoded as JSON literals, which are parsed like the event items
+		cv, edgexErr := newCommandValueFromString(p.DeviceResourceName, p.Type, string(p.Value))
+		if edgexErr != nil {
+			return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+		value = cv.Value
+	}
+
+	cv, err := sdkModel.NewCommandValueWithOrigin(p.DeviceResourceName, p.Type, value, p.Origin)
+	if err != nil {
+		return nil, errors.NewCommonEdgeXWrapper(err)
+	}
+	if p.Tags != nil {
+		cv.Tags = p.Tags
+	}
+	return cv, nil
+}
@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAsyncValues(t *testing.T, resourceName string, valueType string, value any) *sdkModel.AsyncValues {
+	cv, err := sdkModel.NewCommandValueWithOrigin(resourceName, valueType, value, time.Now().UnixNano())
+	require.NoError(t, err)
+	return &sdkModel.AsyncValues{DeviceName: testDeviceName, CommandValues: []*sdkModel.CommandValue{cv}}
+}
+
+func TestEventBuffer_dropPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		dropOldest bool
+		expected   []string
+	}{
+		{name: DropOldestEvent, dropOldest: true, expected: []string{"2", "3"}},
+		{name: DropNewestEvent, dropOldest: false, expected: []string{"1", "2"}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			// the buffer is not started, so that the values stay buffered
+			buffer := newEventBuffer(logger.NewMockClient(), testDeviceName, nil, "")
+			config := eventBufferConfig{size: 2, dropOldest: test.dropOldest}
+			for _, value := range []string{"1", "2", "3"} {
+				buffer.enqueue(newTestAsyncValues(t, CameraEvent, common.ValueTypeString, value), config)
+			}
+
+			var values []string
+			for _, buffered := range buffer.queue {
+				values = append(values, buffered.CommandValues[0].Value.(string))
+			}
+			assert.Equal(t, test.expected, values)
+			assert.Equal(t, uint64(1), buffer.overflowedEvents())
+		})
+	}
+}
+
+func TestEventBuffer_replay(t *testing.T) {
+	ch := make(chan *sdkModel.AsyncValues)
+	buffer := newEventBuffer(logger.NewMockClient(), testDeviceName, ch, "")
+	buffer.start()
+	defer buffer.stop(false)
+
+	// the values are buffered while nothing reads the channel, and replayed in order when it is read again
+	config := eventBufferConfig{size: 10, dropOldest: true}
+	expected := []string{"1", "2", "3", "4"}
+	for _, value := range expected {
+		buffer.enqueue(newTestAsyncValues(t, CameraEvent, common.ValueTypeString, value), config)
+	}
+	for _, value := range expected {
+		select {
+		case values := <-ch:
+			assert.Equal(t, value, values.CommandValues[0].Value)
+		case <-time.After(time.Second):
+			require.Fail(t, "the buffered values were not replayed")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		_, ok := buffer.head()
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestEventBuffer_persistence(t *testing.T) {
+	dir := t.TempDir()
+	path := eventBufferPath(dir, testDeviceName)
+	expected := []*sdkModel.AsyncValues{
+		newTestAsyncValues(t, "MotionAlarm", common.ValueTypeBool, true),
+		newTestAsyncValues(t, "Temperature", common.ValueTypeFloat64, 21.5),
+		newTestAsyncValues(t, "Counter", common.ValueTypeUint64, uint64(18446744073709551615)),
+		newTestAsyncValues(t, CameraEvent, common.ValueTypeObject, map[string]any{"Topic": "tns1:VideoSource/MotionAlarm"}),
+		newTestAsyncValues(t, "Snapshot", common.ValueTypeBinary, []byte("jpeg")),
+	}
+	expected[0].CommandValues[0].Tags = map[string]string{"topic": "tns1:VideoSource/MotionAlarm"}
+
+	// nothing reads the channel, so the values are persisted when the buffer is stopped
+	buffer := newEventBuffer(logger.NewMockClient(), testDeviceName, make(chan *sdkModel.AsyncValues), dir)
+	buffer.start()
+	config := eventBufferConfig{size: 10, dropOldest: true}
+	for _, values := range expected {
+		buffer.enqueue(values, config)
+	}
+	buffer.stop(true)
+	_, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// the persisted values are replayed by the buffer of the restarted service
+	ch := make(chan *sdkModel.AsyncValues, len(expected))
+	buffer = newEventBuffer(logger.NewMockClient(), testDeviceName, ch, dir)
+	buffer.start()
+	for _, values := range expected {
+		select {
+		case restored := <-ch:
+			assert.Equal(t, values, restored)
+		case <-time.After(time.Second):
+			require.Fail(t, "the persisted values were not replayed")
+		}
+	}
+
+	// the file is removed once the buffer is drained
+	buffer.stop(true)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseEventBufferDropPolicy(t *testing.T) {
+	dropOldest, ok := parseEventBufferDropPolicy("")
+	assert.True(t, ok)
+	assert.True(t, dropOldest)
+	dropOldest, ok = parseEventBufferDropPolicy("Newest")
+	assert.True(t, ok)
+	assert.False(t, dropOldest)
+	dropOldest, ok = parseEventBufferDropPolicy("random")
+	assert.False(t, ok)
+	assert.True(t, dropOldest)
+}
@@ -0,0 +1,335 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	GetDroppedEvents = "GetDroppedEvents"
+
+	// anyEventTopic is the EventDebounceWindows topic which applies to the topics without their own window
+	anyEventTopic = "*"
+)
+
+// DroppedEvents is the response of the GetDroppedEvents command, which counts the events dropped by the event filter
+// and the event buffer since the device service started
+type DroppedEvents struct {
+	// Duplicate counts the property events which repeated the current state of their topic and source
+	Duplicate uint64
+	// Debounced counts the events which were received within the debounce window of their topic and source, and
+	// were superseded by a later event before the window closed
+	Debounced uint64
+	// RateLimited counts the events which exceeded the MaxEventsPerMinute of the device, and were superseded by a
+	// later event of their topic and source before a token was available
+	RateLimited uint64
+	// Overflowed counts the events which were dropped because the event buffer of the device was full
+	Overflowed uint64
+	// Topics counts the dropped events of each topic
+	Topics map[string]uint64 `json:",omitempty"`
+}
+
+// eventFilter drops duplicate, debounced and rate limited events of a camera. The last debounced or rate limited
+// event of each topic and source is held back, and delivered once its debounce window closes and a token is
+// available, so that the trailing state of a property is always published. The zero value is a filter which has
+// not published any event.
+type eventFilter struct {
+	mu            sync.Mutex
+	lastPublished map[string]time.Time
+	tokens        float64
+	lastRefill    time.Time
+	dropped       DroppedEvents
+	// pending is the held back event of each topic and source
+	pending map[string]pendingEvent
+	// config is the configuration of the last filtered events, which is applied to the pending events
+	config eventFilterConfig
+	timer  *time.Timer
+	// deliver publishes the pending events once they are due. The pending events are only delivered by flush
+	// if it is nil.
+	deliver func(route eventRoute, readings []EventReading)
+}
+
+// pendingEvent is an event held back by the event filter
+type pendingEvent struct {
+	reading EventReading
+	route   eventRoute
+	// counter counts the event as dropped if it is superseded
+	counter *uint64
+}
+
+// eventFilterConfig is the event filter configuration of the device service
+type eventFilterConfig struct {
+	debounceWindows    map[string]time.Duration
+	suppressDuplicates bool
+	maxEventsPerMinute int
+}
+
+// parseDebounceWindows parses a comma separated list of Topic=Milliseconds pairs
+func parseDebounceWindows(value string) (map[string]time.Duration, errors.EdgeX) {
+	windows := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// split on the last '=', as the topic may contain one in a message content expression
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid debounce window '%s', the format should be Topic=Milliseconds", pair), nil)
+		}
+		millis, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+		if err != nil || millis < 0 {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid debounce window '%s', the format should be Topic=Milliseconds", pair), err)
+		}
+		windows[strings.TrimSpace(pair[:i])] = time.Duration(millis) * time.Millisecond
+	}
+	return windows, nil
+}
+
+// updateDebounceWindows parses the EventDebounceWindows configuration when it is loaded or changed, so that the
+// events are not delayed by parsing it. Invalid debounce windows are logged and ignored.
+func (d *Driver) updateDebounceWindows(value string) {
+	windows, edgexErr := parseDebounceWindows(value)
+	if edgexErr != nil {
+		d.lc.Warnf("Ignoring the EventDebounceWindows configuration, %v", edgexErr)
+	}
+	d.configMu.Lock()
+	d.debounceWindows = windows
+	d.configMu.Unlock()
+}
+
+// eventFilterConfig returns the current event filter configuration
+func (d *Driver) eventFilterConfig() eventFilterConfig {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return eventFilterConfig{
+		debounceWindows:    d.debounceWindows,
+		suppressDuplicates: d.config.AppCustom.SuppressDuplicateEvents,
+		maxEventsPerMinute: d.config.AppCustom.MaxEventsPerMinute,
+	}
+}
+
+// filter returns the readings which should be published. The duplicate flags indicate which readings repeat
+// the current state of their topic and source. The debounced and rate limited readings are held back until they are
+// due, see flush.
+func (filter *eventFilter) filter(readings []EventReading, duplicates []bool, route eventRoute, config eventFilterConfig, now time.Time) []EventReading {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	filter.config = config
+
+	published := make([]EventReading, 0, len(readings))
+	for i, reading := range readings {
+		key := eventStateKey(reading)
+		switch {
+		case config.suppressDuplicates && duplicates[i]:
+			filter.drop(reading, &filter.dropped.Duplicate)
+		case filter.debounced(key, reading.Topic, config.debounceWindows, now):
+			filter.hold(key, pendingEvent{reading: reading, route: route, counter: &filter.dropped.Debounced})
+		case !filter.takeToken(config.maxEventsPerMinute, now):
+			filter.hold(key, pendingEvent{reading: reading, route: route, counter: &filter.dropped.RateLimited})
+		default:
+			filter.supersede(key)
+			filter.published(key, now)
+			published = append(published, reading)
+		}
+	}
+	filter.schedule(now)
+	return published
+}
+
+// flush returns the pending events which are due, grouped by route
+func (filter *eventFilter) flush(now time.Time) map[eventRoute][]EventReading {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+
+	keys := make([]string, 0, len(filter.pending))
+	for key := range filter.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	due := make(map[eventRoute][]EventReading)
+	for _, key := range keys {
+		event := filter.pending[key]
+		if filter.debounced(key, event.reading.Topic, filter.config.debounceWindows, now) ||
+			!filter.takeToken(filter.config.maxEventsPerMinute, now) {
+			continue
+		}
+		delete(filter.pending, key)
+		filter.published(key, now)
+		due[event.route] = append(due[event.route], event.reading)
+	}
+	filter.schedule(now)
+	return due
+}
+
+// stop stops the delivery of the pending events
+func (filter *eventFilter) stop() {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	filter.deliver = nil
+	if filter.timer != nil {
+		filter.timer.Stop()
+		filter.timer = nil
+	}
+}
+
+// schedule starts the timer which delivers the pending events, at the time the first one may be due
+func (filter *eventFilter) schedule(now time.Time) {
+	if filter.timer != nil {
+		filter.timer.Stop()
+		filter.timer = nil
+	}
+	if len(filter.pending) == 0 || filter.deliver == nil {
+		return
+	}
+
+	var next time.Time
+	for key, event := range filter.pending {
+		due := now
+		if window := filter.debounceWindow(event.reading.Topic, filter.config.debounceWindows); window > 0 {
+			if last, ok := filter.lastPublished[key]; ok && last.Add(window).After(due) {
+				due = last.Add(window)
+			}
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	if filter.config.maxEventsPerMinute > 0 && filter.tokens < 1 {
+		refill := now.Add(time.Duration((1 - filter.tokens) / float64(filter.config.maxEventsPerMinute) * float64(time.Minute)))
+		if refill.After(next) {
+			next = refill
+		}
+	}
+
+	filter.timer = time.AfterFunc(next.Sub(now), func() {
+		due := filter.flush(time.Now())
+		filter.mu.Lock()
+		deliver := filter.deliver
+		filter.mu.Unlock()
+		if deliver == nil {
+			return
+		}
+		for route, readings := range due {
+			deliver(route, readings)
+		}
+	})
+}
+
+// hold keeps the event until it is due, superseding the pending event of its topic and source
+func (filter *eventFilter) hold(key string, event pendingEvent) {
+	filter.supersede(key)
+	if filter.pending == nil {
+		filter.pending = make(map[string]pendingEvent)
+	}
+	filter.pending[key] = event
+}
+
+// supersede drops the pending event of the topic and source, since a later event was received
+func (filter *eventFilter) supersede(key string) {
+	if event, ok := filter.pending[key]; ok {
+		delete(filter.pending, key)
+		filter.drop(event.reading, event.counter)
+	}
+}
+
+func (filter *eventFilter) published(key string, now time.Time) {
+	if filter.lastPublished == nil {
+		filter.lastPublished = make(map[string]time.Time)
+	}
+	filter.lastPublished[key] = now
+}
+
+func (filter *eventFilter) debounced(key, topic string, windows map[string]time.Duration, now time.Time) bool {
+	window := filter.debounceWindow(topic, windows)
+	last, ok := filter.lastPublished[key]
+	return ok && window > 0 && now.Sub(last) < window
+}
+
+func (filter *eventFilter) debounceWindow(topic string, windows map[string]time.Duration) time.Duration {
+	window, ok := windows[topic]
+	if !ok {
+		window = windows[anyEventTopic]
+	}
+	return window
+}
+
+// takeToken implements a token bucket which allows bursts of up to a minute of events
+func (filter *eventFilter) takeToken(maxEventsPerMinute int, now time.Time) bool {
+	if maxEventsPerMinute <= 0 {
+		return true
+	}
+	capacity := float64(maxEventsPerMinute)
+	if filter.lastRefill.IsZero() {
+		filter.tokens = capacity
+	} else {
+		filter.tokens += now.Sub(filter.lastRefill).Minutes() * capacity
+		if filter.tokens > capacity {
+			filter.tokens = capacity
+		}
+	}
+	filter.lastRefill = now
+	if filter.tokens < 1 {
+		return false
+	}
+	filter.tokens--
+	return true
+}
+
+func (filter *eventFilter) drop(reading EventReading, counter *uint64) {
+	*counter++
+	if filter.dropped.Topics == nil {
+		filter.dropped.Topics = make(map[string]uint64)
+	}
+	filter.dropped.Topics[reading.Topic]++
+}
+
+// droppedEvents returns a copy of the dropped event counters
+func (filter *eventFilter) droppedEvents() DroppedEvents {
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	dropped := filter.dropped
+	dropped.Topics = maps.Clone(filter.dropped.Topics)
+	return dropped
+}
+
+// isDuplicate returns true if the property event has the same data as the current state of its topic and source.
+// Initialized events are never duplicates, since the camera sends them on purpose, for example when a subscription
+// is created or a synchronization point is requested.
+func (table *eventStateTable) isDuplicate(reading EventReading) bool {
+	if reading.PropertyOperation != PropertyChanged {
+		return false
+	}
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	state, ok := table.states[eventStateKey(reading)]
+	return ok && maps.Equal(state.reading.Data, reading.Data)
+}
+
+func (onvifClient *OnvifClient) callGetDroppedEventsFunction(resourceName string) (*sdkModel.CommandValue, errors.EdgeX) {
+	dropped := onvifClient.eventFilter.droppedEvents()
+	if onvifClient.eventBuffer != nil {
+		dropped.Overflowed = onvifClient.eventBuffer.overflowedEvents()
+	}
+	cv, err := sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, dropped)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the function '%s'", GetDroppedEvents), err)
+	}
+	return cv, nil
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDebounceWindows(t *testing.T) {
+	windows, err := parseDebounceWindows(" tns1:RuleEngine/CellMotionDetector/Motion=2000, *=500 ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"tns1:RuleEngine/CellMotionDetector/Motion": 2 * time.Second,
+		anyEventTopic: 500 * time.Millisecond,
+	}, windows)
+
+	windows, err = parseDebounceWindows("")
+	require.NoError(t, err)
+	assert.Empty(t, windows)
+
+	_, err = parseDebounceWindows("tns1:VideoSource/MotionAlarm")
+	require.Error(t, err)
+	_, err = parseDebounceWindows("tns1:VideoSource/MotionAlarm=-1")
+	require.Error(t, err)
+}
+
+func TestDriver_updateDebounceWindows(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	driver.updateDebounceWindows("tns1:RuleEngine/CellMotionDetector/Motion=2000")
+	assert.Equal(t, map[string]time.Duration{"tns1:RuleEngine/CellMotionDetector/Motion": 2 * time.Second}, driver.eventFilterConfig().debounceWindows)
+
+	// the invalid configuration is ignored
+	driver.updateDebounceWindows("tns1:VideoSource/MotionAlarm")
+	assert.Empty(t, driver.eventFilterConfig().debounceWindows)
+}
+
+func TestEventFilter_filter(t *testing.T) {
+	motion := EventReading{Topic: testMotionTopic, PropertyOperation: PropertyChanged, Source: map[string]string{"Source": "0"}}
+	otherSource := EventReading{Topic: testMotionTopic, PropertyOperation: PropertyChanged, Source: map[string]string{"Source": "1"}}
+	route := eventRoute{resourceName: CameraEvent, byTopic: true}
+	start := time.Now()
+
+	t.Run("duplicates", func(t *testing.T) {
+		filter := eventFilter{}
+		config := eventFilterConfig{suppressDuplicates: true}
+		published := filter.filter([]EventReading{motion, motion}, []bool{false, true}, route, config, start)
+		assert.Len(t, published, 1)
+		assert.Equal(t, uint64(1), filter.droppedEvents().Duplicate)
+
+		config.suppressDuplicates = false
+		published = filter.filter([]EventReading{motion}, []bool{true}, route, config, start)
+		assert.Len(t, published, 1)
+	})
+
+	t.Run("debounce", func(t *testing.T) {
+		filter := eventFilter{}
+		config := eventFilterConfig{debounceWindows: map[string]time.Duration{testMotionTopic: time.Second}}
+		assert.Len(t, filter.filter([]EventReading{motion, otherSource}, []bool{false, false}, route, config, start), 2)
+		assert.Empty(t, filter.filter([]EventReading{motion}, []bool{false}, route, config, start.Add(500*time.Millisecond)))
+		assert.Len(t, filter.filter([]EventReading{motion}, []bool{false}, route, config, start.Add(time.Second)), 1)
+
+		dropped := filter.droppedEvents()
+		assert.Equal(t, uint64(1), dropped.Debounced, "the held back event is superseded")
+		assert.Equal(t, map[string]uint64{testMotionTopic: 1}, dropped.Topics)
+	})
+
+	t.Run("trailing debounced event", func(t *testing.T) {
+		filter := eventFilter{}
+		config := eventFilterConfig{debounceWindows: map[string]time.Duration{testMotionTopic: time.Second}}
+		on := motion
+		on.Data = map[string]string{"IsMotion": "true"}
+		off := motion
+		off.Data = map[string]string{"IsMotion": "false"}
+		assert.Len(t, filter.filter([]EventReading{on}, []bool{false}, route, config, start), 1)
+		assert.Empty(t, filter.filter([]EventReading{off}, []bool{false}, route, config, start.Add(100*time.Millisecond)))
+		assert.Empty(t, filter.filter([]EventReading{on}, []bool{false}, route, config, start.Add(200*time.Millisecond)))
+		assert.Empty(t, filter.filter([]EventReading{off}, []bool{false}, route, config, start.Add(300*time.Millisecond)))
+
+		assert.Empty(t, filter.flush(start.Add(500*time.Millisecond)), "the window is still open")
+		due := filter.flush(start.Add(time.Second))
+		require.Len(t, due[route], 1)
+		assert.Equal(t, "false", due[route][0].Data["IsMotion"], "the last state is delivered when the window closes")
+		assert.Empty(t, filter.flush(start.Add(2*time.Second)))
+		assert.Equal(t, uint64(2), filter.droppedEvents().Debounced)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		filter := eventFilter{}
+		config := eventFilterConfig{maxEventsPerMinute: 2}
+		published := filter.filter([]EventReading{motion, otherSource, motion}, []bool{false, false, false}, route, config, start)
+		assert.Len(t, published, 2)
+		assert.Zero(t, filter.droppedEvents().RateLimited, "the third event is held back")
+
+		// one token is refilled every 30 seconds, which delivers the held back event
+		assert.Empty(t, filter.flush(start.Add(15*time.Second)))
+		due := filter.flush(start.Add(30 * time.Second))
+		assert.Equal(t, []EventReading{motion}, due[route])
+		assert.Empty(t, filter.filter([]EventReading{otherSource}, []bool{false}, route, config, start.Add(31*time.Second)))
+		assert.Empty(t, filter.filter([]EventReading{otherSource}, []bool{false}, route, config, start.Add(32*time.Second)))
+		assert.Equal(t, uint64(1), filter.droppedEvents().RateLimited, "the held back event is superseded")
+	})
+
+	t.Run("delivery", func(t *testing.T) {
+		delivered := make(chan []EventReading, 1)
+		filter := eventFilter{deliver: func(route eventRoute, readings []EventReading) { delivered <- readings }}
+		defer filter.stop()
+		config := eventFilterConfig{debounceWindows: map[string]time.Duration{testMotionTopic: 50 * time.Millisecond}}
+		now := time.Now()
+		assert.Len(t, filter.filter([]EventReading{motion}, []bool{false}, route, config, now), 1)
+		assert.Empty(t, filter.filter([]EventReading{motion}, []bool{false}, route, config, now))
+		select {
+		case readings := <-delivered:
+			assert.Equal(t, []EventReading{motion}, readings)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the held back event was not delivered")
+		}
+	})
+}
+
+func TestEventStateTable_isDuplicate(t *testing.T) {
+	table := eventStateTable{}
+	reading := EventReading{Topic: testMotionTopic, PropertyOperation: PropertyInitialized, Data: map[string]string{"IsMotion": "true"}}
+	assert.False(t, table.isDuplicate(reading))
+
+	table.update([]EventReading{reading})
+	assert.False(t, table.isDuplicate(reading), "initialized events are never duplicates")
+	reading.PropertyOperation = PropertyChanged
+	assert.True(t, table.isDuplicate(reading))
+
+	reading.Data = map[string]string{"IsMotion": "false"}
+	assert.False(t, table.isDuplicate(reading))
+	reading.PropertyOperation = ""
+	assert.False(t, table.isDuplicate(reading), "events without a property operation are never duplicates")
+}
@@ -0,0 +1,391 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edgexfoundry/device-sdk-go/v3/pkg/interfaces"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+// EventReading is the normalized form of an onvif NotificationMessage, which is sent to north bound as an Object reading
+type EventReading struct {
+	Topic string
+	// UtcTime is the RFC 3339 time at which the camera produced the message
+	UtcTime string `json:",omitempty"`
+	// PropertyOperation is Initialized, Changed or Deleted for property events, and empty for other events
+	PropertyOperation string `json:",omitempty"`
+	// Source holds the SimpleItems which identify the source of the event, such as the VideoSourceConfigurationToken
+	Source map[string]string `json:",omitempty"`
+	// Data holds the SimpleItems which describe the event, such as IsMotion
+	Data map[string]string `json:",omitempty"`
+}
+
+type simpleItemElement struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value,attr"`
+}
+
+// notificationMessageElement is the minimal representation of a wsnt:NotificationMessage. The message description
+// of the onvif library does not contain the UtcTime attribute, so the messages are parsed by the device service.
+type notificationMessageElement struct {
+	Topic   string
+	Message struct {
+		Message struct {
+			UtcTime           string `xml:"UtcTime,attr"`
+			PropertyOperation string `xml:"PropertyOperation,attr"`
+			Source            struct {
+				SimpleItem []simpleItemElement
+			}
+			Data struct {
+				SimpleItem []simpleItemElement
+			}
+		}
+	}
+}
+
+// notificationEnvelope is the SOAP envelope of both a PullMessagesResponse and a Notify message
+type notificationEnvelope struct {
+	Body struct {
+		PullMessagesResponse struct {
+			NotificationMessage []notificationMessageElement
+		}
+		Notify struct {
+			NotificationMessage []notificationMessageElement
+		}
+	}
+}
+
+// parseEventReadings returns the normalized readings of the notification messages in a PullMessagesResponse or Notify envelope
+func parseEventReadings(data []byte) ([]EventReading, errors.EdgeX) {
+	envelope := notificationEnvelope{}
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the notification messages", err)
+	}
+
+	messages := append(envelope.Body.PullMessagesResponse.NotificationMessage, envelope.Body.Notify.NotificationMessage...)
+	readings := make([]EventReading, 0, len(messages))
+	for _, message := range messages {
+		readings = append(readings, newEventReading(message))
+	}
+	return readings, nil
+}
+
+// newEventReading returns the normalized reading of a notification message
+func newEventReading(message notificationMessageElement) EventReading {
+	description := message.Message.Message
+	return EventReading{
+		Topic:             strings.TrimSpace(message.Topic),
+		UtcTime:           normalizeUtcTime(description.UtcTime),
+		PropertyOperation: description.PropertyOperation,
+		Source:            simpleItemsToMap(description.Source.SimpleItem),
+		Data:              simpleItemsToMap(description.Data.SimpleItem),
+	}
+}
+
+func simpleItemsToMap(items []simpleItemElement) map[string]string {
+	if len(items) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		values[item.Name] = item.Value
+	}
+	return values
+}
+
+// normalizeUtcTime converts the xsd:dateTime of a message into an RFC 3339 UTC time. The value is returned
+// unchanged if it can not be parsed.
+func normalizeUtcTime(value string) string {
+	t, ok := parseXsdDateTime(value)
+	if !ok {
+		return strings.TrimSpace(value)
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// eventTopicResources returns the device resources which have an eventTopic attribute, keyed by the topic
+func eventTopicResources(sdkService interfaces.DeviceServiceSDK, deviceName string) map[string][]models.DeviceResource {
+	resources := make(map[string][]models.DeviceResource)
+	device, err := sdkService.GetDeviceByName(deviceName)
+	if err != nil {
+		return resources
+	}
+	profile, err := sdkService.GetProfileByName(device.ProfileName)
+	if err != nil {
+		return resources
+	}
+	for _, r := range profile.DeviceResources {
+		if topic, ok := r.Attributes[EventTopic]; ok {
+			topic := strings.TrimSpace(fmt.Sprint(topic))
+			resources[topic] = append(resources[topic], r)
+		}
+	}
+	return resources
+}
+
+// eventRoute selects the resources which receive the event readings of a subscription
+type eventRoute struct {
+	// resourceName receives the readings whose topic has no dedicated resource, or all readings if byTopic is false
+	resourceName string
+	// byTopic sends the readings to the resources whose eventTopic attribute matches their topic
+	byTopic bool
+	// snapshot attaches the snapshot of the event's video source to every reading
+	snapshot bool
+}
+
+// eventDecorators customize the command values created for the readings of an event. The zero value does not change them.
+type eventDecorators struct {
+	// snapshot returns the snapshot command value of the reading's video source, or nil if it is not available
+	snapshot func(EventReading) *sdkModel.CommandValue
+	// timestamp sets the origin and the tags of the command values of the reading
+	timestamp func(EventReading, []*sdkModel.CommandValue)
+}
+
+// eventResources are the resources which receive the event readings of a route
+type eventResources struct {
+	// byTopic are the resources whose eventTopic attribute matches the topic, if the route is by topic
+	byTopic map[string][]models.DeviceResource
+	// route is the resource of the route
+	route models.DeviceResource
+}
+
+// newEventResources returns the resources which receive the event readings of the route
+func newEventResources(sdkService interfaces.DeviceServiceSDK, deviceName string, route eventRoute) eventResources {
+	// the default resource receives the whole reading, while a target resource may select an item with eventItem
+	resources := eventResources{route: models.DeviceResource{Name: route.resourceName}}
+	if route.byTopic {
+		resources.byTopic = eventTopicResources(sdkService, deviceName)
+	} else if resource, ok := sdkService.DeviceResource(deviceName, route.resourceName); ok {
+		resources.route = resource
+	}
+	return resources
+}
+
+// of returns the resources which receive the reading
+func (resources eventResources) of(reading EventReading) []models.DeviceResource {
+	if topicResources, ok := resources.byTopic[reading.Topic]; ok {
+		return topicResources
+	}
+	return []models.DeviceResource{resources.route}
+}
+
+// newEventAsyncValues creates an event for each reading. A reading is sent on the resources whose eventTopic attribute
+// matches its topic, or on the route's resource if the profile does not define one or the route is not by topic. A
+// resource with an eventItem attribute receives the selected SimpleItem as a reading of its valueType, instead of the
+// whole EventReading. If the route or one of the resources requests a snapshot, the command value returned by the
+// snapshot decorator is added to the event of the reading. The source of an event is the first resource which
+// receives the reading, since the SDK drops the events of several readings without a source. A value which can not
+// be converted is skipped, so that the other readings are still sent.
+func newEventAsyncValues(sdkService interfaces.DeviceServiceSDK, deviceName string, route eventRoute, readings []EventReading, decorators eventDecorators) []*sdkModel.AsyncValues {
+	routeResources := newEventResources(sdkService, deviceName, route)
+	asyncValues := make([]*sdkModel.AsyncValues, 0, len(readings))
+	for _, reading := range readings {
+		resources := routeResources.of(reading)
+		var commandValues []*sdkModel.CommandValue
+		for _, resource := range resources {
+			cv, edgexErr := newEventCommandValue(resource, reading)
+			if edgexErr != nil {
+				sdkService.LoggingClient().Warnf("Skipping the %s reading of the '%s' event of device %s, %v", resource.Name, reading.Topic, deviceName, edgexErr)
+				continue
+			}
+			if cv != nil {
+				commandValues = append(commandValues, cv)
+			}
+		}
+		if len(commandValues) == 0 {
+			continue
+		}
+		sourceName := commandValues[0].DeviceResourceName
+		if decorators.snapshot != nil && snapshotRequired(route, resources) {
+			if cv := decorators.snapshot(reading); cv != nil {
+				commandValues = append(commandValues, cv)
+			}
+		}
+		if decorators.timestamp != nil {
+			decorators.timestamp(reading, commandValues)
+		}
+		asyncValues = append(asyncValues, &sdkModel.AsyncValues{
+			DeviceName:    deviceName,
+			SourceName:    sourceName,
+			CommandValues: commandValues,
+		})
+	}
+	return asyncValues
+}
+
+// newEventCommandValue creates the command value of the reading for the resource. Nil is returned if the message
+// does not contain the SimpleItem selected by the eventItem attribute of the resource.
+func newEventCommandValue(resource models.DeviceResource, reading EventReading) (*sdkModel.CommandValue, errors.EdgeX) {
+	itemPath, ok := resource.Attributes[EventItem]
+	if !ok {
+		cv, err := sdkModel.NewCommandValue(resource.Name, common.ValueTypeObject, reading)
+		if err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the event topic '%s'", reading.Topic), err)
+		}
+		return cv, nil
+	}
+
+	value, ok := reading.item(fmt.Sprint(itemPath))
+	if !ok {
+		return nil, nil
+	}
+	cv, edgexErr := newCommandValueFromString(resource.Name, resource.Properties.ValueType, value)
+	if edgexErr != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid value of the event item '%s' for resource '%s'", itemPath, resource.Name), edgexErr)
+	}
+	return cv, nil
+}
+
+// item returns the value of the SimpleItem selected by the path. The path is either Data/<Name> or Source/<Name>,
+// or just the item name to search the data items first and then the source items.
+func (reading EventReading) item(path string) (string, bool) {
+	group, name, found := strings.Cut(path, "/")
+	if !found {
+		if value, ok := reading.Data[path]; ok {
+			return value, true
+		}
+		value, ok := reading.Source[path]
+		return value, ok
+	}
+
+	var value string
+	var ok bool
+	switch group {
+	case "Data":
+		value, ok = reading.Data[name]
+	case "Source":
+		value, ok = reading.Source[name]
+	}
+	return value, ok
+}
+
+// newCommandValueFromString creates a command value by parsing the string as the specified value type
+func newCommandValueFromString(resourceName, valueType, value string) (*sdkModel.CommandValue, errors.EdgeX) {
+	value = strings.TrimSpace(value)
+	var result any
+	var err error
+	switch valueType {
+	case common.ValueTypeString:
+		result = value
+	case common.ValueTypeBool:
+		result, err = strconv.ParseBool(value)
+	case common.ValueTypeInt8:
+		var v int64
+		v, err = strconv.ParseInt(value, 10, 8)
+		result = int8(v)
+	case common.ValueTypeInt16:
+		var v int64
+		v, err = strconv.ParseInt(value, 10, 16)
+		result = int16(v)
+	case common.ValueTypeInt32:
+		var v int64
+		v, err = strconv.ParseInt(value, 10, 32)
+		result = int32(v)
+	case common.ValueTypeInt64:
+		result, err = strconv.ParseInt(value, 10, 64)
+	case common.ValueTypeUint8:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 8)
+		result = uint8(v)
+	case common.ValueTypeUint16:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 16)
+		result = uint16(v)
+	case common.ValueTypeUint32:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 32)
+		result = uint32(v)
+	case common.ValueTypeUint64:
+		result, err = strconv.ParseUint(value, 10, 64)
+	case common.ValueTypeFloat32:
+		var v float64
+		v, err = strconv.ParseFloat(value, 32)
+		result = float32(v)
+	case common.ValueTypeFloat64:
+		result, err = strconv.ParseFloat(value, 64)
+	default:
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("unsupported value type '%s' of an event item", valueType), nil)
+	}
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("failed to parse '%s' as %s", value, valueType), err)
+	}
+
+	cv, err := sdkModel.NewCommandValue(resourceName, valueType, result)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for resource '%s'", resourceName), err)
+	}
+	return cv, nil
+}
+
+// publishEventReadings updates the event state table of the camera with the readings, and sends the readings
+// which pass the event filter to north bound, stamped with the time at which the camera produced them
+func (d *Driver) publishEventReadings(deviceName string, route eventRoute, readings []EventReading) {
+	onvifClient, ok := d.getOnvifClient(deviceName)
+	if !ok {
+		d.sendAsyncValues(deviceName, newEventAsyncValues(d.sdkService, deviceName, route, readings, eventDecorators{}))
+		return
+	}
+
+	now := time.Now()
+	duplicates := make([]bool, len(readings))
+	for i, reading := range readings {
+		duplicates[i] = onvifClient.eventStates.isDuplicate(reading)
+		onvifClient.eventStates.update([]EventReading{reading})
+	}
+	received := len(readings)
+	readings = onvifClient.eventFilter.filter(readings, duplicates, route, d.eventFilterConfig(), now)
+	if held := received - len(readings); held > 0 {
+		d.lc.Debugf("Filtered %d of %d events from device %s", held, received, deviceName)
+	}
+	onvifClient.sendEventReadings(route, readings, now)
+}
+
+// publishPendingEvents sends the readings which were held back by the event filter of the camera once they are due
+func (onvifClient *OnvifClient) publishPendingEvents(route eventRoute, readings []EventReading) {
+	onvifClient.sendEventReadings(route, readings, time.Now())
+}
+
+// sendEventReadings sends the event readings of the camera received at the specified time to north bound. The
+// readings which require a snapshot are queued, and sent once their snapshot is taken, so that the subscriptions
+// are not blocked by the snapshot requests.
+func (onvifClient *OnvifClient) sendEventReadings(route eventRoute, readings []EventReading, received time.Time) {
+	d := onvifClient.driver
+	deviceName := onvifClient.deviceName()
+	resources := newEventResources(d.sdkService, deviceName, route)
+	var immediate, snapshot []EventReading
+	for _, reading := range readings {
+		if snapshotRequired(route, resources.of(reading)) {
+			snapshot = append(snapshot, reading)
+		} else {
+			immediate = append(immediate, reading)
+		}
+	}
+
+	if len(immediate) > 0 {
+		d.sendAsyncValues(deviceName, newEventAsyncValues(d.sdkService, deviceName, route, immediate, onvifClient.eventDecorators(received)))
+	}
+	if len(snapshot) > 0 {
+		onvifClient.queueSnapshotEvents(snapshotJob{route: route, readings: snapshot, received: received})
+	}
+}
+
+// eventDecorators returns the decorators of the event readings of the camera received at the specified time. The
+// snapshots are only attached by the snapshot queue.
+func (onvifClient *OnvifClient) eventDecorators(received time.Time) eventDecorators {
+	return eventDecorators{
+		timestamp: onvifClient.newEventTimestamper(received, onvifClient.driver.eventTimeTolerance()),
+	}
+}
@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testPullMessagesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tev="http://www.onvif.org/ver10/events/wsdl" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:tns1="http://www.onvif.org/ver10/topics">
+  <env:Body>
+    <tev:PullMessagesResponse>
+      <tev:CurrentTime>2023-09-21T08:00:05Z</tev:CurrentTime>
+      <tev:TerminationTime>2023-09-21T09:00:05Z</tev:TerminationTime>
+      <wsnt:NotificationMessage>
+        <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:VideoSource/MotionAlarm</wsnt:Topic>
+        <wsnt:Message>
+          <tt:Message UtcTime="2023-09-21T08:00:04.5+02:00" PropertyOperation="Changed">
+            <tt:Source>
+              <tt:SimpleItem Name="Source" Value="VideoSourceToken"/>
+            </tt:Source>
+            <tt:Data>
+              <tt:SimpleItem Name="State" Value="true"/>
+            </tt:Data>
+          </tt:Message>
+        </wsnt:Message>
+      </wsnt:NotificationMessage>
+      <wsnt:NotificationMessage>
+        <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:Device/Trigger/DigitalInput</wsnt:Topic>
+        <wsnt:Message>
+          <tt:Message UtcTime="2023-09-21T08:00:05">
+            <tt:Data>
+              <tt:SimpleItem Name="LogicalState" Value="false"/>
+            </tt:Data>
+          </tt:Message>
+        </wsnt:Message>
+      </wsnt:NotificationMessage>
+    </tev:PullMessagesResponse>
+  </env:Body>
+</env:Envelope>`
+
+func TestParseEventReadings(t *testing.T) {
+	readings, err := parseEventReadings([]byte(testPullMessagesResponse))
+	require.NoError(t, err)
+	require.Len(t, readings, 2)
+
+	assert.Equal(t, EventReading{
+		Topic:             "tns1:VideoSource/MotionAlarm",
+		UtcTime:           "2023-09-21T06:00:04.5Z",
+		PropertyOperation: "Changed",
+		Source:            map[string]string{"Source": "VideoSourceToken"},
+		Data:              map[string]string{"State": "true"},
+	}, readings[0])
+	assert.Equal(t, EventReading{
+		Topic:   "tns1:Device/Trigger/DigitalInput",
+		UtcTime: "2023-09-21T08:00:05Z",
+		Data:    map[string]string{"LogicalState": "false"},
+	}, readings[1])
+
+	_, err = parseEventReadings([]byte("invalid"))
+	require.Error(t, err)
+}
+
+func TestNewEventAsyncValues(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	mockService.On("GetDeviceByName", testDeviceName).Return(models.Device{Name: testDeviceName, ProfileName: testProfileName}, nil)
+	mockService.On("GetProfileByName", testProfileName).Return(models.DeviceProfile{
+		Name: testProfileName,
+		DeviceResources: []models.DeviceResource{
+			{Name: CameraEvent, Attributes: map[string]any{GetFunction: CameraEvent}},
+			{Name: "MotionAlarm", Attributes: map[string]any{EventTopic: "tns1:VideoSource/MotionAlarm"}},
+		},
+	}, nil)
+
+	readings := []EventReading{{Topic: "tns1:VideoSource/MotionAlarm"}, {Topic: "tns1:Device/Trigger/DigitalInput"}}
+	asyncValues := newEventAsyncValues(driver.sdkService, testDeviceName, eventRoute{resourceName: CameraEvent, byTopic: true}, readings, eventDecorators{})
+	require.Len(t, asyncValues, 2)
+	assert.Equal(t, "MotionAlarm", asyncValues[0].CommandValues[0].DeviceResourceName)
+	assert.Equal(t, readings[0], asyncValues[0].CommandValues[0].Value)
+	assert.Equal(t, CameraEvent, asyncValues[1].CommandValues[0].DeviceResourceName)
+}
+
+func TestNewEventAsyncValues_eventItem(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	mockService.On("GetDeviceByName", testDeviceName).Return(models.Device{Name: testDeviceName, ProfileName: testProfileName}, nil)
+	mockService.On("GetProfileByName", testProfileName).Return(models.DeviceProfile{
+		Name: testProfileName,
+		DeviceResources: []models.DeviceResource{
+			{
+				Name:       "MotionDetected",
+				Attributes: map[string]any{EventTopic: "tns1:RuleEngine/CellMotionDetector/Motion", EventItem: "Data/IsMotion"},
+				Properties: models.ResourceProperties{ValueType: common.ValueTypeBool},
+			},
+			{
+				Name:       "MotionSource",
+				Attributes: map[string]any{EventTopic: "tns1:RuleEngine/CellMotionDetector/Motion", EventItem: "VideoSourceConfigurationToken"},
+				Properties: models.ResourceProperties{ValueType: common.ValueTypeString},
+			},
+		},
+	}, nil)
+
+	readings := []EventReading{
+		{
+			Topic:  "tns1:RuleEngine/CellMotionDetector/Motion",
+			Source: map[string]string{"VideoSourceConfigurationToken": "VideoSourceConfig_1"},
+			Data:   map[string]string{"IsMotion": "true"},
+		},
+		{
+			// a deleted property has no data items, so only the source is sent
+			Topic:             "tns1:RuleEngine/CellMotionDetector/Motion",
+			PropertyOperation: "Deleted",
+			Source:            map[string]string{"VideoSourceConfigurationToken": "VideoSourceConfig_1"},
+		},
+	}
+	asyncValues := newEventAsyncValues(driver.sdkService, testDeviceName, eventRoute{resourceName: CameraEvent, byTopic: true}, readings, eventDecorators{})
+	require.Len(t, asyncValues, 2)
+
+	require.Len(t, asyncValues[0].CommandValues, 2)
+	assert.Equal(t, "MotionDetected", asyncValues[0].CommandValues[0].DeviceResourceName)
+	assert.Equal(t, true, asyncValues[0].CommandValues[0].Value)
+	assert.Equal(t, "MotionSource", asyncValues[0].CommandValues[1].DeviceResourceName)
+	assert.Equal(t, "VideoSourceConfig_1", asyncValues[0].CommandValues[1].Value)
+	require.Len(t, asyncValues[1].CommandValues, 1)
+	assert.Equal(t, "MotionSource", asyncValues[1].CommandValues[0].DeviceResourceName)
+
+	// the event has several readings, so the SDK requires its source
+	assert.Equal(t, "MotionDetected", asyncValues[0].SourceName)
+	assert.Equal(t, "MotionSource", asyncValues[1].SourceName)
+
+	// an invalid value is skipped without losing the other readings of the event
+	readings[0].Data["IsMotion"] = "maybe"
+	asyncValues = newEventAsyncValues(driver.sdkService, testDeviceName, eventRoute{resourceName: CameraEvent, byTopic: true}, readings, eventDecorators{})
+	require.Len(t, asyncValues, 2)
+	require.Len(t, asyncValues[0].CommandValues, 1)
+	assert.Equal(t, "MotionSource", asyncValues[0].CommandValues[0].DeviceResourceName)
+	assert.Equal(t, "MotionSource", asyncValues[0].SourceName)
+}
+
+func TestNewEventAsyncValues_targetResource(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	mockService.On("DeviceResource", testDeviceName, "MotionDetected").Return(models.DeviceResource{
+		Name:       "MotionDetected",
+		Attributes: map[string]any{EventItem: "Data/IsMotion"},
+		Properties: models.ResourceProperties{ValueType: common.ValueTypeBool},
+	}, true)
+
+	// the readings of a subscription with a target resource are not routed by topic
+	readings := []EventReading{{Topic: "tns1:RuleEngine/CellMotionDetector/Motion", Data: map[string]string{"IsMotion": "false"}}}
+	asyncValues := newEventAsyncValues(driver.sdkService, testDeviceName, eventRoute{resourceName: "MotionDetected"}, readings, eventDecorators{})
+	require.Len(t, asyncValues, 1)
+	require.Len(t, asyncValues[0].CommandValues, 1)
+	assert.Equal(t, "MotionDetected", asyncValues[0].CommandValues[0].DeviceResourceName)
+	assert.Equal(t, false, asyncValues[0].CommandValues[0].Value)
+	mockService.AssertNotCalled(t, "GetProfileByName", mock.Anything)
+}
+
+func TestNewCommandValueFromString(t *testing.T) {
+	tests := []struct {
+		valueType     string
+		value         string
+		expected      any
+		errorExpected bool
+	}{
+		{valueType: common.ValueTypeBool, value: "false", expected: false},
+		{valueType: common.ValueTypeString, value: "VideoSource_1", expected: "VideoSource_1"},
+		{valueType: common.ValueTypeInt8, value: "-12", expected: int8(-12)},
+		{valueType: common.ValueTypeInt8, value: "300", errorExpected: true},
+		{valueType: common.ValueTypeUint32, value: "42", expected: uint32(42)},
+		{valueType: common.ValueTypeInt64, value: "42", expected: int64(42)},
+		{valueType: common.ValueTypeFloat32, value: "0.5", expected: float32(0.5)},
+		{valueType: common.ValueTypeFloat64, value: "1.25", expected: 1.25},
+		{valueType: common.ValueTypeObject, value: "{}", errorExpected: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.valueType+"/"+test.value, func(t *testing.T) {
+			cv, err := newCommandValueFromString("resource", test.valueType, test.value)
+			if test.errorExpected {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.valueType, cv.Type)
+			assert.Equal(t, test.expected, cv.Value)
+		})
+	}
+}
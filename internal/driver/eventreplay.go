@@ -0,0 +1,460 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	onvifdevice "github.com/IOTechSystems/onvif/device"
+	"github.com/IOTechSystems/onvif/event"
+	"github.com/IOTechSystems/onvif/gosoap"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	ReplayEvents = "ReplayEvents"
+	// ReplayedTag is the tag added to the readings of replayed events, so that they can be told apart from live events
+	ReplayedTag = "replayed"
+
+	searchServiceName      = "search"
+	searchServiceNamespace = "http://www.onvif.org/ver10/search/wsdl"
+	onvifSchemaNamespace   = "http://www.onvif.org/ver10/schema"
+	onvifTopicsNamespace   = "http://www.onvif.org/ver10/topics"
+
+	// searchCompleted is the SearchState of the last GetEventSearchResults response of a search
+	searchCompleted = "Completed"
+	// searchKeepAliveTime is the time the camera keeps a search alive between two requests
+	searchKeepAliveTime = "PT60S"
+	// searchWaitTime is the time the camera may wait for results before responding to GetEventSearchResults
+	searchWaitTime = "PT5S"
+	// searchMaxResults is the maximum number of results per GetEventSearchResults response
+	searchMaxResults = 100
+	// searchPollInterval is the pause between two GetEventSearchResults requests which do not return a full page of
+	// results, since some cameras respond immediately instead of waiting for the WaitTime
+	searchPollInterval = time.Second
+	// searchTimeout is the maximum duration of a search
+	searchTimeout = 5 * time.Minute
+)
+
+// EventReplayRequest is the request body of the ReplayEvents command. The events of the time range between StartTime
+// and EndTime are replayed if StartTime is specified, otherwise the event gaps recorded by the subscriptions are replayed.
+type EventReplayRequest struct {
+	// Subscription is the name of the subscription whose gaps are replayed, and whose target resource and filters
+	// apply to the replayed events. The gaps of all subscriptions are replayed if it is empty.
+	Subscription string
+	// StartTime and EndTime are the RFC 3339 times of the range to replay, EndTime defaults to the current time
+	StartTime string
+	EndTime   string
+	// TopicFilter overrides the TopicFilter of the subscription
+	TopicFilter *string
+}
+
+// eventReplay is the replay of the gaps of a subscription, or of a time range
+type eventReplay struct {
+	name   string
+	route  eventRoute
+	filter *event.FilterType
+	gaps   []eventGap
+	// health is the health of the subscription whose gaps are replayed, which gets back the gaps which fail
+	health *subscriptionHealth
+}
+
+// replaySource is a subscription whose gaps can be replayed
+type replaySource struct {
+	name    string
+	request *SubscriptionRequest
+	health  *subscriptionHealth
+}
+
+type findEvents struct {
+	XMLName           string            `xml:"tse:FindEvents"`
+	SearchNamespace   string            `xml:"xmlns:tse,attr"`
+	SchemaNamespace   string            `xml:"xmlns:tt,attr"`
+	TopicsNamespace   string            `xml:"xmlns:tns1,attr"`
+	StartPoint        string            `xml:"tse:StartPoint"`
+	EndPoint          string            `xml:"tse:EndPoint"`
+	Scope             string            `xml:"tse:Scope"`
+	SearchFilter      *event.FilterType `xml:"tse:SearchFilter"`
+	IncludeStartState bool              `xml:"tse:IncludeStartState"`
+	KeepAliveTime     string            `xml:"tse:KeepAliveTime"`
+}
+
+type getEventSearchResults struct {
+	XMLName         string `xml:"tse:GetEventSearchResults"`
+	SearchNamespace string `xml:"xmlns:tse,attr"`
+	SearchToken     string `xml:"tse:SearchToken"`
+	MaxResults      int    `xml:"tse:MaxResults"`
+	WaitTime        string `xml:"tse:WaitTime"`
+}
+
+type endSearch struct {
+	XMLName         string `xml:"tse:EndSearch"`
+	SearchNamespace string `xml:"xmlns:tse,attr"`
+	SearchToken     string `xml:"tse:SearchToken"`
+}
+
+// searchResponseEnvelope is the minimal representation of the responses of the search service
+type searchResponseEnvelope struct {
+	Body struct {
+		Fault              *gosoap.SOAPFault
+		FindEventsResponse struct {
+			SearchToken string
+		}
+		GetEventSearchResultsResponse struct {
+			ResultList struct {
+				SearchState string
+				Result      []struct {
+					Time  string
+					Event notificationMessageElement
+				}
+			}
+		}
+	}
+}
+
+// servicesEnvelope is the minimal representation of a GetServicesResponse. The response of the onvif library
+// holds a single service.
+type servicesEnvelope struct {
+	Body struct {
+		GetServicesResponse struct {
+			Service []struct {
+				Namespace string
+				XAddr     string
+			}
+		}
+	}
+}
+
+// newEventReplays returns the replays of a ReplayEvents request. The recorded gaps are taken from the subscriptions,
+// so that they are not replayed twice.
+func (onvifClient *OnvifClient) newEventReplays(data []byte) ([]eventReplay, errors.EdgeX) {
+	var request EventReplayRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+	}
+
+	sources := onvifClient.replaySources()
+	if request.Subscription != "" {
+		var found []replaySource
+		for _, source := range sources {
+			if source.name == request.Subscription {
+				found = append(found, source)
+			}
+		}
+		if len(found) == 0 {
+			return nil, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("subscription '%s' not found for device '%s'", request.Subscription, onvifClient.deviceName()), nil)
+		}
+		sources = found
+	}
+
+	if request.StartTime != "" {
+		gap, edgexErr := parseReplayRange(request.StartTime, request.EndTime, time.Now())
+		if edgexErr != nil {
+			return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+		replay := eventReplay{
+			name:  onvifClient.deviceName(),
+			route: eventRoute{resourceName: onvifClient.CameraEventResource.Name, byTopic: true},
+			gaps:  []eventGap{gap},
+		}
+		if request.Subscription != "" {
+			replay.name = sources[0].name
+			replay.route = sources[0].request.eventRoute(onvifClient.CameraEventResource.Name)
+			replay.filter = eventFilterType(sources[0].request.TopicFilter, sources[0].request.MessageContentFilter)
+		}
+		if request.TopicFilter != nil {
+			replay.filter = eventFilterType(request.TopicFilter, nil)
+		}
+		return []eventReplay{replay}, nil
+	}
+	if request.EndTime != "" {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "the EndTime requires a StartTime", nil)
+	}
+
+	var replays []eventReplay
+	for _, source := range sources {
+		gaps := source.health.takeGaps()
+		if len(gaps) == 0 {
+			continue
+		}
+		replay := eventReplay{
+			name:   source.name,
+			route:  source.request.eventRoute(onvifClient.CameraEventResource.Name),
+			filter: eventFilterType(source.request.TopicFilter, source.request.MessageContentFilter),
+			gaps:   gaps,
+			health: source.health,
+		}
+		if request.TopicFilter != nil {
+			replay.filter = eventFilterType(request.TopicFilter, nil)
+		}
+		replays = append(replays, replay)
+	}
+	return replays, nil
+}
+
+// parseReplayRange parses the RFC 3339 time range of a ReplayEvents request
+func parseReplayRange(startTime, endTime string, now time.Time) (eventGap, errors.EdgeX) {
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return eventGap{}, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid StartTime '%s'", startTime), err)
+	}
+	end := now
+	if endTime != "" {
+		if end, err = time.Parse(time.RFC3339, endTime); err != nil {
+			return eventGap{}, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid EndTime '%s'", endTime), err)
+		}
+	}
+	if !start.Before(end) {
+		return eventGap{}, errors.NewCommonEdgeX(errors.KindContractInvalid, "the StartTime should be before the EndTime", nil)
+	}
+	return eventGap{start: start, end: end}, nil
+}
+
+// replaySources returns the subscriptions of the camera with their requests and health
+func (onvifClient *OnvifClient) replaySources() []replaySource {
+	var sources []replaySource
+	if onvifClient.pullPointManager != nil {
+		for _, sub := range onvifClient.pullPointManager.listSubscribers() {
+			sources = append(sources, replaySource{name: sub.Name, request: sub.request(), health: &sub.health})
+		}
+	}
+	if onvifClient.baseNotificationManager != nil {
+		for _, consumer := range onvifClient.baseNotificationManager.listConsumers() {
+			sources = append(sources, replaySource{name: consumer.Name, request: consumer.request(), health: &consumer.health})
+		}
+	}
+	return sources
+}
+
+// callReplayEventsFunction starts replaying the events of a ReplayEvents request. The searches can take a while on
+// the camera, so they run in the background once the request is validated.
+func (onvifClient *OnvifClient) callReplayEventsFunction(data []byte) errors.EdgeX {
+	endpoint, edgexErr := onvifClient.searchEndpoint()
+	if edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	replays, edgexErr := onvifClient.newEventReplays(data)
+	if edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	if len(replays) == 0 {
+		onvifClient.lc.Infof("No event gap to replay for device %s", onvifClient.deviceName())
+		return nil
+	}
+	go onvifClient.replayEvents(endpoint, replays)
+	return nil
+}
+
+// replayEvents searches the events of the replays and publishes them. The gaps which fail are recorded again by
+// their subscription, so that they can be replayed later. The replays of a camera run one at a time.
+func (onvifClient *OnvifClient) replayEvents(endpoint string, replays []eventReplay) int {
+	onvifClient.replayMu.Lock()
+	defer onvifClient.replayMu.Unlock()
+	published := 0
+	for _, replay := range replays {
+		for i, gap := range replay.gaps {
+			readings, edgexErr := onvifClient.searchEvents(endpoint, gap, replay.filter)
+			if edgexErr != nil {
+				onvifClient.lc.Errorf("Failed to replay the events of '%s' from %s to %s for device %s, %v", replay.name,
+					gap.start.UTC().Format(time.RFC3339), gap.end.UTC().Format(time.RFC3339), onvifClient.deviceName(), edgexErr)
+				if replay.health != nil {
+					replay.health.restoreGaps(replay.gaps[i:])
+				}
+				break
+			}
+			onvifClient.publishReplayedReadings(replay.route, readings)
+			published += len(readings)
+		}
+	}
+	onvifClient.lc.Infof("Replayed %d events for device %s", published, onvifClient.deviceName())
+	return published
+}
+
+// publishReplayedReadings sends the replayed readings to north bound with the time at which the camera produced
+// them. The replayed events bypass the event filter and do not update the event state table, since they are not
+// the current state of the camera.
+func (onvifClient *OnvifClient) publishReplayedReadings(route eventRoute, readings []EventReading) {
+	// the replayed events are older than the sanity window by nature, so their time is not checked
+	timestamp := onvifClient.newEventTimestamper(time.Time{}, 0)
+	asyncValues := newEventAsyncValues(onvifClient.driver.sdkService, onvifClient.deviceName(), route, readings, eventDecorators{
+		timestamp: func(reading EventReading, commandValues []*sdkModel.CommandValue) {
+			timestamp(reading, commandValues)
+			for _, cv := range commandValues {
+				cv.Tags[ReplayedTag] = "true"
+			}
+		},
+	})
+	onvifClient.driver.sendAsyncValues(onvifClient.deviceName(), asyncValues)
+}
+
+// searchEndpoint returns the address of the search service of the camera, which is not part of the capabilities
+// used by the onvif library, so it is looked up with GetServices
+func (onvifClient *OnvifClient) searchEndpoint() (string, errors.EdgeX) {
+	if endpoint := onvifClient.device().GetEndpoint(searchServiceName); endpoint != "" {
+		return endpoint, nil
+	}
+	servResp, err := onvifClient.device().CallMethod(onvifdevice.GetServices{})
+	if err != nil {
+		return "", errors.NewCommonEdgeX(errors.KindServiceUnavailable, "failed to request the services of the camera", err)
+	}
+	defer servResp.Body.Close()
+	data, err := io.ReadAll(servResp.Body)
+	if err != nil {
+		return "", errors.NewCommonEdgeX(errors.KindServerError, "failed to read the services of the camera", err)
+	}
+	if servResp.StatusCode >= http.StatusBadRequest {
+		return "", errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to request the services of the camera, status code: %d", servResp.StatusCode), nil)
+	}
+	envelope := servicesEnvelope{}
+	if err = xml.Unmarshal(data, &envelope); err != nil {
+		return "", errors.NewCommonEdgeX(errors.KindServerError, "failed to parse the services of the camera", err)
+	}
+	for _, service := range envelope.Body.GetServicesResponse.Service {
+		if strings.TrimSpace(service.Namespace) != searchServiceNamespace {
+			continue
+		}
+		return onvifClient.reachableEndpoint(strings.TrimSpace(service.XAddr)), nil
+	}
+	return "", errors.NewCommonEdgeX(errors.KindNotImplemented, fmt.Sprintf("the camera %s does not support the search service", onvifClient.deviceName()), nil)
+}
+
+// reachableEndpoint replaces the hostname of a service address with the hostname of the camera, since the camera may
+// report an address which is not reachable from the device service. The port of the service is kept, since the
+// service may listen on another port than the device service of the camera.
+func (onvifClient *OnvifClient) reachableEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	host := onvifClient.device().GetDeviceParams().Xaddr
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	return u.String()
+}
+
+// searchEvents returns the events recorded by the camera within the gap, in the order of their UtcTime
+func (onvifClient *OnvifClient) searchEvents(endpoint string, gap eventGap, filter *event.FilterType) ([]EventReading, errors.EdgeX) {
+	if filter == nil {
+		filter = &event.FilterType{}
+	}
+	response, edgexErr := onvifClient.sendSearchRequest(endpoint, findEvents{
+		SearchNamespace: searchServiceNamespace,
+		SchemaNamespace: onvifSchemaNamespace,
+		TopicsNamespace: onvifTopicsNamespace,
+		StartPoint:      gap.start.UTC().Format(time.RFC3339),
+		EndPoint:        gap.end.UTC().Format(time.RFC3339),
+		SearchFilter:    filter,
+		KeepAliveTime:   searchKeepAliveTime,
+	})
+	if edgexErr != nil {
+		return nil, errors.NewCommonEdgeX(errors.Kind(edgexErr), "failed to start the event search", edgexErr)
+	}
+	token := response.Body.FindEventsResponse.SearchToken
+	if token == "" {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, "the FindEvents response does not contain a SearchToken", nil)
+	}
+
+	var readings []EventReading
+	seen := make(map[string]bool)
+	deadline := time.Now().Add(searchTimeout)
+	for {
+		response, edgexErr = onvifClient.sendSearchRequest(endpoint, getEventSearchResults{
+			SearchNamespace: searchServiceNamespace,
+			SearchToken:     token,
+			MaxResults:      searchMaxResults,
+			WaitTime:        searchWaitTime,
+		})
+		if edgexErr != nil {
+			onvifClient.endSearch(endpoint, token)
+			return nil, errors.NewCommonEdgeX(errors.Kind(edgexErr), "failed to get the event search results", edgexErr)
+		}
+		resultList := response.Body.GetEventSearchResultsResponse.ResultList
+		for _, result := range resultList.Result {
+			reading := newEventReading(result.Event)
+			if reading.UtcTime == "" {
+				reading.UtcTime = normalizeUtcTime(result.Time)
+			}
+			// the same event may be recorded by several recordings or tracks
+			key := fmt.Sprint(reading)
+			if !seen[key] {
+				seen[key] = true
+				readings = append(readings, reading)
+			}
+		}
+		if resultList.SearchState == searchCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			onvifClient.endSearch(endpoint, token)
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("the event search did not complete within %v", searchTimeout), nil)
+		}
+		if len(resultList.Result) < searchMaxResults {
+			time.Sleep(searchPollInterval)
+		}
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool {
+		ti, _ := parseXsdDateTime(readings[i].UtcTime)
+		tj, _ := parseXsdDateTime(readings[j].UtcTime)
+		return ti.Before(tj)
+	})
+	return readings, nil
+}
+
+// endSearch releases the resources of an incomplete search on the camera
+func (onvifClient *OnvifClient) endSearch(endpoint, token string) {
+	if _, edgexErr := onvifClient.sendSearchRequest(endpoint, endSearch{SearchNamespace: searchServiceNamespace, SearchToken: token}); edgexErr != nil {
+		onvifClient.lc.Debugf("Unable to end the event search of device %s, %v", onvifClient.deviceName(), edgexErr)
+	}
+}
+
+func (onvifClient *OnvifClient) sendSearchRequest(endpoint string, request any) (*searchResponseEnvelope, errors.EdgeX) {
+	requestBody, err := xml.Marshal(request)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the search request", err)
+	}
+	servResp, err := onvifClient.device().SendSoap(endpoint, string(requestBody))
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServiceUnavailable, "failed to send the search request", err)
+	}
+	defer servResp.Body.Close()
+
+	rsp, err := io.ReadAll(servResp.Body)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, "failed to read the search response", err)
+	}
+	response := &searchResponseEnvelope{}
+	if err = xml.Unmarshal(rsp, response); err != nil && servResp.StatusCode < http.StatusBadRequest {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, "failed to parse the search response", err)
+	}
+	if response.Body.Fault != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("status code: %d, %s", servResp.StatusCode, response.Body.Fault.String()), nil)
+	}
+	if servResp.StatusCode >= http.StatusBadRequest {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("status code: %d", servResp.StatusCode), nil)
+	}
+	return response, nil
+}
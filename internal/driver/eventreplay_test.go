@@ -0,0 +1,266 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGetServicesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
+  <env:Body>
+    <tds:GetServicesResponse>
+      <tds:Service>
+        <tds:Namespace>http://www.onvif.org/ver10/device/wsdl</tds:Namespace>
+        <tds:XAddr>http://10.0.0.10/onvif/device_service</tds:XAddr>
+      </tds:Service>
+      <tds:Service>
+        <tds:Namespace>http://www.onvif.org/ver10/search/wsdl</tds:Namespace>
+        <tds:XAddr>http://10.0.0.10/onvif/search_service</tds:XAddr>
+      </tds:Service>
+    </tds:GetServicesResponse>
+  </env:Body>
+</env:Envelope>`
+	testFindEventsResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tse="http://www.onvif.org/ver10/search/wsdl">
+  <env:Body>
+    <tse:FindEventsResponse>
+      <tse:SearchToken>search_1</tse:SearchToken>
+    </tse:FindEventsResponse>
+  </env:Body>
+</env:Envelope>`
+	testEventSearchResultsSearching = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tse="http://www.onvif.org/ver10/search/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tns1="http://www.onvif.org/ver10/topics">
+  <env:Body>
+    <tse:GetEventSearchResultsResponse>
+      <tse:ResultList>
+        <tt:SearchState>Searching</tt:SearchState>
+        <tt:Result>
+          <tt:RecordingToken>recording_1</tt:RecordingToken>
+          <tt:TrackToken>track_1</tt:TrackToken>
+          <tt:Time>2023-09-21T08:10:00Z</tt:Time>
+          <tt:Event>
+            <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:VideoSource/MotionAlarm</wsnt:Topic>
+            <wsnt:Message>
+              <tt:Message UtcTime="2023-09-21T08:10:00Z" PropertyOperation="Changed">
+                <tt:Source><tt:SimpleItem Name="Source" Value="VideoSource_1"/></tt:Source>
+                <tt:Data><tt:SimpleItem Name="State" Value="false"/></tt:Data>
+              </tt:Message>
+            </wsnt:Message>
+          </tt:Event>
+        </tt:Result>
+        <tt:Result>
+          <tt:RecordingToken>recording_2</tt:RecordingToken>
+          <tt:TrackToken>track_1</tt:TrackToken>
+          <tt:Time>2023-09-21T08:10:00Z</tt:Time>
+          <tt:Event>
+            <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:VideoSource/MotionAlarm</wsnt:Topic>
+            <wsnt:Message>
+              <tt:Message UtcTime="2023-09-21T08:10:00Z" PropertyOperation="Changed">
+                <tt:Source><tt:SimpleItem Name="Source" Value="VideoSource_1"/></tt:Source>
+                <tt:Data><tt:SimpleItem Name="State" Value="false"/></tt:Data>
+              </tt:Message>
+            </wsnt:Message>
+          </tt:Event>
+        </tt:Result>
+      </tse:ResultList>
+    </tse:GetEventSearchResultsResponse>
+  </env:Body>
+</env:Envelope>`
+	testEventSearchResultsCompleted = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tse="http://www.onvif.org/ver10/search/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tns1="http://www.onvif.org/ver10/topics">
+  <env:Body>
+    <tse:GetEventSearchResultsResponse>
+      <tse:ResultList>
+        <tt:SearchState>Completed</tt:SearchState>
+        <tt:Result>
+          <tt:RecordingToken>recording_1</tt:RecordingToken>
+          <tt:TrackToken>track_1</tt:TrackToken>
+          <tt:Time>2023-09-21T08:05:00Z</tt:Time>
+          <tt:Event>
+            <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:VideoSource/MotionAlarm</wsnt:Topic>
+            <wsnt:Message>
+              <tt:Message UtcTime="2023-09-21T08:05:00Z" PropertyOperation="Changed">
+                <tt:Source><tt:SimpleItem Name="Source" Value="VideoSource_1"/></tt:Source>
+                <tt:Data><tt:SimpleItem Name="State" Value="true"/></tt:Data>
+              </tt:Message>
+            </wsnt:Message>
+          </tt:Event>
+        </tt:Result>
+      </tse:ResultList>
+    </tse:GetEventSearchResultsResponse>
+  </env:Body>
+</env:Envelope>`
+)
+
+func TestSubscriptionHealth_eventGaps(t *testing.T) {
+	health := subscriptionHealth{}
+	lastMessage := time.Date(2023, 9, 21, 8, 0, 0, 0, time.UTC)
+	health.succeeded(1, lastMessage)
+	health.failed(errors.NewCommonEdgeX(errors.KindServerError, "timeout", nil))
+	health.failed(errors.NewCommonEdgeX(errors.KindServerError, "timeout", nil))
+	recovered := lastMessage.Add(time.Hour)
+	health.succeeded(0, recovered)
+
+	// the gap starts at the last message received before the failure
+	assert.Equal(t, []EventGap{{Start: "2023-09-21T08:00:00Z", End: "2023-09-21T09:00:00Z"}}, health.snapshot().EventGaps)
+
+	// the oldest gaps are merged beyond the limit
+	for i := 0; i < maxEventGaps; i++ {
+		start := recovered.Add(time.Duration(2*i+1) * time.Minute)
+		health.restoreGaps([]eventGap{{start: start, end: start.Add(time.Minute)}})
+	}
+	gaps := health.takeGaps()
+	require.Len(t, gaps, maxEventGaps)
+	assert.Equal(t, lastMessage, gaps[0].start)
+	assert.Equal(t, recovered.Add(2*time.Minute), gaps[0].end)
+	assert.Empty(t, health.snapshot().EventGaps, "the gaps are taken once")
+}
+
+func TestOnvifClient_newEventReplays(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.CameraEventResource = models.DeviceResource{Name: CameraEvent}
+	client.pullPointManager = newPullPointManager(logger.NewMockClient())
+	topicFilter := "tns1:VideoSource/MotionAlarm"
+	sub := &Subscriber{Name: "PullPointSubscription", subscriptionRequest: &SubscriptionRequest{TopicFilter: &topicFilter}}
+	gap := eventGap{start: time.Date(2023, 9, 21, 8, 0, 0, 0, time.UTC), end: time.Date(2023, 9, 21, 9, 0, 0, 0, time.UTC)}
+	sub.health.restoreGaps([]eventGap{gap})
+	client.pullPointManager.addSubscriber(sub)
+
+	tests := []struct {
+		name         string
+		data         string
+		expectedKind errors.ErrKind
+	}{
+		{name: "invalid json", data: "{", expectedKind: errors.KindContractInvalid},
+		{name: "unknown subscription", data: `{"Subscription": "unknown"}`, expectedKind: errors.KindEntityDoesNotExist},
+		{name: "invalid start time", data: `{"StartTime": "yesterday"}`, expectedKind: errors.KindContractInvalid},
+		{name: "end before start", data: `{"StartTime": "2023-09-21T09:00:00Z", "EndTime": "2023-09-21T08:00:00Z"}`, expectedKind: errors.KindContractInvalid},
+		{name: "end without start", data: `{"EndTime": "2023-09-21T08:00:00Z"}`, expectedKind: errors.KindContractInvalid},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.newEventReplays([]byte(test.data))
+			require.Error(t, err)
+			assert.Equal(t, test.expectedKind, errors.Kind(err))
+		})
+	}
+
+	replays, err := client.newEventReplays([]byte(`{"StartTime": "2023-09-21T08:00:00Z", "EndTime": "2023-09-21T08:30:00Z", "TopicFilter": "tns1:Device//."}`))
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	assert.Equal(t, eventRoute{resourceName: CameraEvent, byTopic: true}, replays[0].route)
+	assert.Equal(t, "tns1:Device//.", string(replays[0].filter.TopicExpression.TopicKinds))
+	assert.Nil(t, replays[0].health, "an explicit range is not a gap of the subscription")
+
+	// the recorded gaps of the subscription are replayed once, with the filter of the subscription
+	replays, err = client.newEventReplays([]byte(`{"Subscription": "PullPointSubscription"}`))
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	assert.Equal(t, []eventGap{gap}, replays[0].gaps)
+	assert.Equal(t, topicFilter, string(replays[0].filter.TopicExpression.TopicKinds))
+	replays, err = client.newEventReplays([]byte(`{}`))
+	require.NoError(t, err)
+	assert.Empty(t, replays)
+}
+
+func TestOnvifClient_reachableEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		xaddr    string
+		endpoint string
+		expected string
+	}{
+		{name: "service port is kept", xaddr: "192.168.1.10:8080", endpoint: "http://10.0.0.10:8000/onvif/search_service", expected: "http://192.168.1.10:8000/onvif/search_service"},
+		{name: "default service port", xaddr: "192.168.1.10:8080", endpoint: "http://10.0.0.10/onvif/search_service", expected: "http://192.168.1.10/onvif/search_service"},
+		{name: "camera without port", xaddr: "192.168.1.10", endpoint: "http://10.0.0.10:8000/onvif/search_service", expected: "http://192.168.1.10:8000/onvif/search_service"},
+		{name: "ipv6 camera", xaddr: "[fe80::1]:80", endpoint: "http://10.0.0.10/onvif/search_service", expected: "http://[fe80::1]/onvif/search_service"},
+		{name: "invalid endpoint", xaddr: "192.168.1.10:80", endpoint: "/onvif/search_service", expected: "/onvif/search_service"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver, _ := createDriverWithMockService()
+			client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+			mockDevice.On("GetDeviceParams").Return(onvif.DeviceParams{Xaddr: test.xaddr})
+			assert.Equal(t, test.expected, client.reachableEndpoint(test.endpoint))
+		})
+	}
+}
+
+func TestOnvifClient_replayEvents(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	mockService.On("GetDeviceByName", testDeviceName).Return(models.Device{Name: testDeviceName, ProfileName: testProfileName}, nil)
+	mockService.On("GetProfileByName", testProfileName).Return(models.DeviceProfile{Name: testProfileName}, nil)
+	asyncCh := make(chan *sdkModel.AsyncValues, 10)
+	mockService.On("AsyncValuesChannel").Return(asyncCh)
+
+	mockDevice.On("GetEndpoint", searchServiceName).Return("")
+	mockDevice.On("GetDeviceParams").Return(onvif.DeviceParams{Xaddr: "192.168.1.10:80"})
+	mockDevice.On("CallMethod", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(testGetServicesResponse)),
+	}, nil).Once()
+	endpoint, err := client.searchEndpoint()
+	require.NoError(t, err)
+	assert.Equal(t, "http://192.168.1.10/onvif/search_service", endpoint)
+
+	mockDevice.On("SendSoap", endpoint, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "tse:FindEvents") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testFindEventsResponse))}, nil).Once()
+	mockDevice.On("SendSoap", endpoint, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "tse:GetEventSearchResults") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testEventSearchResultsSearching))}, nil).Once()
+	mockDevice.On("SendSoap", endpoint, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "tse:GetEventSearchResults") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testEventSearchResultsCompleted))}, nil).Once()
+
+	gap := eventGap{start: time.Date(2023, 9, 21, 8, 0, 0, 0, time.UTC), end: time.Date(2023, 9, 21, 9, 0, 0, 0, time.UTC)}
+	published := client.replayEvents(endpoint, []eventReplay{{name: "PullPointSubscription", route: eventRoute{resourceName: CameraEvent, byTopic: true}, gaps: []eventGap{gap}}})
+	assert.Equal(t, 2, published, "the events recorded by several recordings are replayed once")
+	mockDevice.AssertExpectations(t)
+
+	// the events are replayed in order with their original time
+	expected := []struct {
+		state string
+		time  time.Time
+	}{
+		{state: "true", time: time.Date(2023, 9, 21, 8, 5, 0, 0, time.UTC)},
+		{state: "false", time: time.Date(2023, 9, 21, 8, 10, 0, 0, time.UTC)},
+	}
+	for _, e := range expected {
+		values := <-asyncCh
+		require.Len(t, values.CommandValues, 1)
+		cv := values.CommandValues[0]
+		reading, ok := cv.Value.(EventReading)
+		require.True(t, ok)
+		assert.Equal(t, e.state, reading.Data["State"])
+		assert.Equal(t, e.time.UnixNano(), cv.Origin)
+		assert.Equal(t, "true", cv.Tags[ReplayedTag])
+	}
+
+	// the failed gaps are recorded again by the subscription
+	health := &subscriptionHealth{}
+	mockDevice.On("SendSoap", endpoint, mock.Anything).
+		Return(&http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(strings.NewReader(""))}, nil).Once()
+	published = client.replayEvents(endpoint, []eventReplay{{name: "PullPointSubscription", route: eventRoute{resourceName: CameraEvent, byTopic: true}, gaps: []eventGap{gap}, health: health}})
+	assert.Zero(t, published)
+	assert.Equal(t, []eventGap{gap}, health.takeGaps())
+}
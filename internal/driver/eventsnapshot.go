@@ -0,0 +1,252 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+	"github.com/IOTechSystems/onvif/media"
+	xsdOnvif "github.com/IOTechSystems/onvif/xsd/onvif"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+// snapshotQueueSize is the number of event batches of a camera which can wait for their snapshots
+const snapshotQueueSize = 64
+
+// videoSourceItems are the Source items which identify the video source of an event, in order of preference
+var videoSourceItems = []string{"VideoSourceConfigurationToken", "VideoSourceToken", "Source"}
+
+// mediaProfileCache caches the media profiles of the camera, which are used to take the snapshots of events
+type mediaProfileCache struct {
+	mu       sync.Mutex
+	profiles []xsdOnvif.Profile
+}
+
+func (cache *mediaProfileCache) get() []xsdOnvif.Profile {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.profiles
+}
+
+func (cache *mediaProfileCache) set(profiles []xsdOnvif.Profile) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.profiles = profiles
+}
+
+// snapshotRequired returns true if a snapshot should be attached to the readings sent on the resources, because the
+// route requests it or one of the resources has the eventSnapshot attribute
+func snapshotRequired(route eventRoute, resources []models.DeviceResource) bool {
+	if route.snapshot {
+		return true
+	}
+	for _, resource := range resources {
+		if value, ok := resource.Attributes[EventSnapshot]; ok {
+			if enabled, err := strconv.ParseBool(fmt.Sprint(value)); err == nil && enabled {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// snapshotProfileToken returns the token of the media profile of the event's video source, or of the first media
+// profile if the event does not identify a video source of a profile
+func snapshotProfileToken(profiles []xsdOnvif.Profile, reading EventReading) (string, bool) {
+	for _, item := range videoSourceItems {
+		token, ok := reading.Source[item]
+		if !ok {
+			continue
+		}
+		for _, profile := range profiles {
+			config := profile.VideoSourceConfiguration
+			if config == nil {
+				continue
+			}
+			if string(config.Token) == token || (config.SourceToken != nil && string(*config.SourceToken) == token) {
+				return string(profile.Token), true
+			}
+		}
+	}
+	if len(profiles) > 0 {
+		return string(profiles[0].Token), true
+	}
+	return "", false
+}
+
+// loadMediaProfiles returns the cached media profiles of the camera, or requests them if they are not cached
+func (onvifClient *OnvifClient) loadMediaProfiles() ([]xsdOnvif.Profile, errors.EdgeX) {
+	if profiles := onvifClient.mediaProfiles.get(); profiles != nil {
+		return profiles, nil
+	}
+	respContent, edgexErr := onvifClient.callOnvifFunction(onvif.MediaWebService, onvif.GetProfiles, nil)
+	if edgexErr != nil {
+		return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	response, ok := respContent.(*media.GetProfilesResponse)
+	if !ok {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid GetProfilesResponse of type %T for the camera %s", respContent, onvifClient.deviceName()), nil)
+	}
+	onvifClient.mediaProfiles.set(response.Profiles)
+	return response.Profiles, nil
+}
+
+// snapshotResourceName returns the name of the Binary resource of the device profile which reads the snapshot
+func (onvifClient *OnvifClient) snapshotResourceName() (string, bool) {
+	sdkService := onvifClient.driver.sdkService
+	device, err := sdkService.GetDeviceByName(onvifClient.deviceName())
+	if err != nil {
+		return "", false
+	}
+	profile, err := sdkService.GetProfileByName(device.ProfileName)
+	if err != nil {
+		return "", false
+	}
+	for _, resource := range profile.DeviceResources {
+		if fmt.Sprint(resource.Attributes[GetFunction]) == GetSnapshot && resource.Properties.ValueType == common.ValueTypeBinary {
+			return resource.Name, true
+		}
+	}
+	return "", false
+}
+
+// newEventSnapshotter returns a function which takes the snapshot of the video source of an event reading, as a Binary
+// command value of the snapshot resource. The snapshots are shared by the readings of the same video source, since
+// they are received at the same time. Nil is returned if the snapshot can not be taken, so that the event is still
+// published without it.
+func (onvifClient *OnvifClient) newEventSnapshotter() func(EventReading) *sdkModel.CommandValue {
+	resourceName := ""
+	snapshots := make(map[string]*sdkModel.CommandValue)
+	return func(reading EventReading) *sdkModel.CommandValue {
+		if resourceName == "" {
+			var ok bool
+			if resourceName, ok = onvifClient.snapshotResourceName(); !ok {
+				onvifClient.lc.Warnf("Unable to attach the snapshot to the '%s' event of device %s, the profile has no %s resource", reading.Topic, onvifClient.deviceName(), GetSnapshot)
+				return nil
+			}
+		}
+
+		profiles, edgexErr := onvifClient.loadMediaProfiles()
+		if edgexErr != nil {
+			onvifClient.lc.Warnf("Unable to attach the snapshot to the '%s' event of device %s, %v", reading.Topic, onvifClient.deviceName(), edgexErr)
+			return nil
+		}
+		profileToken, ok := snapshotProfileToken(profiles, reading)
+		if !ok {
+			onvifClient.lc.Warnf("Unable to attach the snapshot to the '%s' event of device %s, the camera has no media profile", reading.Topic, onvifClient.deviceName())
+			return nil
+		}
+		if cv, ok := snapshots[profileToken]; ok {
+			return cv
+		}
+
+		data, _ := json.Marshal(media.GetSnapshotUri{ProfileToken: xsdOnvif.ReferenceToken(profileToken)})
+		image, edgexErr := onvifClient.callGetSnapshotFunction(data)
+		if edgexErr != nil {
+			// the profiles may have been changed, so they are requested again for the next event
+			onvifClient.mediaProfiles.set(nil)
+			onvifClient.lc.Warnf("Unable to attach the snapshot of profile '%s' to the '%s' event of device %s, %v", profileToken, reading.Topic, onvifClient.deviceName(), edgexErr)
+			return nil
+		}
+		cv, err := sdkModel.NewCommandValue(resourceName, common.ValueTypeBinary, image)
+		if err != nil {
+			onvifClient.lc.Warnf("Unable to create the snapshot reading of device %s, %v", onvifClient.deviceName(), err)
+			return nil
+		}
+		snapshots[profileToken] = cv
+		return cv
+	}
+}
+
+// snapshotJob is a batch of event readings which are sent once their snapshots are taken
+type snapshotJob struct {
+	route    eventRoute
+	readings []EventReading
+	received time.Time
+}
+
+// snapshotQueue takes the snapshots of the events of a camera in the background, in the order of the events. The
+// zero value is a queue which is started by the first event.
+type snapshotQueue struct {
+	mu      sync.Mutex
+	jobs    chan snapshotJob
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// queueSnapshotEvents queues the readings until their snapshots are taken. The readings are sent without snapshots
+// if the queue is full, so that the events are not lost when the camera is slow to take the snapshots.
+func (onvifClient *OnvifClient) queueSnapshotEvents(job snapshotJob) {
+	queue := &onvifClient.snapshotQueue
+	queue.mu.Lock()
+	if queue.stopped {
+		queue.mu.Unlock()
+		return
+	}
+	if queue.jobs == nil {
+		queue.jobs = make(chan snapshotJob, snapshotQueueSize)
+		queue.wg.Add(1)
+		go func() {
+			defer queue.wg.Done()
+			onvifClient.takeEventSnapshots(queue.jobs)
+		}()
+	}
+	queued := false
+	select {
+	case queue.jobs <- job:
+		queued = true
+	default:
+	}
+	queue.mu.Unlock()
+	if queued {
+		return
+	}
+
+	deviceName := onvifClient.deviceName()
+	onvifClient.lc.Warnf("The snapshot queue of device %s is full, sending %d events without snapshot", deviceName, len(job.readings))
+	onvifClient.driver.sendAsyncValues(deviceName, newEventAsyncValues(onvifClient.driver.sdkService, deviceName, job.route, job.readings, onvifClient.eventDecorators(job.received)))
+}
+
+// takeEventSnapshots sends the queued readings with their snapshots until the queue is stopped
+func (onvifClient *OnvifClient) takeEventSnapshots(jobs <-chan snapshotJob) {
+	for job := range jobs {
+		if onvifClient.snapshotQueueStopped() {
+			continue
+		}
+		deviceName := onvifClient.deviceName()
+		decorators := onvifClient.eventDecorators(job.received)
+		decorators.snapshot = onvifClient.newEventSnapshotter()
+		onvifClient.driver.sendAsyncValues(deviceName, newEventAsyncValues(onvifClient.driver.sdkService, deviceName, job.route, job.readings, decorators))
+	}
+}
+
+func (onvifClient *OnvifClient) snapshotQueueStopped() bool {
+	onvifClient.snapshotQueue.mu.Lock()
+	defer onvifClient.snapshotQueue.mu.Unlock()
+	return onvifClient.snapshotQueue.stopped
+}
+
+// stopSnapshotQueue stops the snapshot queue of a removed camera, or of the stopped service. The queued readings are
+// discarded, and the readings whose snapshot is being taken are sent before it returns.
+func (onvifClient *OnvifClient) stopSnapshotQueue() {
+	queue := &onvifClient.snapshotQueue
+	queue.mu.Lock()
+	if !queue.stopped && queue.jobs != nil {
+		close(queue.jobs)
+	}
+	queue.stopped = true
+	queue.mu.Unlock()
+	queue.wg.Wait()
+}
@@ -0,0 +1,207 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	xsdOnvif "github.com/IOTechSystems/onvif/xsd/onvif"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGetProfilesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <env:Body>
+    <trt:GetProfilesResponse>
+      <trt:Profiles token="profile_1" fixed="true">
+        <tt:Name>Profile1</tt:Name>
+        <tt:VideoSourceConfiguration token="VideoSourceConfig_1">
+          <tt:Name>VideoSourceConfig1</tt:Name>
+          <tt:SourceToken>VideoSource_1</tt:SourceToken>
+        </tt:VideoSourceConfiguration>
+      </trt:Profiles>
+      <trt:Profiles token="profile_2" fixed="true">
+        <tt:Name>Profile2</tt:Name>
+        <tt:VideoSourceConfiguration token="VideoSourceConfig_2">
+          <tt:Name>VideoSourceConfig2</tt:Name>
+          <tt:SourceToken>VideoSource_2</tt:SourceToken>
+        </tt:VideoSourceConfiguration>
+      </trt:Profiles>
+    </trt:GetProfilesResponse>
+  </env:Body>
+</env:Envelope>`
+	testGetSnapshotUriResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <env:Body>
+    <trt:GetSnapshotUriResponse>
+      <trt:MediaUri>
+        <tt:Uri>http://192.168.1.10/snapshot/profile_2.jpg</tt:Uri>
+      </trt:MediaUri>
+    </trt:GetSnapshotUriResponse>
+  </env:Body>
+</env:Envelope>`
+)
+
+func TestSnapshotProfileToken(t *testing.T) {
+	sourceToken := xsdOnvif.ReferenceToken("VideoSource_2")
+	profiles := []xsdOnvif.Profile{
+		{Token: "profile_1"},
+		{Token: "profile_2", VideoSourceConfiguration: &xsdOnvif.VideoSourceConfiguration{
+			ConfigurationEntity: xsdOnvif.ConfigurationEntity{Token: "VideoSourceConfig_2"},
+			SourceToken:         &sourceToken,
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		source   map[string]string
+		expected string
+	}{
+		{name: "video source configuration", source: map[string]string{"VideoSourceConfigurationToken": "VideoSourceConfig_2"}, expected: "profile_2"},
+		{name: "video source", source: map[string]string{"Source": "VideoSource_2"}, expected: "profile_2"},
+		{name: "unknown video source", source: map[string]string{"Source": "VideoSource_9"}, expected: "profile_1"},
+		{name: "no source", expected: "profile_1"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			token, ok := snapshotProfileToken(profiles, EventReading{Source: test.source})
+			require.True(t, ok)
+			assert.Equal(t, test.expected, token)
+		})
+	}
+
+	_, ok := snapshotProfileToken(nil, EventReading{})
+	assert.False(t, ok)
+}
+
+func TestNewEventAsyncValues_snapshot(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	mockService.On("GetDeviceByName", testDeviceName).Return(models.Device{Name: testDeviceName, ProfileName: testProfileName}, nil)
+	mockService.On("GetProfileByName", testProfileName).Return(models.DeviceProfile{
+		Name: testProfileName,
+		DeviceResources: []models.DeviceResource{
+			{
+				Name:       "Snapshot",
+				Attributes: map[string]any{Service: EdgeXWebService, GetFunction: GetSnapshot},
+				Properties: models.ResourceProperties{ValueType: common.ValueTypeBinary},
+			},
+			{
+				Name:       "MotionAlarm",
+				Attributes: map[string]any{EventTopic: "tns1:VideoSource/MotionAlarm", EventSnapshot: true},
+			},
+		},
+	}, nil)
+
+	mockDevice.On("GetEndpointByRequestStruct", mock.Anything).Return("http://192.168.1.10/onvif/media_service", nil)
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "GetProfiles") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testGetProfilesResponse))}, nil).Once()
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "profile_2") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testGetSnapshotUriResponse))}, nil).Once()
+	mockDevice.On("SendGetSnapshotRequest", "http://192.168.1.10/snapshot/profile_2.jpg").
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("jpeg"))}, nil).Once()
+
+	readings := []EventReading{
+		{Topic: "tns1:VideoSource/MotionAlarm", Source: map[string]string{"Source": "VideoSource_2"}, Data: map[string]string{"State": "true"}},
+		{Topic: "tns1:VideoSource/MotionAlarm", Source: map[string]string{"Source": "VideoSource_2"}, Data: map[string]string{"State": "false"}},
+		{Topic: "tns1:Device/Trigger/DigitalInput", Data: map[string]string{"LogicalState": "true"}},
+	}
+	asyncValues := newEventAsyncValues(driver.sdkService, testDeviceName, eventRoute{resourceName: CameraEvent, byTopic: true}, readings, eventDecorators{snapshot: client.newEventSnapshotter()})
+	require.Len(t, asyncValues, 3)
+
+	// the readings of the same video source share the snapshot
+	for _, values := range asyncValues[:2] {
+		// the SDK drops the events of several readings without a source
+		assert.Equal(t, "MotionAlarm", values.SourceName)
+		require.Len(t, values.CommandValues, 2)
+		assert.Equal(t, "MotionAlarm", values.CommandValues[0].DeviceResourceName)
+		assert.Equal(t, "Snapshot", values.CommandValues[1].DeviceResourceName)
+		assert.Equal(t, common.ValueTypeBinary, values.CommandValues[1].Type)
+		assert.Equal(t, []byte("jpeg"), values.CommandValues[1].Value)
+	}
+	// the topic without the eventSnapshot attribute is published without a snapshot
+	require.Len(t, asyncValues[2].CommandValues, 1)
+	assert.Equal(t, CameraEvent, asyncValues[2].CommandValues[0].DeviceResourceName)
+	assert.Equal(t, CameraEvent, asyncValues[2].SourceName)
+	mockDevice.AssertExpectations(t)
+
+	// the snapshot requested by the subscription is attached to every reading, and the event is still published if
+	// the snapshot fails
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "profile_1") })).
+		Return(&http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(strings.NewReader(""))}, nil).Once()
+	asyncValues = newEventAsyncValues(driver.sdkService, testDeviceName, eventRoute{resourceName: CameraEvent, byTopic: true, snapshot: true}, readings[2:], eventDecorators{snapshot: client.newEventSnapshotter()})
+	require.Len(t, asyncValues, 1)
+	require.Len(t, asyncValues[0].CommandValues, 1)
+}
+
+// TestOnvifClient_sendEventReadings_snapshot verifies that the events which require a snapshot are sent in the
+// background, without delaying the other events
+func TestOnvifClient_sendEventReadings_snapshot(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	defer client.stopSnapshotQueue()
+	mockService.On("GetDeviceByName", testDeviceName).Return(models.Device{Name: testDeviceName, ProfileName: testProfileName}, nil)
+	mockService.On("GetProfileByName", testProfileName).Return(models.DeviceProfile{
+		Name: testProfileName,
+		DeviceResources: []models.DeviceResource{
+			{
+				Name:       "Snapshot",
+				Attributes: map[string]any{Service: EdgeXWebService, GetFunction: GetSnapshot},
+				Properties: models.ResourceProperties{ValueType: common.ValueTypeBinary},
+			},
+			{
+				Name:       "MotionAlarm",
+				Attributes: map[string]any{EventTopic: "tns1:VideoSource/MotionAlarm", EventSnapshot: true},
+			},
+		},
+	}, nil)
+	asyncCh := make(chan *sdkModel.AsyncValues, 2)
+	mockService.On("AsyncValuesChannel").Return(asyncCh)
+
+	release := make(chan time.Time)
+	mockDevice.On("GetEndpointByRequestStruct", mock.Anything).Return("http://192.168.1.10/onvif/media_service", nil)
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "GetProfiles") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testGetProfilesResponse))}, nil).Once()
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "profile_2") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testGetSnapshotUriResponse))}, nil).Once()
+	mockDevice.On("SendGetSnapshotRequest", "http://192.168.1.10/snapshot/profile_2.jpg").WaitUntil(release).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("jpeg"))}, nil).Once()
+
+	readings := []EventReading{
+		{Topic: "tns1:VideoSource/MotionAlarm", Source: map[string]string{"Source": "VideoSource_2"}, Data: map[string]string{"State": "true"}},
+		{Topic: "tns1:Device/Trigger/DigitalInput", Data: map[string]string{"LogicalState": "true"}},
+	}
+	client.sendEventReadings(eventRoute{resourceName: CameraEvent, byTopic: true}, readings, time.Now())
+
+	// the event without snapshot is sent while the snapshot is taken
+	select {
+	case values := <-asyncCh:
+		assert.Equal(t, CameraEvent, values.SourceName)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event without snapshot was delayed by the snapshot request")
+	}
+	close(release)
+	select {
+	case values := <-asyncCh:
+		assert.Equal(t, "MotionAlarm", values.SourceName)
+		require.Len(t, values.CommandValues, 2)
+		assert.Equal(t, []byte("jpeg"), values.CommandValues[1].Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event with snapshot was not sent")
+	}
+}
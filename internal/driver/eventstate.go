@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	GetEventState = "GetEventState"
+
+	// PropertyOperation values of the onvif property events
+	PropertyInitialized = "Initialized"
+	PropertyChanged     = "Changed"
+	PropertyDeleted     = "Deleted"
+)
+
+// EventStateRequest is the optional request body of the GetEventState command
+type EventStateRequest struct {
+	// Topic limits the response to the states of the topic
+	Topic string
+	// Source limits the response to the states whose source contains these SimpleItems, for example
+	// {"VideoSourceConfigurationToken": "VideoSource_1"}. It is required by a resource with an eventTopic attribute
+	// when the camera reports the topic for several sources.
+	Source map[string]string
+}
+
+type eventState struct {
+	reading EventReading
+}
+
+// eventStateTable holds the current state of the property events of a camera, keyed by topic and source.
+// The zero value is an empty table.
+type eventStateTable struct {
+	mu     sync.RWMutex
+	states map[string]eventState
+}
+
+// eventStateKey identifies the property of a reading by its topic and source items, for example the motion
+// state of a single video source
+func eventStateKey(reading EventReading) string {
+	sources := make([]string, 0, len(reading.Source))
+	for name, value := range reading.Source {
+		sources = append(sources, name+"="+value)
+	}
+	sort.Strings(sources)
+	return reading.Topic + "|" + strings.Join(sources, ",")
+}
+
+// update applies the property events to the table. Readings which are not property events are ignored.
+func (table *eventStateTable) update(readings []EventReading) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, reading := range readings {
+		switch reading.PropertyOperation {
+		case PropertyInitialized, PropertyChanged:
+			if table.states == nil {
+				table.states = make(map[string]eventState)
+			}
+			table.states[eventStateKey(reading)] = eventState{reading: reading}
+		case PropertyDeleted:
+			delete(table.states, eventStateKey(reading))
+		}
+	}
+}
+
+// list returns the current states of the topic sorted by key, or of all topics if the topic is empty
+func (table *eventStateTable) list(topic string) []EventReading {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	keys := make([]string, 0, len(table.states))
+	for key, state := range table.states {
+		if topic == "" || state.reading.Topic == topic {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	readings := make([]EventReading, 0, len(keys))
+	for _, key := range keys {
+		readings = append(readings, table.states[key].reading)
+	}
+	return readings
+}
+
+// find returns the current states of the topic whose source contains the SimpleItems of the selector, sorted by key
+func (table *eventStateTable) find(topic string, source map[string]string) []EventReading {
+	var readings []EventReading
+	for _, reading := range table.list(topic) {
+		if sourceMatches(reading.Source, source) {
+			readings = append(readings, reading)
+		}
+	}
+	return readings
+}
+
+func sourceMatches(source, selector map[string]string) bool {
+	for name, value := range selector {
+		if source[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// callGetEventStateFunction returns the current state of the camera's property events. For a resource with an
+// eventTopic attribute, the state of the topic for the source selected by the request is returned, as the SimpleItem
+// selected by its eventItem attribute if there is one. Otherwise, the states of all topics, or of the topic and
+// source in the request, are returned.
+func (onvifClient *OnvifClient) callGetEventStateFunction(resourceName string, attributes map[string]interface{}, data []byte) (*sdkModel.CommandValue, errors.EdgeX) {
+	request := EventStateRequest{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &request); err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+		}
+	}
+
+	topic, ok := attributes[EventTopic]
+	if !ok {
+		cv, err := sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, onvifClient.eventStates.find(request.Topic, request.Source))
+		if err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the function '%s'", GetEventState), err)
+		}
+		return cv, nil
+	}
+
+	states := onvifClient.eventStates.find(strings.TrimSpace(fmt.Sprint(topic)), request.Source)
+	if len(states) == 0 {
+		return nil, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("no state of the event topic '%s' has been received from the camera for the source %v", topic, request.Source), nil)
+	}
+	if len(states) > 1 {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("the event topic '%s' has states of %d sources, select one with the Source of the request", topic, len(states)), nil)
+	}
+	reading := states[0]
+	resource, ok := onvifClient.driver.sdkService.DeviceResource(onvifClient.deviceName(), resourceName)
+	if !ok {
+		return nil, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("device resource '%s' not found", resourceName), nil)
+	}
+	cv, edgexErr := newEventCommandValue(resource, reading)
+	if edgexErr != nil {
+		return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	if cv == nil {
+		return nil, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("the state of the event topic '%s' does not contain the item '%v'", topic, attributes[EventItem]), nil)
+	}
+	return cv, nil
+}
@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMotionTopic = "tns1:RuleEngine/CellMotionDetector/Motion"
+
+func TestEventStateTable(t *testing.T) {
+	table := eventStateTable{}
+	source0 := map[string]string{"VideoSourceConfigurationToken": "VideoSource_0"}
+	source1 := map[string]string{"VideoSourceConfigurationToken": "VideoSource_1"}
+
+	table.update([]EventReading{
+		{Topic: testMotionTopic, PropertyOperation: PropertyInitialized, Source: source0, Data: map[string]string{"IsMotion": "false"}},
+		{Topic: testMotionTopic, PropertyOperation: PropertyInitialized, Source: source1, Data: map[string]string{"IsMotion": "false"}},
+		{Topic: "tns1:Device/Trigger/DigitalInput", PropertyOperation: PropertyInitialized, Data: map[string]string{"LogicalState": "true"}},
+		// events without a property operation are not states
+		{Topic: "tns1:Monitoring/ProcessorUsage", Data: map[string]string{"Value": "42"}},
+	})
+	assert.Len(t, table.list(""), 3)
+	assert.Len(t, table.list(testMotionTopic), 2)
+
+	table.update([]EventReading{{Topic: testMotionTopic, PropertyOperation: PropertyChanged, Source: source1, Data: map[string]string{"IsMotion": "true"}}})
+	states := table.list(testMotionTopic)
+	require.Len(t, states, 2)
+	assert.Equal(t, "false", states[0].Data["IsMotion"])
+	assert.Equal(t, "true", states[1].Data["IsMotion"])
+	states = table.find(testMotionTopic, source1)
+	require.Len(t, states, 1)
+	assert.Equal(t, "true", states[0].Data["IsMotion"])
+
+	table.update([]EventReading{{Topic: testMotionTopic, PropertyOperation: PropertyDeleted, Source: source0}})
+	assert.Len(t, table.list(testMotionTopic), 1)
+	assert.Empty(t, table.find("tns1:RuleEngine/TamperDetector/Tamper", nil))
+}
+
+func TestOnvifClient_callGetEventStateFunction(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	attributes := map[string]any{Service: EdgeXWebService, GetFunction: GetEventState, EventTopic: testMotionTopic, EventItem: "Data/IsMotion"}
+	mockService.On("DeviceResource", testDeviceName, "MotionDetected").Return(models.DeviceResource{
+		Name:       "MotionDetected",
+		Attributes: attributes,
+		Properties: models.ResourceProperties{ValueType: common.ValueTypeBool},
+	}, true)
+
+	_, err := client.callCustomFunction("MotionDetected", GetEventState, attributes, nil)
+	require.Error(t, err, "no state has been received")
+
+	client.eventStates.update([]EventReading{{Topic: testMotionTopic, PropertyOperation: PropertyChanged, Data: map[string]string{"IsMotion": "true"}}})
+	cv, err := client.callCustomFunction("MotionDetected", GetEventState, attributes, nil)
+	require.NoError(t, err)
+	assert.Equal(t, true, cv.Value)
+
+	// the state of a topic reported for several sources requires a source selector
+	source0 := map[string]string{"VideoSourceConfigurationToken": "VideoSource_0"}
+	source1 := map[string]string{"VideoSourceConfigurationToken": "VideoSource_1"}
+	client.eventStates = eventStateTable{}
+	client.eventStates.update([]EventReading{
+		{Topic: testMotionTopic, PropertyOperation: PropertyChanged, Source: source0, Data: map[string]string{"IsMotion": "false"}},
+		{Topic: testMotionTopic, PropertyOperation: PropertyChanged, Source: source1, Data: map[string]string{"IsMotion": "true"}},
+	})
+	_, err = client.callCustomFunction("MotionDetected", GetEventState, attributes, nil)
+	require.Error(t, err, "the source is ambiguous")
+	cv, err = client.callCustomFunction("MotionDetected", GetEventState, attributes, []byte(`{"Source": {"VideoSourceConfigurationToken": "VideoSource_0"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, false, cv.Value)
+	cv, err = client.callCustomFunction("MotionDetected", GetEventState, attributes, []byte(`{"Source": {"VideoSourceConfigurationToken": "VideoSource_1"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, true, cv.Value)
+	cv, err = client.callCustomFunction("EventState", GetEventState, map[string]any{}, []byte(`{"Source": {"VideoSourceConfigurationToken": "VideoSource_1"}}`))
+	require.NoError(t, err)
+	assert.Len(t, cv.Value, 1)
+
+	cv, err = client.callCustomFunction("EventState", GetEventState, map[string]any{}, []byte(`{"Topic": "tns1:VideoSource/MotionAlarm"}`))
+	require.NoError(t, err)
+	assert.Empty(t, cv.Value)
+	cv, err = client.callCustomFunction("EventState", GetEventState, map[string]any{}, nil)
+	require.NoError(t, err)
+	assert.Len(t, cv.Value, 2)
+}
@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"sync"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+)
+
+// OutOfRangeUtcTimeTag is the tag which holds the UtcTime of an event whose time is outside the EventTimeToleranceSeconds
+// of the time it was received. The readings of such an event are stamped with the time they were received instead.
+const OutOfRangeUtcTimeTag = "outOfRangeUtcTime"
+
+// cameraClock is the offset of the camera clock from the clock of the device service, which is measured with the
+// CurrentTime reported by the responses of the event service
+type cameraClock struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	measured bool
+}
+
+// update measures the offset with the current time reported by the camera at the local time now. Values which can
+// not be parsed are ignored, since not every camera reports its current time.
+func (clock *cameraClock) update(currentTime string, now time.Time) {
+	t, ok := parseXsdDateTime(currentTime)
+	if !ok {
+		return
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.offset = t.Sub(now)
+	clock.measured = true
+}
+
+// get returns the measured offset, which is zero if it was not measured yet
+func (clock *cameraClock) get() (time.Duration, bool) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.offset, clock.measured
+}
+
+func (d *Driver) eventTimeTolerance() time.Duration {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return time.Duration(d.config.AppCustom.EventTimeToleranceSeconds) * time.Second
+}
+
+// newEventTimestamper returns a function which stamps the command values of a reading with the UtcTime of the event,
+// corrected by the offset of the camera clock. If the corrected time differs from the received time by more than
+// the tolerance, the command values are stamped with the received time and flagged with the OutOfRangeUtcTimeTag.
+// The check is disabled by a zero tolerance, and the origin is left unchanged if the received time is zero and the
+// reading has no valid UtcTime.
+func (onvifClient *OnvifClient) newEventTimestamper(received time.Time, tolerance time.Duration) func(EventReading, []*sdkModel.CommandValue) {
+	offset, _ := onvifClient.clock.get()
+	return func(reading EventReading, commandValues []*sdkModel.CommandValue) {
+		origin := received
+		outOfRange := false
+		if cameraTime, ok := parseXsdDateTime(reading.UtcTime); ok {
+			eventTime := cameraTime.Add(-offset)
+			difference := eventTime.Sub(received)
+			if difference < 0 {
+				difference = -difference
+			}
+			if tolerance > 0 && !received.IsZero() && difference > tolerance {
+				outOfRange = true
+			} else {
+				origin = eventTime
+			}
+		}
+		if outOfRange {
+			onvifClient.lc.Warnf("The time %s of the '%s' event of device %s is outside the tolerance of %v, the event is stamped with the received time",
+				reading.UtcTime, reading.Topic, onvifClient.deviceName(), tolerance)
+		}
+
+		for _, cv := range commandValues {
+			if !origin.IsZero() {
+				cv.Origin = origin.UnixNano()
+			}
+			if outOfRange {
+				if cv.Tags == nil {
+					cv.Tags = make(map[string]string)
+				}
+				cv.Tags[OutOfRangeUtcTimeTag] = reading.UtcTime
+			}
+		}
+	}
+}
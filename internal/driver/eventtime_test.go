@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCameraClock_update(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	var clock cameraClock
+
+	offset, measured := clock.get()
+	assert.False(t, measured)
+	assert.Equal(t, time.Duration(0), offset)
+
+	clock.update("2023-04-01T12:00:30Z", now)
+	offset, measured = clock.get()
+	assert.True(t, measured)
+	assert.Equal(t, 30*time.Second, offset)
+
+	// an invalid current time keeps the last measured offset
+	clock.update("", now)
+	offset, _ = clock.get()
+	assert.Equal(t, 30*time.Second, offset)
+}
+
+func TestOnvifClient_newEventTimestamper(t *testing.T) {
+	received := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		offset         string
+		received       time.Time
+		tolerance      time.Duration
+		utcTime        string
+		expectedOrigin time.Time
+		outOfRange     bool
+	}{
+		{
+			name:           "no offset",
+			received:       received,
+			tolerance:      time.Minute,
+			utcTime:        "2023-04-01T11:59:58Z",
+			expectedOrigin: received.Add(-2 * time.Second),
+		},
+		{
+			name:           "corrected by the offset",
+			offset:         "2023-04-01T13:00:00Z",
+			received:       received,
+			tolerance:      time.Minute,
+			utcTime:        "2023-04-01T12:59:58Z",
+			expectedOrigin: received.Add(-2 * time.Second),
+		},
+		{
+			name:           "out of range",
+			received:       received,
+			tolerance:      time.Minute,
+			utcTime:        "2023-04-01T11:00:00Z",
+			expectedOrigin: received,
+			outOfRange:     true,
+		},
+		{
+			name:           "check disabled",
+			received:       received,
+			utcTime:        "2023-04-01T11:00:00Z",
+			expectedOrigin: received.Add(-time.Hour),
+		},
+		{
+			name:           "invalid utc time",
+			received:       received,
+			tolerance:      time.Minute,
+			utcTime:        "invalid",
+			expectedOrigin: received,
+		},
+		{
+			name:           "replayed without received time",
+			tolerance:      time.Minute,
+			utcTime:        "2023-04-01T11:00:00Z",
+			expectedOrigin: received.Add(-time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			driver, _ := createDriverWithMockService()
+			client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+			if test.offset != "" {
+				client.clock.update(test.offset, received)
+			}
+
+			cv, err := sdkModel.NewCommandValue(CameraEvent, common.ValueTypeString, "event")
+			require.NoError(t, err)
+			reading := EventReading{Topic: "tns1:VideoSource/MotionAlarm", UtcTime: test.utcTime}
+			client.newEventTimestamper(test.received, test.tolerance)(reading, []*sdkModel.CommandValue{cv})
+
+			assert.Equal(t, test.expectedOrigin.UnixNano(), cv.Origin)
+			if test.outOfRange {
+				assert.Equal(t, test.utcTime, cv.Tags[OutOfRangeUtcTimeTag])
+			} else {
+				assert.NotContains(t, cv.Tags, OutOfRangeUtcTimeTag)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/IOTechSystems/onvif/event"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const GetEventTopics = "GetEventTopics"
+
+// TopicDescription describes a topic supported by the camera, as advertised in the TopicSet of GetEventProperties
+type TopicDescription struct {
+	// Topic is the concrete topic path, for example tns1:RuleEngine/CellMotionDetector/Motion
+	Topic string
+	// IsProperty indicates the topic is a property event, which reports the state of the Source
+	IsProperty bool
+	// Source, Key and Data describe the items of the event messages of the topic
+	Source []EventItemDescription `json:",omitempty"`
+	Key    []EventItemDescription `json:",omitempty"`
+	Data   []EventItemDescription `json:",omitempty"`
+}
+
+// EventItemDescription is the name and the type of a SimpleItem or ElementItem of an event message
+type EventItemDescription struct {
+	Name string
+	Type string
+}
+
+// eventTopicCatalog is the normalized TopicSet of a camera
+type eventTopicCatalog struct {
+	// topics are the topics with a message description, sorted by topic
+	topics []TopicDescription
+	// nodes are the paths of all the topics of the TopicSet, including the topic namespaces, without the namespace prefixes
+	nodes [][]string
+}
+
+// parseEventTopicCatalog parses the TopicSet of a GetEventProperties response. The response is walked with raw
+// tokens, so that the namespace prefixes of the topics are kept as they are published in the event messages.
+func parseEventTopicCatalog(data []byte) (*eventTopicCatalog, errors.EdgeX) {
+	catalog := &eventTopicCatalog{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var path []string
+	var current *TopicDescription
+	var section *[]EventItemDescription
+	inTopicSet, found := false, false
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, "failed to parse the event properties", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case !inTopicSet:
+				if t.Name.Local == "TopicSet" {
+					inTopicSet, found = true, true
+				}
+			case current != nil:
+				switch t.Name.Local {
+				case "Source":
+					section = &current.Source
+				case "Key":
+					section = &current.Key
+				case "Data":
+					section = &current.Data
+				case "SimpleItemDescription", "ElementItemDescription":
+					if section != nil {
+						*section = append(*section, EventItemDescription{Name: xmlAttr(t, "Name"), Type: xmlAttr(t, "Type")})
+					}
+				}
+			case t.Name.Local == "MessageDescription":
+				current = &TopicDescription{Topic: strings.Join(path, "/"), IsProperty: xmlAttr(t, "IsProperty") == "true"}
+			default:
+				segment := t.Name.Local
+				if t.Name.Space != "" {
+					segment = t.Name.Space + ":" + segment
+				}
+				path = append(path, segment)
+				catalog.nodes = append(catalog.nodes, topicSegments(strings.Join(path, "/")))
+			}
+		case xml.EndElement:
+			switch {
+			case !inTopicSet:
+			case current != nil:
+				switch t.Name.Local {
+				case "MessageDescription":
+					if current.Topic != "" {
+						catalog.topics = append(catalog.topics, *current)
+					}
+					current, section = nil, nil
+				case "Source", "Key", "Data":
+					section = nil
+				}
+			case len(path) == 0:
+				inTopicSet = false
+			default:
+				path = path[:len(path)-1]
+			}
+		}
+	}
+	if !found {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, "the event properties do not contain a TopicSet", nil)
+	}
+
+	sort.Slice(catalog.topics, func(i, j int) bool {
+		return catalog.topics[i].Topic < catalog.topics[j].Topic
+	})
+	return catalog, nil
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// topicSegments splits a topic path into its segments without namespace prefixes, since the same namespace can be
+// bound to different prefixes by the camera and by the user
+func topicSegments(topic string) []string {
+	segments := strings.Split(topic, "/")
+	for i, segment := range segments {
+		if index := strings.Index(segment, ":"); index >= 0 {
+			segments[i] = segment[index+1:]
+		}
+	}
+	return segments
+}
+
+// validateTopicFilter verifies every alternative of a topic expression matches at least one topic of the catalog.
+// Concrete topics, the * wildcard segment and the descendant selector // of the ONVIF topic expression dialect
+// are supported, for example tns1:RuleEngine/CellMotionDetector/Motion|tns1:VideoSource//.
+func (catalog *eventTopicCatalog) validateTopicFilter(filter string) errors.EdgeX {
+	for _, expression := range strings.Split(filter, "|") {
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			return errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("the TopicFilter '%s' contains an empty topic expression", filter), nil)
+		}
+		if !catalog.matches(expression) {
+			return errors.NewCommonEdgeX(errors.KindContractInvalid,
+				fmt.Sprintf("the topic expression '%s' of the TopicFilter does not match any topic supported by the camera", expression), nil)
+		}
+	}
+	return nil
+}
+
+func (catalog *eventTopicCatalog) matches(expression string) bool {
+	// the trailing . of a descendant selector selects the node itself, so it is equivalent to the empty segment
+	expression = strings.TrimSuffix(expression, "/.")
+	pattern := topicSegments(expression)
+	for _, node := range catalog.nodes {
+		if matchTopicSegments(pattern, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTopicSegments matches a topic path with a pattern, in which * matches any segment and an empty segment
+// matches any number of segments
+func matchTopicSegments(pattern, node []string) bool {
+	if len(pattern) == 0 {
+		return len(node) == 0
+	}
+	if pattern[0] == "" {
+		for i := 0; i <= len(node); i++ {
+			if matchTopicSegments(pattern[1:], node[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(node) == 0 || (pattern[0] != "*" && pattern[0] != node[0]) {
+		return false
+	}
+	return matchTopicSegments(pattern[1:], node[1:])
+}
+
+// loadEventTopics requests the event properties from the camera, and caches its topic catalog
+func (onvifClient *OnvifClient) loadEventTopics() (*eventTopicCatalog, errors.EdgeX) {
+	servResp, err := onvifClient.onvifDevice.CallMethod(event.GetEventProperties{})
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServiceUnavailable, "failed to request the event properties", err)
+	}
+	defer servResp.Body.Close()
+	data, err := io.ReadAll(servResp.Body)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, "failed to read the event properties", err)
+	}
+	if servResp.StatusCode >= http.StatusBadRequest {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to request the event properties, status code: %d", servResp.StatusCode), nil)
+	}
+	catalog, edgexErr := parseEventTopicCatalog(data)
+	if edgexErr != nil {
+		return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+
+	onvifClient.eventTopicsMu.Lock()
+	onvifClient.eventTopics = catalog
+	onvifClient.eventTopicsMu.Unlock()
+	return catalog, nil
+}
+
+// eventTopicCatalog returns the cached topic catalog of the camera, or loads it if it is not cached yet
+func (onvifClient *OnvifClient) eventTopicCatalog() (*eventTopicCatalog, errors.EdgeX) {
+	onvifClient.eventTopicsMu.Lock()
+	catalog := onvifClient.eventTopics
+	onvifClient.eventTopicsMu.Unlock()
+	if catalog != nil {
+		return catalog, nil
+	}
+	return onvifClient.loadEventTopics()
+}
+
+// resetEventTopics clears the cached topic catalog, since the topics of the camera can change with its firmware
+func (onvifClient *OnvifClient) resetEventTopics() {
+	onvifClient.eventTopicsMu.Lock()
+	defer onvifClient.eventTopicsMu.Unlock()
+	onvifClient.eventTopics = nil
+}
+
+// topicValidator returns the topic catalog used to validate the TopicFilter of a subscription request, or nil if the
+// filter can not be validated because the event properties of the camera are not available
+func (onvifClient *OnvifClient) topicValidator() *eventTopicCatalog {
+	catalog, edgexErr := onvifClient.eventTopicCatalog()
+	if edgexErr != nil {
+		onvifClient.lc.Warnf("Unable to validate the TopicFilter with the event properties of device %s, %v", onvifClient.deviceName(), edgexErr)
+		return nil
+	}
+	return catalog
+}
+
+func (onvifClient *OnvifClient) callGetEventTopicsFunction(resourceName string) (*sdkModel.CommandValue, errors.EdgeX) {
+	// the catalog is always reloaded by the read command, so that it reflects the current topics of the camera
+	catalog, edgexErr := onvifClient.loadEventTopics()
+	if edgexErr != nil {
+		return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	cv, err := sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, catalog.topics)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the function '%s'", GetEventTopics), err)
+	}
+	return cv, nil
+}
@@ -0,0 +1,162 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testEventPropertiesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tev="http://www.onvif.org/ver10/events/wsdl" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:wstop="http://docs.oasis-open.org/wsn/t-1" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:tns1="http://www.onvif.org/ver10/topics" xmlns:tnshik="http://www.hikvision.com/2011/event/topics">
+  <env:Body>
+    <tev:GetEventPropertiesResponse>
+      <tev:TopicNamespaceLocation>http://www.onvif.org/onvif/ver10/topics/topicns.xml</tev:TopicNamespaceLocation>
+      <wsnt:FixedTopicSet>true</wsnt:FixedTopicSet>
+      <wstop:TopicSet>
+        <tns1:UserAlarm wstop:topic="true">
+          <tnshik:IllegalAccess wstop:topic="true"/>
+        </tns1:UserAlarm>
+        <tns1:RuleEngine wstop:topic="true">
+          <CellMotionDetector wstop:topic="true">
+            <Motion wstop:topic="true">
+              <tt:MessageDescription IsProperty="true">
+                <tt:Source>
+                  <tt:SimpleItemDescription Name="VideoSourceConfigurationToken" Type="tt:ReferenceToken"/>
+                  <tt:SimpleItemDescription Name="Rule" Type="xs:string"/>
+                </tt:Source>
+                <tt:Data>
+                  <tt:SimpleItemDescription Name="IsMotion" Type="xs:boolean"/>
+                </tt:Data>
+              </tt:MessageDescription>
+            </Motion>
+          </CellMotionDetector>
+        </tns1:RuleEngine>
+        <tns1:Device wstop:topic="true">
+          <Trigger wstop:topic="true">
+            <DigitalInput wstop:topic="true">
+              <tt:MessageDescription>
+                <tt:Source>
+                  <tt:SimpleItemDescription Name="InputToken" Type="tt:ReferenceToken"/>
+                </tt:Source>
+                <tt:Data>
+                  <tt:SimpleItemDescription Name="LogicalState" Type="xs:boolean"/>
+                </tt:Data>
+              </tt:MessageDescription>
+            </DigitalInput>
+          </Trigger>
+        </tns1:Device>
+      </wstop:TopicSet>
+      <tev:TopicExpressionDialect>http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet</tev:TopicExpressionDialect>
+    </tev:GetEventPropertiesResponse>
+  </env:Body>
+</env:Envelope>`
+
+func TestParseEventTopicCatalog(t *testing.T) {
+	catalog, err := parseEventTopicCatalog([]byte(testEventPropertiesResponse))
+	require.NoError(t, err)
+
+	expected := []TopicDescription{
+		{
+			Topic:  "tns1:Device/Trigger/DigitalInput",
+			Source: []EventItemDescription{{Name: "InputToken", Type: "tt:ReferenceToken"}},
+			Data:   []EventItemDescription{{Name: "LogicalState", Type: "xs:boolean"}},
+		},
+		{
+			Topic:      "tns1:RuleEngine/CellMotionDetector/Motion",
+			IsProperty: true,
+			Source: []EventItemDescription{
+				{Name: "VideoSourceConfigurationToken", Type: "tt:ReferenceToken"},
+				{Name: "Rule", Type: "xs:string"},
+			},
+			Data: []EventItemDescription{{Name: "IsMotion", Type: "xs:boolean"}},
+		},
+	}
+	assert.Equal(t, expected, catalog.topics)
+
+	_, err = parseEventTopicCatalog([]byte(testEmptyPullMessagesResponse))
+	require.Error(t, err)
+	_, err = parseEventTopicCatalog([]byte("<invalid"))
+	require.Error(t, err)
+}
+
+func TestEventTopicCatalog_validateTopicFilter(t *testing.T) {
+	catalog, err := parseEventTopicCatalog([]byte(testEventPropertiesResponse))
+	require.NoError(t, err)
+
+	tests := []struct {
+		filter        string
+		errorExpected bool
+	}{
+		{filter: "tns1:RuleEngine/CellMotionDetector/Motion"},
+		{filter: "tns1:RuleEngine/CellMotionDetector/Motion|tns1:Device/Trigger/DigitalInput"},
+		{filter: "tns1:RuleEngine//."},
+		{filter: "tns1:RuleEngine/*/Motion"},
+		{filter: "tns1:UserAlarm/tnshik:IllegalAccess"},
+		{filter: "onvif:Device/Trigger/DigitalInput"},
+		{filter: "tns1:RuleEngine/CellMotionDetector/Motoin", errorExpected: true},
+		{filter: "tns1:RuleEngine/CellMotionDetector/Motion|tns1:VideoSource//.", errorExpected: true},
+		{filter: "tns1:RuleEngine/CellMotionDetector/Motion|", errorExpected: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.filter, func(t *testing.T) {
+			err := catalog.validateTopicFilter(test.filter)
+			if test.errorExpected {
+				require.Error(t, err)
+				assert.Equal(t, errors.KindContractInvalid, errors.Kind(err))
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewSubscriptionRequest_topicFilter(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	mockDevice.On("CallMethod", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(testEventPropertiesResponse)),
+	}, nil).Once()
+
+	attributes := map[string]interface{}{DefaultInitialTerminationTime: "PT1H"}
+	_, err := newSubscriptionRequest(attributes, []byte(`{"TopicFilter": "tns1:RuleEngine/CellMotionDetector/Motion"}`), client.topicValidator)
+	require.NoError(t, err)
+	_, err = newSubscriptionRequest(attributes, []byte(`{"TopicFilter": "tns1:RuleEngine/CellMotionDetecter/Motion"}`), client.topicValidator)
+	require.Error(t, err)
+	assert.Equal(t, errors.KindContractInvalid, errors.Kind(err))
+
+	// the catalog is cached, and the read command reloads it
+	mockDevice.AssertNumberOfCalls(t, "CallMethod", 1)
+	mockDevice.On("CallMethod", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(testEventPropertiesResponse)),
+	}, nil).Once()
+	cv, err := client.callGetEventTopicsFunction(GetEventTopics)
+	require.NoError(t, err)
+	topics, ok := cv.Value.([]TopicDescription)
+	require.True(t, ok)
+	assert.Len(t, topics, 2)
+
+	// the filter is not validated when the event properties are not available
+	client.resetEventTopics()
+	mockDevice.On("CallMethod", mock.Anything).Return(&http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}, nil).Once()
+	_, err = newSubscriptionRequest(attributes, []byte(`{"TopicFilter": "tns1:Unknown"}`), client.topicValidator)
+	require.NoError(t, err)
+}
@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+const (
+	GetMaintenanceMode = "GetMaintenanceMode"
+	SetMaintenanceMode = "SetMaintenanceMode"
+	// MaintenanceUntil is the protocol property which holds the end time of the camera's maintenance window
+	MaintenanceUntil = "MaintenanceUntil"
+
+	// maintenancePollInterval is the interval at which paused pull point subscribers check if the
+	// maintenance window has ended
+	maintenancePollInterval = time.Second
+)
+
+// MaintenanceRequest is the request body of the SetMaintenanceMode command
+type MaintenanceRequest struct {
+	// Enabled starts the maintenance window when true, and ends the active maintenance window when false
+	Enabled bool
+	// Duration is the optional ISO 8601 length of the maintenance window, for example PT10M. The
+	// MaintenanceWindowSeconds configuration is used if it is not set.
+	Duration string
+}
+
+// MaintenanceStatus is the response of the GetMaintenanceMode command
+type MaintenanceStatus struct {
+	Enabled bool
+	// Until is the RFC 3339 end time of the maintenance window
+	Until string `json:",omitempty"`
+}
+
+// maintenanceFunctions are the onvif functions which make the camera unavailable, and automatically
+// start a maintenance window when they are sent by the device service
+var maintenanceFunctions = map[string]bool{
+	onvif.SystemReboot:            true,
+	onvif.SetSystemFactoryDefault: true,
+	onvif.UpgradeSystemFirmware:   true,
+	onvif.StartFirmwareUpgrade:    true,
+	onvif.StartSystemRestore:      true,
+}
+
+// inMaintenance returns true while the camera's maintenance window is active
+func (onvifClient *OnvifClient) inMaintenance() bool {
+	onvifClient.maintenanceMu.Lock()
+	defer onvifClient.maintenanceMu.Unlock()
+	return !onvifClient.maintenanceUntil.IsZero()
+}
+
+// maintenanceStatus returns the current state of the camera's maintenance window
+func (onvifClient *OnvifClient) maintenanceStatus() MaintenanceStatus {
+	onvifClient.maintenanceMu.Lock()
+	defer onvifClient.maintenanceMu.Unlock()
+	if onvifClient.maintenanceUntil.IsZero() {
+		return MaintenanceStatus{}
+	}
+	return MaintenanceStatus{Enabled: true, Until: onvifClient.maintenanceUntil.Format(time.RFC3339)}
+}
+
+// setMaintenanceMode handles the SetMaintenanceMode command
+func (d *Driver) setMaintenanceMode(onvifClient *OnvifClient, data []byte) errors.EdgeX {
+	var request MaintenanceRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+	}
+
+	if !request.Enabled {
+		d.endMaintenance(onvifClient)
+		return nil
+	}
+
+	duration := d.defaultMaintenanceWindow()
+	if request.Duration != "" {
+		var err error
+		duration, err = ParseISO8601(request.Duration)
+		if err != nil {
+			return errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid maintenance duration, %v", err), err)
+		}
+	}
+	if duration <= 0 {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "the maintenance duration should be greater than zero", nil)
+	}
+	return d.startMaintenance(onvifClient, time.Now().Add(duration))
+}
+
+func (d *Driver) defaultMaintenanceWindow() time.Duration {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return time.Duration(d.config.AppCustom.MaintenanceWindowSeconds) * time.Second
+}
+
+// startMaintenance suppresses status checks, subscription renewals and alerts for the camera until the specified
+// time. The end time is stored in the protocol properties so that the window survives a service restart.
+func (d *Driver) startMaintenance(onvifClient *OnvifClient, until time.Time) errors.EdgeX {
+	d.lc.Infof("Device %s is in maintenance until %s", onvifClient.deviceName(), until.Format(time.RFC3339))
+	d.scheduleMaintenanceEnd(onvifClient, until)
+	onvifClient.pauseMetadataStream()
+
+	unlock := d.lockDeviceProtocols(onvifClient.deviceName())
+	defer unlock()
+	device, err := d.latestDevice(onvifClient.deviceName())
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", onvifClient.deviceName()), err)
+	}
+	device.Protocols[OnvifProtocol][MaintenanceUntil] = until.Format(time.RFC3339)
+	if err = d.patchDeviceProtocols(device.Name, device.Protocols); err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", device.Name), err)
+	}
+	return nil
+}
+
+// restoreMaintenance resumes the maintenance window stored in the protocol properties of a device
+func (d *Driver) restoreMaintenance(onvifClient *OnvifClient, device models.Device) {
+	value := protocolValue(device.Protocols[OnvifProtocol], MaintenanceUntil)
+	if value == "" {
+		return
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		d.lc.Warnf("Ignoring invalid %s value '%s' of device %s: %s", MaintenanceUntil, value, device.Name, err.Error())
+		return
+	}
+	d.lc.Infof("Resuming the maintenance window of device %s until %s", device.Name, value)
+	// an expired window is still scheduled, so that the device is refreshed and resubscribed immediately
+	d.scheduleMaintenanceEnd(onvifClient, until)
+}
+
+func (d *Driver) scheduleMaintenanceEnd(onvifClient *OnvifClient, until time.Time) {
+	onvifClient.maintenanceMu.Lock()
+	defer onvifClient.maintenanceMu.Unlock()
+	onvifClient.maintenanceUntil = until
+	if onvifClient.maintenanceTimer != nil {
+		onvifClient.maintenanceTimer.Stop()
+	}
+	onvifClient.maintenanceTimer = time.AfterFunc(time.Until(until), func() {
+		d.endMaintenance(onvifClient)
+	})
+}
+
+// discardMaintenance stops the scheduled end of the maintenance window of a client which is discarded or removed,
+// so that endMaintenance does not run on a stale client. The window stored in the protocol properties is kept.
+func (onvifClient *OnvifClient) discardMaintenance() {
+	onvifClient.maintenanceMu.Lock()
+	defer onvifClient.maintenanceMu.Unlock()
+	// a timer which already fired returns early from endMaintenance
+	onvifClient.maintenanceUntil = time.Time{}
+	if onvifClient.maintenanceTimer != nil {
+		onvifClient.maintenanceTimer.Stop()
+		onvifClient.maintenanceTimer = nil
+	}
+}
+
+// endMaintenance ends the maintenance window of the camera, then checks its status and re-creates the event
+// subscriptions, which are lost when the camera reboots
+func (d *Driver) endMaintenance(onvifClient *OnvifClient) {
+	onvifClient.maintenanceMu.Lock()
+	if onvifClient.maintenanceUntil.IsZero() {
+		onvifClient.maintenanceMu.Unlock()
+		return
+	}
+	onvifClient.maintenanceUntil = time.Time{}
+	if onvifClient.maintenanceTimer != nil {
+		onvifClient.maintenanceTimer.Stop()
+		onvifClient.maintenanceTimer = nil
+	}
+	onvifClient.maintenanceMu.Unlock()
+
+	deviceName := onvifClient.deviceName()
+	d.lc.Infof("The maintenance window of device %s has ended", deviceName)
+
+	unlock := d.lockDeviceProtocols(deviceName)
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		unlock()
+		d.lc.Errorf("Unable to get device %s from cache after the maintenance window: %s", deviceName, err.Error())
+		return
+	}
+	delete(device.Protocols[OnvifProtocol], MaintenanceUntil)
+	if err = d.patchDeviceProtocols(deviceName, device.Protocols); err != nil {
+		d.lc.Errorf("Unable to clear the maintenance window of device %s: %s", deviceName, err.Error())
+	}
+	unlock()
+
+	// the firmware may have been upgraded during the maintenance window. The status check refreshes the device
+	// information if the status changed, and detects the firmware, hardware and reboot changes otherwise.
+	onvifClient.resetEventTopics()
+	d.checkStatusOfDevice(device)
+
+	if onvifClient.pullPointManager != nil {
+		onvifClient.pullPointManager.ResubscribeAll()
+	}
+	if onvifClient.baseNotificationManager != nil {
+		onvifClient.baseNotificationManager.ResubscribeAll()
+	}
+}
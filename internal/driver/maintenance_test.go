@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDriver_setMaintenanceMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		expectedUntil time.Duration
+		errorExpected bool
+	}{
+		{
+			name:          "explicit duration",
+			data:          `{"Enabled": true, "Duration": "PT1H"}`,
+			expectedUntil: time.Hour,
+		},
+		{
+			name:          "default duration",
+			data:          `{"Enabled": true}`,
+			expectedUntil: 300 * time.Second,
+		},
+		{
+			name:          "invalid duration",
+			data:          `{"Enabled": true, "Duration": "1H"}`,
+			errorExpected: true,
+		},
+		{
+			name:          "zero duration",
+			data:          `{"Enabled": true, "Duration": "PT0S"}`,
+			errorExpected: true,
+		},
+		{
+			name:          "invalid json",
+			data:          `{"Enabled": "maybe"}`,
+			errorExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			driver, mockService := createDriverWithMockService()
+			driver.config.AppCustom.MaintenanceWindowSeconds = 300
+			client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+
+			mockService.On("GetDeviceByName", testDeviceName).Return(createTestDevice(), nil).Maybe()
+			mockService.On("PatchDevice", mock.Anything).Return(nil).Maybe()
+
+			start := time.Now()
+			err := driver.setMaintenanceMode(client, []byte(test.data))
+			defer func() {
+				if client.maintenanceTimer != nil {
+					client.maintenanceTimer.Stop()
+				}
+			}()
+
+			if test.errorExpected {
+				require.Error(t, err)
+				assert.False(t, client.inMaintenance())
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, client.inMaintenance())
+
+			status := client.maintenanceStatus()
+			assert.True(t, status.Enabled)
+			until, parseErr := time.Parse(time.RFC3339, status.Until)
+			require.NoError(t, parseErr)
+			assert.WithinDuration(t, start.Add(test.expectedUntil), until, 2*time.Second)
+			mockService.AssertCalled(t, "PatchDevice", mock.Anything)
+		})
+	}
+}
+
+func TestDriver_restoreMaintenance(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][MaintenanceUntil] = "invalid"
+	driver.restoreMaintenance(client, device)
+	assert.False(t, client.inMaintenance())
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	device.Protocols[OnvifProtocol][MaintenanceUntil] = until.Format(time.RFC3339)
+	driver.restoreMaintenance(client, device)
+	defer client.maintenanceTimer.Stop()
+	assert.True(t, client.inMaintenance())
+	assert.Equal(t, MaintenanceStatus{Enabled: true, Until: until.Format(time.RFC3339)}, client.maintenanceStatus())
+}
+
+func TestDriver_removeOnvifClient_discardsMaintenance(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	driver.onvifClients = map[string]*OnvifClient{testDeviceName: client}
+	mockService.On("GetDeviceByName", testDeviceName).Return(createTestDevice(), nil).Maybe()
+
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][MaintenanceUntil] = time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)
+	driver.restoreMaintenance(client, device)
+	require.True(t, client.inMaintenance())
+
+	driver.removeOnvifClient(testDeviceName)
+	assert.False(t, client.inMaintenance())
+	assert.Nil(t, client.maintenanceTimer)
+
+	// the end of the maintenance window is not run on the removed client
+	time.Sleep(300 * time.Millisecond)
+	mockService.AssertNotCalled(t, "GetDeviceByName", testDeviceName)
+}
+
+func TestDriver_removeOnvifClient_unsubscribes(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	driver.onvifClients = map[string]*OnvifClient{testDeviceName: client}
+	consumer := &Consumer{
+		Name:                CameraEvent,
+		lc:                  client.lc,
+		manager:             client.baseNotificationManager,
+		onvifClient:         client,
+		subscriptionRequest: &SubscriptionRequest{},
+		SubscriptionAddress: "http://192.168.1.10/onvif/Subscription?Idx=1",
+		terminationDeadline: time.Now().Add(time.Hour),
+		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+		resubscribeRequests: make(chan chan errors.EdgeX, 1),
+	}
+	mockDevice.On("SendSoap", "http://192.168.1.10/onvif/Subscription?Idx=1", mock.Anything).Return(nil, fmt.Errorf("unavailable")).Once()
+	client.baseNotificationManager.addConsumer(consumer)
+	go consumer.StartRenewLoop()
+
+	driver.removeOnvifClient(testDeviceName)
+	mockDevice.AssertExpectations(t)
+	assert.Empty(t, client.baseNotificationManager.listConsumers())
+}
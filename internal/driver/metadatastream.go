@@ -0,0 +1,702 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+	"github.com/IOTechSystems/onvif/media"
+	xsdOnvif "github.com/IOTechSystems/onvif/xsd/onvif"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	GetMetadataStream = "GetMetadataStream"
+	SetMetadataStream = "SetMetadataStream"
+	// AnalyticsFrame is the getFunction of the resource which receives the analytics frames of the metadata stream
+	AnalyticsFrame = "AnalyticsFrame"
+	// MetadataStream is the device property which declares the metadata stream that should always be open for the
+	// camera. The value is the body of a SetMetadataStream request, and can be set in a device definition or in the
+	// discoveredDevice of a provision watcher.
+	MetadataStream = "MetadataStream"
+	// RequestedMetadataStream is the protocol property which holds the json encoded request of the last
+	// SetMetadataStream command, which overrides the MetadataStream device property
+	RequestedMetadataStream = "RequestedMetadataStream"
+
+	// metadataStreamMaxDocumentSize limits the size of a metadata document reassembled from the RTP packets
+	metadataStreamMaxDocumentSize = 1 << 20
+)
+
+// MetadataStreamRequest is the request body of the SetMetadataStream command
+type MetadataStreamRequest struct {
+	// Enabled opens the metadata stream when true, and closes it when false
+	Enabled bool
+	// ProfileToken is the media profile whose MetadataConfiguration is streamed. The first media profile with a
+	// MetadataConfiguration is used if it is not specified.
+	ProfileToken string `json:",omitempty"`
+}
+
+// MetadataStreamStatus is the response of the GetMetadataStream command
+type MetadataStreamStatus struct {
+	Enabled      bool
+	ProfileToken string `json:",omitempty"`
+	// StreamUri is the RTSP uri of the metadata stream
+	StreamUri string `json:",omitempty"`
+	// SubscriptionHealth counts the metadata documents received from the stream as messages
+	SubscriptionHealth
+}
+
+// AnalyticsReading is the normalized form of a tt:Frame of the metadata stream, which is sent to north bound as an
+// Object reading of the AnalyticsFrame resource
+type AnalyticsReading struct {
+	// UtcTime is the RFC 3339 time of the video frame which was analyzed
+	UtcTime string `json:",omitempty"`
+	// Source is the token of the video source of the frame, if the camera reports it
+	Source  string            `json:",omitempty"`
+	Objects []AnalyticsObject `json:",omitempty"`
+}
+
+// AnalyticsObject is an object detected in an analytics frame
+type AnalyticsObject struct {
+	ObjectId string
+	// BoundingBox is the box of the object in the normalized coordinates of the frame, from -1 to 1
+	BoundingBox *BoundingBox `json:",omitempty"`
+	// Classes are the candidate classes of the object, such as Human or Vehicle
+	Classes []ObjectClass `json:",omitempty"`
+}
+
+type BoundingBox struct {
+	Left   float64
+	Top    float64
+	Right  float64
+	Bottom float64
+}
+
+type ObjectClass struct {
+	Type       string
+	Likelihood float64 `json:",omitempty"`
+}
+
+// metadataStreamElement is the minimal representation of a tt:MetadataStream document. The analytics frames are
+// parsed by the device service, since the onvif library does not describe the metadata stream.
+type metadataStreamElement struct {
+	VideoAnalytics []struct {
+		Frame []analyticsFrameElement
+	}
+	Event []struct {
+		NotificationMessage []notificationMessageElement
+	}
+}
+
+type analyticsFrameElement struct {
+	UtcTime string `xml:"UtcTime,attr"`
+	Source  string `xml:"Source,attr"`
+	Object  []struct {
+		ObjectId   string `xml:"ObjectId,attr"`
+		Appearance struct {
+			Shape struct {
+				BoundingBox *struct {
+					Left   float64 `xml:"left,attr"`
+					Top    float64 `xml:"top,attr"`
+					Right  float64 `xml:"right,attr"`
+					Bottom float64 `xml:"bottom,attr"`
+				}
+			}
+			Class struct {
+				// ClassCandidate is used by the ver10 schema, and Type by the later versions
+				ClassCandidate []struct {
+					Type       string
+					Likelihood float64
+				}
+				Type []struct {
+					Value      string  `xml:",chardata"`
+					Likelihood float64 `xml:"Likelihood,attr"`
+				}
+			}
+		}
+	}
+}
+
+// parseMetadataStream returns the analytics frames and the event readings of a tt:MetadataStream document
+func parseMetadataStream(data []byte) ([]AnalyticsReading, []EventReading, errors.EdgeX) {
+	document := metadataStreamElement{}
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the metadata stream document", err)
+	}
+
+	var frames []AnalyticsReading
+	for _, analytics := range document.VideoAnalytics {
+		for _, frame := range analytics.Frame {
+			frames = append(frames, newAnalyticsReading(frame))
+		}
+	}
+	var readings []EventReading
+	for _, event := range document.Event {
+		for _, message := range event.NotificationMessage {
+			readings = append(readings, newEventReading(message))
+		}
+	}
+	return frames, readings, nil
+}
+
+// newAnalyticsReading returns the normalized analytics frame of a tt:Frame
+func newAnalyticsReading(frame analyticsFrameElement) AnalyticsReading {
+	result := AnalyticsReading{
+		UtcTime: normalizeUtcTime(frame.UtcTime),
+		Source:  strings.TrimSpace(frame.Source),
+	}
+	for _, object := range frame.Object {
+		analyticsObject := AnalyticsObject{ObjectId: strings.TrimSpace(object.ObjectId)}
+		if box := object.Appearance.Shape.BoundingBox; box != nil {
+			analyticsObject.BoundingBox = &BoundingBox{Left: box.Left, Top: box.Top, Right: box.Right, Bottom: box.Bottom}
+		}
+		for _, candidate := range object.Appearance.Class.ClassCandidate {
+			analyticsObject.Classes = append(analyticsObject.Classes, ObjectClass{Type: strings.TrimSpace(candidate.Type), Likelihood: candidate.Likelihood})
+		}
+		for _, classType := range object.Appearance.Class.Type {
+			analyticsObject.Classes = append(analyticsObject.Classes, ObjectClass{Type: strings.TrimSpace(classType.Value), Likelihood: classType.Likelihood})
+		}
+		result.Objects = append(result.Objects, analyticsObject)
+	}
+	return result
+}
+
+// metadataAssembler reassembles the metadata documents, which are split across RTP packets and end with the packet
+// which has the marker bit. A document is discarded if one of its packets was lost.
+type metadataAssembler struct {
+	buffer   []byte
+	sequence uint16
+	started  bool
+	// broken indicates the current document lost a packet or exceeds the maximum size
+	broken bool
+}
+
+// push adds the payload of the packet to the current document, and returns the document when it is complete
+func (assembler *metadataAssembler) push(packet rtpPacket) ([]byte, bool) {
+	if assembler.started && packet.sequence != assembler.sequence+1 {
+		assembler.broken = true
+	}
+	assembler.started = true
+	assembler.sequence = packet.sequence
+	if !assembler.broken {
+		assembler.buffer = append(assembler.buffer, packet.payload...)
+		assembler.broken = len(assembler.buffer) > metadataStreamMaxDocumentSize
+	}
+	if !packet.marker {
+		return nil, false
+	}
+
+	document, complete := assembler.buffer, !assembler.broken && len(assembler.buffer) > 0
+	assembler.buffer = nil
+	assembler.broken = false
+	return document, complete
+}
+
+// metadataStream reads the RTSP metadata stream of a media profile, and publishes its analytics frames and events.
+// The stream is opened again with exponential backoff if it fails, and it is paused during the maintenance window
+// of the camera or while the device is locked.
+type metadataStream struct {
+	onvifClient *OnvifClient
+	request     MetadataStreamRequest
+	health      subscriptionHealth
+
+	mu sync.Mutex
+	// client is the RTSP client of the open stream, which is closed to stop reading it
+	client    *rtspClient
+	streamUri string
+	// profileToken is the media profile which is streamed
+	profileToken string
+
+	// frameSources are the analytics frames published for each video source, which limit the published frames
+	frameSources map[string]frameSource
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newMetadataStream(onvifClient *OnvifClient, request MetadataStreamRequest) *metadataStream {
+	return &metadataStream{
+		onvifClient:  onvifClient,
+		request:      request,
+		frameSources: make(map[string]frameSource),
+		stopped:      make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// run reads the stream until it is stopped
+func (stream *metadataStream) run() {
+	defer close(stream.done)
+	onvifClient := stream.onvifClient
+	onvifClient.lc.Infof("Opening the metadata stream of device %s", onvifClient.deviceName())
+
+	attempt := 0
+	for {
+		if onvifClient.inMaintenance() || onvifClient.locked.Load() {
+			stream.health.setState(SubscriptionPaused)
+			if stream.wait(maintenancePollInterval) {
+				return
+			}
+			continue
+		}
+
+		played, edgexErr := stream.play()
+		if stream.isStopped() {
+			return
+		}
+		if played {
+			attempt = 0
+		}
+		if edgexErr == nil {
+			continue
+		}
+
+		stream.health.failed(edgexErr)
+		attempt++
+		delay := retryBackoff(attempt)
+		onvifClient.lc.Warnf("The metadata stream of device %s failed, retrying in %v, %v", onvifClient.deviceName(), delay, edgexErr)
+		if stream.wait(delay) {
+			return
+		}
+	}
+}
+
+// play opens the stream and publishes its documents until it fails, the stream is stopped or the camera is paused.
+// It returns true if the stream was played.
+func (stream *metadataStream) play() (bool, errors.EdgeX) {
+	onvifClient := stream.onvifClient
+	uri, profileToken, edgexErr := onvifClient.metadataStreamUri(stream.request.ProfileToken)
+	if edgexErr != nil {
+		return false, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	stream.mu.Lock()
+	stream.streamUri = uri
+	stream.profileToken = profileToken
+	stream.mu.Unlock()
+
+	onvifClient.driver.configMu.RLock()
+	timeout := time.Duration(onvifClient.driver.config.AppCustom.RequestTimeout) * time.Second
+	onvifClient.driver.configMu.RUnlock()
+	params := onvifClient.device().GetDeviceParams()
+
+	client, err := dialRTSP(uri, params.Username, params.Password, timeout)
+	if err != nil {
+		return false, errors.NewCommonEdgeX(errors.KindServiceUnavailable, fmt.Sprintf("failed to connect to the metadata stream %s", uri), err)
+	}
+	if !stream.setClient(client) {
+		// the stream was stopped meanwhile
+		client.close()
+		return false, nil
+	}
+	defer stream.setClient(nil)
+	defer client.close()
+
+	if err = client.playTrack(onvifMetadataEncoding); err != nil {
+		return false, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to play the metadata stream %s", uri), err)
+	}
+	stream.health.setState(SubscriptionActive)
+	onvifClient.lc.Infof("Playing the metadata stream of media profile '%s' of device %s", profileToken, onvifClient.deviceName())
+
+	// the session is kept alive by a separate goroutine, since the camera may not send any packet for a long time
+	interval := client.keepAliveInterval()
+	keepAliveDone := make(chan struct{})
+	defer close(keepAliveDone)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-keepAliveDone:
+				return
+			case <-ticker.C:
+				if err := client.keepAlive(); err != nil {
+					onvifClient.lc.Debugf("Failed to keep the metadata stream of device %s alive, %v", onvifClient.deviceName(), err)
+				}
+			}
+		}
+	}()
+
+	assembler := metadataAssembler{}
+	for {
+		if onvifClient.inMaintenance() || onvifClient.locked.Load() {
+			return true, nil
+		}
+		// the responses of the keepalive requests are received at least twice per idle timeout
+		packet, err := client.readPacket(2 * interval)
+		if err != nil {
+			// the connection is closed by pause when the camera enters its maintenance window or is locked
+			if stream.isStopped() || onvifClient.inMaintenance() || onvifClient.locked.Load() {
+				return true, nil
+			}
+			return true, errors.NewCommonEdgeX(errors.KindServiceUnavailable, fmt.Sprintf("failed to read the metadata stream %s", uri), err)
+		}
+		if document, ok := assembler.push(packet); ok {
+			stream.publish(document)
+		}
+	}
+}
+
+// publish sends the event readings and the analytics frames of a metadata document to north bound
+func (stream *metadataStream) publish(document []byte) {
+	onvifClient := stream.onvifClient
+	frames, readings, edgexErr := parseMetadataStream(document)
+	if edgexErr != nil {
+		onvifClient.lc.Debugf("Ignoring an invalid metadata document of device %s, %v", onvifClient.deviceName(), edgexErr)
+		return
+	}
+	stream.health.succeeded(1, time.Now())
+
+	if len(readings) > 0 {
+		route := eventRoute{resourceName: onvifClient.CameraEventResource.Name, byTopic: true}
+		onvifClient.driver.publishEventReadings(onvifClient.deviceName(), route, readings)
+	}
+	if frames = stream.changedFrames(frames, onvifClient.driver.analyticsFrameInterval(), time.Now()); len(frames) > 0 {
+		if edgexErr = onvifClient.publishAnalyticsFrames(frames); edgexErr != nil {
+			onvifClient.lc.Warnf("Failed to publish the analytics frames of device %s, %v", onvifClient.deviceName(), edgexErr)
+		}
+	}
+}
+
+// frameSource holds the analytics frames published for a video source
+type frameSource struct {
+	// objects indicates the last published frame had objects
+	objects bool
+	// published is the time the last frame with objects was published
+	published time.Time
+}
+
+// changedFrames returns the frames which have objects, at most one per interval for each video source, and the first
+// frame without objects after a published frame with objects. The cameras send a frame for every analyzed video
+// frame, so the other frames would flood north bound.
+func (stream *metadataStream) changedFrames(frames []AnalyticsReading, interval time.Duration, now time.Time) []AnalyticsReading {
+	var result []AnalyticsReading
+	for _, frame := range frames {
+		source := stream.frameSources[frame.Source]
+		if len(frame.Objects) == 0 {
+			// the frame without objects indicates the objects are gone, so it is not limited by the interval
+			if !source.objects {
+				continue
+			}
+			source.objects = false
+		} else {
+			if !source.published.IsZero() && now.Sub(source.published) < interval {
+				continue
+			}
+			source.objects = true
+			source.published = now
+		}
+		stream.frameSources[frame.Source] = source
+		result = append(result, frame)
+	}
+	return result
+}
+
+// setClient sets the RTSP client of the open stream, and returns false if the stream is stopped
+func (stream *metadataStream) setClient(client *rtspClient) bool {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if client != nil && stream.isStopped() {
+		return false
+	}
+	stream.client = client
+	return true
+}
+
+func (stream *metadataStream) isStopped() bool {
+	select {
+	case <-stream.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait pauses the stream for the specified duration, and returns true if the stream was stopped meanwhile
+func (stream *metadataStream) wait(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-stream.stopped:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// pause closes the connection of the stream without stopping it, so that the stream checks the maintenance window
+// and the admin state of the camera without waiting for the next packet
+func (stream *metadataStream) pause() {
+	stream.mu.Lock()
+	client := stream.client
+	stream.mu.Unlock()
+	if client != nil {
+		client.close()
+	}
+}
+
+// stop closes the stream and waits for it to exit
+func (stream *metadataStream) stop() {
+	stream.stopOnce.Do(func() {
+		stream.mu.Lock()
+		close(stream.stopped)
+		client := stream.client
+		stream.mu.Unlock()
+		if client != nil {
+			// closing the connection unblocks the pending read of the stream
+			client.close()
+		}
+	})
+	<-stream.done
+}
+
+func (stream *metadataStream) status() MetadataStreamStatus {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	profileToken := stream.profileToken
+	if profileToken == "" {
+		profileToken = stream.request.ProfileToken
+	}
+	return MetadataStreamStatus{
+		Enabled:            true,
+		ProfileToken:       profileToken,
+		StreamUri:          stream.streamUri,
+		SubscriptionHealth: stream.health.snapshot(),
+	}
+}
+
+// metadataProfileToken returns the token of the media profile whose metadata is streamed, which is either the
+// requested profile or the first profile with a MetadataConfiguration
+func metadataProfileToken(profiles []xsdOnvif.Profile, requested string) (string, errors.EdgeX) {
+	for _, profile := range profiles {
+		if requested != "" && string(profile.Token) != requested {
+			continue
+		}
+		if profile.MetadataConfiguration == nil {
+			if requested == "" {
+				continue
+			}
+			return "", errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("the media profile '%s' has no MetadataConfiguration", requested), nil)
+		}
+		return string(profile.Token), nil
+	}
+	if requested != "" {
+		return "", errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("the media profile '%s' is not found", requested), nil)
+	}
+	return "", errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, "the camera has no media profile with a MetadataConfiguration", nil)
+}
+
+// metadataStreamUri returns the RTSP uri of the metadata stream, and the token of its media profile
+func (onvifClient *OnvifClient) metadataStreamUri(requestedToken string) (string, string, errors.EdgeX) {
+	profiles, edgexErr := onvifClient.loadMediaProfiles()
+	if edgexErr != nil {
+		return "", "", errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	profileToken, edgexErr := metadataProfileToken(profiles, requestedToken)
+	if edgexErr != nil {
+		// the profiles may have been changed, so they are requested again for the next attempt
+		onvifClient.mediaProfiles.set(nil)
+		return "", "", errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+
+	streamType := xsdOnvif.StreamType("RTP-Unicast")
+	protocol := xsdOnvif.TransportProtocol("RTSP")
+	token := xsdOnvif.ReferenceToken(profileToken)
+	data, _ := json.Marshal(media.GetStreamUri{
+		StreamSetup:  &xsdOnvif.StreamSetup{Stream: &streamType, Transport: &xsdOnvif.Transport{Protocol: &protocol}},
+		ProfileToken: &token,
+	})
+	respContent, edgexErr := onvifClient.callOnvifFunction(onvif.MediaWebService, onvif.GetStreamUri, data)
+	if edgexErr != nil {
+		return "", "", errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	response, ok := respContent.(*media.GetStreamUriResponse)
+	if !ok {
+		return "", "", errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid GetStreamUriResponse of type %T for the camera %s", respContent, onvifClient.deviceName()), nil)
+	}
+	return strings.TrimSpace(string(response.MediaUri.Uri)), profileToken, nil
+}
+
+func (d *Driver) analyticsFrameInterval() time.Duration {
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return time.Duration(d.config.AppCustom.AnalyticsFrameIntervalMillis) * time.Millisecond
+}
+
+// publishAnalyticsFrames sends the frames as Object readings of the AnalyticsFrame resource of the device profile
+func (onvifClient *OnvifClient) publishAnalyticsFrames(frames []AnalyticsReading) errors.EdgeX {
+	resource, edgexErr := onvifClient.driver.getDeviceResourceByGetFunction(onvifClient.deviceName(), AnalyticsFrame)
+	if edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("the profile has no %s resource", AnalyticsFrame), edgexErr)
+	}
+
+	// the frames are stamped with the time of the camera, corrected by the offset of its clock like the events
+	timestamp := onvifClient.newEventTimestamper(time.Now(), onvifClient.driver.eventTimeTolerance())
+	asyncValues := make([]*sdkModel.AsyncValues, 0, len(frames))
+	for _, frame := range frames {
+		cv, err := sdkModel.NewCommandValue(resource.Name, common.ValueTypeObject, frame)
+		if err != nil {
+			return errors.NewCommonEdgeX(errors.KindServerError, "failed to create commandValue for the analytics frame", err)
+		}
+		commandValues := []*sdkModel.CommandValue{cv}
+		timestamp(EventReading{Topic: AnalyticsFrame, UtcTime: frame.UtcTime}, commandValues)
+		asyncValues = append(asyncValues, &sdkModel.AsyncValues{
+			DeviceName:    onvifClient.deviceName(),
+			CommandValues: commandValues,
+		})
+	}
+	onvifClient.driver.sendAsyncValues(onvifClient.deviceName(), asyncValues)
+	return nil
+}
+
+// startMetadataStream opens the metadata stream of the camera, replacing the open stream if it streams another
+// media profile
+func (onvifClient *OnvifClient) startMetadataStream(request MetadataStreamRequest) {
+	onvifClient.metadataStreamMu.Lock()
+	defer onvifClient.metadataStreamMu.Unlock()
+	if stream := onvifClient.metadataStream; stream != nil {
+		if stream.request.ProfileToken == request.ProfileToken {
+			return
+		}
+		stream.stop()
+	}
+	stream := newMetadataStream(onvifClient, request)
+	onvifClient.metadataStream = stream
+	go stream.run()
+}
+
+// stopMetadataStream closes the metadata stream of the camera if it is open
+func (onvifClient *OnvifClient) stopMetadataStream() {
+	onvifClient.metadataStreamMu.Lock()
+	defer onvifClient.metadataStreamMu.Unlock()
+	if onvifClient.metadataStream != nil {
+		onvifClient.metadataStream.stop()
+		onvifClient.metadataStream = nil
+		onvifClient.lc.Infof("Closed the metadata stream of device %s", onvifClient.deviceName())
+	}
+}
+
+// pauseMetadataStream closes the connection of the metadata stream of the camera, which is opened again once the
+// camera leaves its maintenance window and is unlocked
+func (onvifClient *OnvifClient) pauseMetadataStream() {
+	onvifClient.metadataStreamMu.Lock()
+	defer onvifClient.metadataStreamMu.Unlock()
+	if onvifClient.metadataStream != nil {
+		onvifClient.metadataStream.pause()
+	}
+}
+
+// metadataStreamStatus returns the status of the metadata stream of the camera
+func (onvifClient *OnvifClient) metadataStreamStatus() MetadataStreamStatus {
+	onvifClient.metadataStreamMu.Lock()
+	defer onvifClient.metadataStreamMu.Unlock()
+	if onvifClient.metadataStream == nil {
+		return MetadataStreamStatus{}
+	}
+	return onvifClient.metadataStream.status()
+}
+
+// setMetadataStream handles the SetMetadataStream command
+func (onvifClient *OnvifClient) setMetadataStream(data []byte) errors.EdgeX {
+	var request MetadataStreamRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+	}
+	if !request.Enabled {
+		onvifClient.stopMetadataStream()
+		return onvifClient.driver.storeMetadataStreamRequest(onvifClient.deviceName(), request)
+	}
+
+	// the profile is validated before the stream is opened, the stream retries other errors in the background
+	profiles, edgexErr := onvifClient.loadMediaProfiles()
+	if edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	if _, edgexErr = metadataProfileToken(profiles, request.ProfileToken); edgexErr != nil {
+		onvifClient.mediaProfiles.set(nil)
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	onvifClient.startMetadataStream(request)
+	return onvifClient.driver.storeMetadataStreamRequest(onvifClient.deviceName(), request)
+}
+
+// storeMetadataStreamRequest writes the request of the SetMetadataStream command to the protocol properties of the
+// device, so that reconcileMetadataStream does not override it
+func (d *Driver) storeMetadataStreamRequest(deviceName string, request MetadataStreamRequest) errors.EdgeX {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the metadata stream request", err)
+	}
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
+	}
+	device.Protocols[OnvifProtocol][RequestedMetadataStream] = string(data)
+	if err = d.patchDeviceProtocols(device.Name, device.Protocols); err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", device.Name), err)
+	}
+	return nil
+}
+
+// parseMetadataStreamRequest returns the request of the MetadataStream device property, which is either an object or
+// its json string
+func parseMetadataStreamRequest(value any) (MetadataStreamRequest, errors.EdgeX) {
+	var request MetadataStreamRequest
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return request, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid %s device property", MetadataStream), err)
+		}
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return request, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid %s device property", MetadataStream), err)
+	}
+	return request, nil
+}
+
+// reconcileMetadataStream opens the metadata stream declared by the device properties if it is not open. The request
+// of the last SetMetadataStream command takes precedence over the device properties. It is called on every
+// successful status check, like the default subscriptions.
+func (d *Driver) reconcileMetadataStream(deviceName string) {
+	onvifClient, ok := d.getOnvifClient(deviceName)
+	if !ok || onvifClient.pullPointManager == nil {
+		return
+	}
+	device, err := d.sdkService.GetDeviceByName(deviceName)
+	if err != nil {
+		d.lc.Debugf("Unable to get device %s from cache while reconciling its metadata stream: %s", deviceName, err.Error())
+		return
+	}
+	var value any = protocolValue(d.withProtocolWrites(device).Protocols[OnvifProtocol], RequestedMetadataStream)
+	if value == "" {
+		if value, ok = device.Properties[MetadataStream]; !ok || value == nil {
+			return
+		}
+	}
+	request, edgexErr := parseMetadataStreamRequest(value)
+	if edgexErr != nil {
+		d.lc.Warnf("Unable to reconcile the metadata stream of device %s, %v", deviceName, edgexErr)
+		return
+	}
+	if request.Enabled {
+		onvifClient.startMetadataStream(request)
+	}
+}
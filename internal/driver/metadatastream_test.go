@@ -0,0 +1,324 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testMetadataDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tns1="http://www.onvif.org/ver10/topics">
+  <tt:VideoAnalytics>
+    <tt:Frame UtcTime="2023-09-21T08:00:00.100Z" Source="VideoSource_1">
+      <tt:Object ObjectId="12">
+        <tt:Appearance>
+          <tt:Shape>
+            <tt:BoundingBox left="-0.5" top="0.5" right="0.25" bottom="-0.25"/>
+            <tt:CenterOfGravity x="-0.125" y="0.125"/>
+          </tt:Shape>
+          <tt:Class>
+            <tt:ClassCandidate>
+              <tt:Type>Human</tt:Type>
+              <tt:Likelihood>0.8</tt:Likelihood>
+            </tt:ClassCandidate>
+          </tt:Class>
+        </tt:Appearance>
+      </tt:Object>
+      <tt:Object ObjectId="13">
+        <tt:Appearance>
+          <tt:Class>
+            <tt:Type Likelihood="0.9">Vehicle</tt:Type>
+          </tt:Class>
+        </tt:Appearance>
+      </tt:Object>
+    </tt:Frame>
+  </tt:VideoAnalytics>
+  <tt:Event>
+    <wsnt:NotificationMessage>
+      <wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:RuleEngine/FieldDetector/ObjectsInside</wsnt:Topic>
+      <wsnt:Message>
+        <tt:Message UtcTime="2023-09-21T08:00:00.100Z" PropertyOperation="Changed">
+          <tt:Source>
+            <tt:SimpleItem Name="Rule" Value="Field1"/>
+          </tt:Source>
+          <tt:Data>
+            <tt:SimpleItem Name="IsInside" Value="true"/>
+          </tt:Data>
+        </tt:Message>
+      </wsnt:Message>
+    </wsnt:NotificationMessage>
+  </tt:Event>
+</tt:MetadataStream>`
+	testEmptyMetadataDocument = `<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema"><tt:VideoAnalytics><tt:Frame UtcTime="2023-09-21T08:00:00.200Z" Source="VideoSource_1"/></tt:VideoAnalytics></tt:MetadataStream>`
+
+	testMetadataProfilesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <env:Body>
+    <trt:GetProfilesResponse>
+      <trt:Profiles token="profile_1" fixed="true">
+        <tt:Name>Profile1</tt:Name>
+      </trt:Profiles>
+      <trt:Profiles token="profile_2" fixed="true">
+        <tt:Name>Profile2</tt:Name>
+        <tt:MetadataConfiguration token="MetadataConfig_1">
+          <tt:Name>MetadataConfig1</tt:Name>
+          <tt:Analytics>true</tt:Analytics>
+        </tt:MetadataConfiguration>
+      </trt:Profiles>
+    </trt:GetProfilesResponse>
+  </env:Body>
+</env:Envelope>`
+	testGetStreamUriResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <env:Body>
+    <trt:GetStreamUriResponse>
+      <trt:MediaUri>
+        <tt:Uri>%s</tt:Uri>
+      </trt:MediaUri>
+    </trt:GetStreamUriResponse>
+  </env:Body>
+</env:Envelope>`
+)
+
+func TestParseMetadataStream(t *testing.T) {
+	frames, readings, err := parseMetadataStream([]byte(testMetadataDocument))
+	require.NoError(t, err)
+	assert.Equal(t, []AnalyticsReading{{
+		UtcTime: "2023-09-21T08:00:00.1Z",
+		Source:  "VideoSource_1",
+		Objects: []AnalyticsObject{
+			{
+				ObjectId:    "12",
+				BoundingBox: &BoundingBox{Left: -0.5, Top: 0.5, Right: 0.25, Bottom: -0.25},
+				Classes:     []ObjectClass{{Type: "Human", Likelihood: 0.8}},
+			},
+			{
+				ObjectId: "13",
+				Classes:  []ObjectClass{{Type: "Vehicle", Likelihood: 0.9}},
+			},
+		},
+	}}, frames)
+	assert.Equal(t, []EventReading{{
+		Topic:             "tns1:RuleEngine/FieldDetector/ObjectsInside",
+		UtcTime:           "2023-09-21T08:00:00.1Z",
+		PropertyOperation: "Changed",
+		Source:            map[string]string{"Rule": "Field1"},
+		Data:              map[string]string{"IsInside": "true"},
+	}}, readings)
+
+	_, _, err = parseMetadataStream([]byte("<tt:MetadataStream"))
+	require.Error(t, err)
+}
+
+func TestMetadataAssembler(t *testing.T) {
+	assembler := metadataAssembler{}
+	_, ok := assembler.push(rtpPacket{sequence: 1, payload: []byte("<a>")})
+	assert.False(t, ok)
+	document, ok := assembler.push(rtpPacket{sequence: 2, marker: true, payload: []byte("</a>")})
+	require.True(t, ok)
+	assert.Equal(t, "<a></a>", string(document))
+
+	// the document which lost a packet is discarded, and the next one is complete again
+	assembler.push(rtpPacket{sequence: 3, payload: []byte("<b>")})
+	_, ok = assembler.push(rtpPacket{sequence: 5, marker: true, payload: []byte("</b>")})
+	assert.False(t, ok)
+	document, ok = assembler.push(rtpPacket{sequence: 6, marker: true, payload: []byte("<c/>")})
+	require.True(t, ok)
+	assert.Equal(t, "<c/>", string(document))
+
+	// the sequence number wraps around
+	assembler = metadataAssembler{}
+	assembler.push(rtpPacket{sequence: 65535, payload: []byte("<d>")})
+	document, ok = assembler.push(rtpPacket{sequence: 0, marker: true, payload: []byte("</d>")})
+	require.True(t, ok)
+	assert.Equal(t, "<d></d>", string(document))
+}
+
+func TestMetadataStream_changedFrames(t *testing.T) {
+	stream := newMetadataStream(nil, MetadataStreamRequest{})
+	object := []AnalyticsObject{{ObjectId: "1"}}
+	frames := []AnalyticsReading{
+		{UtcTime: "1", Source: "VideoSource_1"},
+		{UtcTime: "2", Source: "VideoSource_1", Objects: object},
+		{UtcTime: "3", Source: "VideoSource_2"},
+		{UtcTime: "4", Source: "VideoSource_1"},
+		{UtcTime: "5", Source: "VideoSource_1"},
+	}
+
+	var published []string
+	for _, frame := range stream.changedFrames(frames, 0, time.Now()) {
+		published = append(published, frame.UtcTime)
+	}
+	assert.Equal(t, []string{"2", "4"}, published, "only the first frame without objects of a source is published")
+
+	// the frames with objects are limited by the interval, but the frame which indicates that they are gone is not
+	stream = newMetadataStream(nil, MetadataStreamRequest{})
+	start := time.Now()
+	published = nil
+	for i, frame := range []AnalyticsReading{
+		{UtcTime: "1", Source: "VideoSource_1", Objects: object},
+		{UtcTime: "2", Source: "VideoSource_1", Objects: object},
+		{UtcTime: "3", Source: "VideoSource_2", Objects: object},
+		{UtcTime: "4", Source: "VideoSource_1"},
+		{UtcTime: "5", Source: "VideoSource_1", Objects: object},
+		{UtcTime: "6", Source: "VideoSource_1"},
+		{UtcTime: "7", Source: "VideoSource_1", Objects: object},
+	} {
+		now := start.Add(time.Duration(i) * 200 * time.Millisecond)
+		for _, frame := range stream.changedFrames([]AnalyticsReading{frame}, time.Second, now) {
+			published = append(published, frame.UtcTime)
+		}
+	}
+	assert.Equal(t, []string{"1", "3", "4", "7"}, published)
+}
+
+func TestMetadataProfileToken(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	mockDevice.On("GetEndpointByRequestStruct", mock.Anything).Return("http://192.168.1.10/onvif/media_service", nil)
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "GetProfiles") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testMetadataProfilesResponse))}, nil).Once()
+	profiles, err := client.loadMediaProfiles()
+	require.NoError(t, err)
+
+	token, err := metadataProfileToken(profiles, "")
+	require.NoError(t, err)
+	assert.Equal(t, "profile_2", token)
+
+	_, err = metadataProfileToken(profiles, "profile_1")
+	require.Error(t, err)
+	assert.Equal(t, errors.KindContractInvalid, errors.Kind(err))
+
+	_, err = metadataProfileToken(profiles, "profile_3")
+	require.Error(t, err)
+	assert.Equal(t, errors.KindEntityDoesNotExist, errors.Kind(err))
+}
+
+func TestOnvifClient_metadataStream(t *testing.T) {
+	server := newTestRTSPServer(t, "admin", "password", testMetadataDocument, testEmptyMetadataDocument, testEmptyMetadataDocument)
+
+	driver, mockService := createDriverWithMockService()
+	driver.config.AppCustom.RequestTimeout = 2
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.CameraEventResource = models.DeviceResource{Name: CameraEvent}
+	driver.onvifClients[testDeviceName] = client
+
+	device := createTestDevice()
+	device.ProfileName = testProfileName
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	mockService.On("GetProfileByName", testProfileName).Return(models.DeviceProfile{
+		Name: testProfileName,
+		DeviceResources: []models.DeviceResource{
+			{Name: CameraEvent, Attributes: map[string]any{Service: EdgeXWebService, GetFunction: CameraEvent}},
+			{Name: "Analytics", Attributes: map[string]any{Service: EdgeXWebService, GetFunction: AnalyticsFrame}},
+		},
+	}, nil)
+	var requested []string
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		requested = append(requested, protocolValue(models.ProtocolProperties(update.Protocols[OnvifProtocol]), RequestedMetadataStream))
+	}).Return(nil)
+	asyncCh := make(chan *sdkModel.AsyncValues, 10)
+	mockService.On("AsyncValuesChannel").Return(asyncCh)
+
+	mockDevice.On("GetDeviceParams").Return(onvif.DeviceParams{Xaddr: "192.168.1.10:80", Username: "admin", Password: "password"})
+	mockDevice.On("GetEndpointByRequestStruct", mock.Anything).Return("http://192.168.1.10/onvif/media_service", nil)
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool { return strings.Contains(body, "GetProfiles") })).
+		Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(testMetadataProfilesResponse))}, nil).Once()
+	mockDevice.On("SendSoap", mock.Anything, mock.MatchedBy(func(body string) bool {
+		return strings.Contains(body, "GetStreamUri") && strings.Contains(body, "profile_2") && strings.Contains(body, "RTP-Unicast")
+	})).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(fmt.Sprintf(testGetStreamUriResponse, server.uri())))}, nil).Once()
+
+	_, err := client.callCustomFunction("MetadataStream", SetMetadataStream, nil, []byte(`{"Enabled": true}`))
+	require.NoError(t, err)
+	defer client.stopMetadataStream()
+
+	// the event of the document, its analytics frame and the first frame without objects are published
+	var published []*sdkModel.AsyncValues
+	for len(published) < 3 {
+		select {
+		case values := <-asyncCh:
+			published = append(published, values)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the metadata stream was not published")
+		}
+	}
+	require.Len(t, published[0].CommandValues, 1)
+	assert.Equal(t, CameraEvent, published[0].CommandValues[0].DeviceResourceName)
+	assert.Equal(t, "tns1:RuleEngine/FieldDetector/ObjectsInside", published[0].CommandValues[0].Value.(EventReading).Topic)
+
+	require.Len(t, published[1].CommandValues, 1)
+	cv := published[1].CommandValues[0]
+	assert.Equal(t, "Analytics", cv.DeviceResourceName)
+	assert.Equal(t, common.ValueTypeObject, cv.Type)
+	frame := cv.Value.(AnalyticsReading)
+	require.Len(t, frame.Objects, 2)
+	assert.Equal(t, "12", frame.Objects[0].ObjectId)
+	assert.Equal(t, time.Date(2023, 9, 21, 8, 0, 0, 100000000, time.UTC).UnixNano(), cv.Origin)
+
+	assert.Empty(t, published[2].CommandValues[0].Value.(AnalyticsReading).Objects)
+	select {
+	case values := <-asyncCh:
+		assert.Failf(t, "the repeated frame without objects should not be published", "%v", values.CommandValues[0].Value)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	status := client.metadataStreamStatus()
+	assert.True(t, status.Enabled)
+	assert.Equal(t, "profile_2", status.ProfileToken)
+	assert.Equal(t, server.uri(), status.StreamUri)
+	assert.Equal(t, SubscriptionActive, status.State)
+	assert.Equal(t, uint64(3), status.MessageCount)
+
+	// the stream is paused without waiting for the next packet when the device is locked
+	client.locked.Store(true)
+	client.pauseMetadataStream()
+	assert.Eventually(t, func() bool {
+		return client.metadataStreamStatus().State == SubscriptionPaused
+	}, 5*time.Second, 10*time.Millisecond, "the stream should be paused")
+
+	_, err = client.callCustomFunction("MetadataStream", SetMetadataStream, nil, []byte(`{"Enabled": false}`))
+	require.NoError(t, err)
+	assert.False(t, client.metadataStreamStatus().Enabled)
+	assert.Equal(t, []string{`{"Enabled":true}`, `{"Enabled":false}`}, requested, "the requests are stored for reconcileMetadataStream")
+	assert.Eventually(t, func() bool {
+		requested := server.requested()
+		return requested[len(requested)-1] == "TEARDOWN "+server.uri()
+	}, time.Second, 10*time.Millisecond, "the session should be torn down")
+	mockDevice.AssertExpectations(t)
+}
+
+func TestDriver_reconcileMetadataStream(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	driver.onvifClients[testDeviceName] = client
+
+	// the request of the SetMetadataStream command overrides the device property
+	device := createTestDevice()
+	device.Properties = map[string]any{MetadataStream: map[string]any{"Enabled": true}}
+	device.Protocols[OnvifProtocol][RequestedMetadataStream] = `{"Enabled":false}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	driver.reconcileMetadataStream(testDeviceName)
+	assert.False(t, client.metadataStreamStatus().Enabled)
+}
@@ -0,0 +1,238 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// notificationListenerReadTimeout limits the time a camera may take to send a notification to the listener
+	notificationListenerReadTimeout = 10 * time.Second
+	// notificationListenerShutdownTimeout is the time to wait for in-flight notifications when the service stops
+	notificationListenerShutdownTimeout = 5 * time.Second
+)
+
+// notificationListener is a lightweight HTTP server which only receives the BaseNotification messages of the cameras.
+// Unlike the REST API of the device service, it can be bound to the network interface of the camera network, and does
+// not require the authentication of the EdgeX API gateway, since the notifications are authenticated by the secret
+// token of their subscription.
+type notificationListener struct {
+	lc     logger.LoggingClient
+	server *http.Server
+	// ips are the local addresses the listener is bound to, or empty if it listens on all interfaces
+	ips  []net.IP
+	port int
+	wg   sync.WaitGroup
+}
+
+// startNotificationListener starts listening for notifications on the addresses of the specified network interface,
+// or on all interfaces if the interface name is empty
+func startNotificationListener(lc logger.LoggingClient, handler *RestNotificationHandler, interfaceName string, port int) (*notificationListener, errors.EdgeX) {
+	var ips []net.IP
+	if interfaceName != "" {
+		var edgexErr errors.EdgeX
+		ips, edgexErr = interfaceIPs(interfaceName)
+		if edgexErr != nil {
+			return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.POST(apiResourceRoute, handler.processAsyncRequest)
+
+	listener := &notificationListener{
+		lc:   lc,
+		ips:  ips,
+		port: port,
+		server: &http.Server{
+			Handler:           e,
+			ReadHeaderTimeout: notificationListenerReadTimeout,
+			ReadTimeout:       notificationListenerReadTimeout,
+		},
+	}
+
+	hosts := []string{""}
+	if len(ips) > 0 {
+		hosts = hosts[:0]
+		for _, ip := range ips {
+			hosts = append(hosts, ip.String())
+		}
+	}
+	listeners := make([]net.Listener, 0, len(hosts))
+	for _, host := range hosts {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("unable to start the notification listener on port %d", port), err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	for _, l := range listeners {
+		l := l
+		lc.Infof("Listening for camera notifications on %s", l.Addr().String())
+		listener.wg.Add(1)
+		go func() {
+			defer listener.wg.Done()
+			if err := listener.server.Serve(l); err != nil && err != http.ErrServerClosed {
+				lc.Errorf("The notification listener on %s has stopped: %s", l.Addr().String(), err.Error())
+			}
+		}()
+	}
+	return listener, nil
+}
+
+// stop shuts the listener down, waiting for the notifications which are being processed
+func (listener *notificationListener) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationListenerShutdownTimeout)
+	defer cancel()
+	if err := listener.server.Shutdown(ctx); err != nil {
+		listener.lc.Warnf("Unable to gracefully stop the notification listener: %s", err.Error())
+	}
+	listener.wg.Wait()
+}
+
+// baseURL returns the URL of the listener as seen by the camera at the specified address. The local address is the
+// address of the interface which is on the same subnet as the camera, or else the source address of the route to the
+// camera. Returns false if no local address can be determined.
+func (listener *notificationListener) baseURL(cameraAddress string) (string, bool) {
+	camera := net.ParseIP(cameraAddress)
+	if camera == nil {
+		addrs, err := net.LookupHost(cameraAddress)
+		if err != nil || len(addrs) == 0 {
+			return "", false
+		}
+		camera = net.ParseIP(addrs[0])
+	}
+	if camera == nil {
+		return "", false
+	}
+
+	var local net.IP
+	if len(listener.ips) > 0 {
+		local = selectLocalIP(listener.ips, localNetworks(), camera)
+	} else if ip := selectLocalIP(nil, localNetworks(), camera); ip != nil {
+		local = ip
+	} else {
+		local = routeSourceIP(camera)
+	}
+	if local == nil {
+		return "", false
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(local.String(), strconv.Itoa(listener.port))), true
+}
+
+// interfaceIPs returns the unicast addresses of the network interface
+func interfaceIPs(interfaceName string) ([]net.IP, errors.EdgeX) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("network interface '%s' not found", interfaceName), err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("unable to get the addresses of network interface '%s'", interfaceName), err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("network interface '%s' has no address", interfaceName), nil)
+	}
+	return ips, nil
+}
+
+// localNetworks returns the networks of all the local interfaces
+func localNetworks() []*net.IPNet {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			networks = append(networks, ipNet)
+		}
+	}
+	return networks
+}
+
+// selectLocalIP returns the local address of the network which contains the camera. If allowed is not empty, only
+// the allowed addresses are selected, and the first allowed address of the camera's IP version is used as fallback.
+func selectLocalIP(allowed []net.IP, networks []*net.IPNet, camera net.IP) net.IP {
+	isAllowed := func(ip net.IP) bool {
+		if len(allowed) == 0 {
+			return true
+		}
+		for _, a := range allowed {
+			if a.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(camera) && isAllowed(network.IP) {
+			return network.IP
+		}
+	}
+	for _, a := range allowed {
+		if (a.To4() == nil) == (camera.To4() == nil) {
+			return a
+		}
+	}
+	return nil
+}
+
+// routeSourceIP returns the local address used to reach the camera through the default route. Connecting a UDP
+// socket does not send any packet.
+func routeSourceIP(camera net.IP) net.IP {
+	conn, err := net.Dial("udp", net.JoinHostPort(camera.String(), "80"))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+// baseNotificationURL returns the base URL the camera with the specified XAddr sends its notifications to. The URL of
+// the notification listener is used if it is enabled, and the BaseNotificationURL configuration otherwise.
+func (d *Driver) baseNotificationURL(xAddr string) string {
+	if d.notificationListener != nil {
+		host, _, err := net.SplitHostPort(xAddr)
+		if err != nil {
+			host = xAddr
+		}
+		if url, ok := d.notificationListener.baseURL(host); ok {
+			return url
+		}
+		d.lc.Warnf("Unable to determine the local address of the notification listener for camera %s, falling back to BaseNotificationURL", xAddr)
+	}
+	d.configMu.RLock()
+	defer d.configMu.RUnlock()
+	return d.config.AppCustom.BaseNotificationURL
+}
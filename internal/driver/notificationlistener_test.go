@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectLocalIP(t *testing.T) {
+	_, cameraNet, _ := net.ParseCIDR("192.168.10.5/24")
+	cameraNet.IP = net.ParseIP("192.168.10.5")
+	_, officeNet, _ := net.ParseCIDR("10.0.0.2/8")
+	officeNet.IP = net.ParseIP("10.0.0.2")
+	networks := []*net.IPNet{officeNet, cameraNet}
+
+	tests := []struct {
+		name     string
+		allowed  []net.IP
+		camera   string
+		expected string
+	}{
+		{
+			name:     "camera subnet",
+			camera:   "192.168.10.64",
+			expected: "192.168.10.5",
+		},
+		{
+			name:     "other subnet",
+			camera:   "10.20.30.40",
+			expected: "10.0.0.2",
+		},
+		{
+			name:   "routed camera",
+			camera: "172.16.0.9",
+		},
+		{
+			name:     "routed camera with bound listener",
+			allowed:  []net.IP{net.ParseIP("192.168.10.5")},
+			camera:   "172.16.0.9",
+			expected: "192.168.10.5",
+		},
+		{
+			name:     "subnet of another interface than the bound listener",
+			allowed:  []net.IP{net.ParseIP("192.168.10.5")},
+			camera:   "10.20.30.40",
+			expected: "192.168.10.5",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			ip := selectLocalIP(test.allowed, networks, net.ParseIP(test.camera))
+			if test.expected == "" {
+				assert.Nil(t, ip)
+				return
+			}
+			assert.Equal(t, test.expected, ip.String())
+		})
+	}
+}
+
+func TestNotificationListener(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	mockService.On("GetDeviceByName", "unknown").Return(models.Device{}, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, "not found", nil))
+	handler := NewRestNotificationHandler(mockService, driver)
+
+	// find a free port for the listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+
+	listener, edgexErr := startNotificationListener(driver.lc, handler, "", port)
+	require.NoError(t, edgexErr)
+	driver.notificationListener = listener
+
+	baseURL := driver.baseNotificationURL("127.0.0.1:80")
+	assert.Equal(t, fmt.Sprintf("http://127.0.0.1:%d", port), baseURL)
+
+	resp, err := http.Post(fmt.Sprintf("%s%s/%s/unknown/%s", baseURL, common.ApiBase, OnvifEventRestPath, CameraEvent),
+		"application/soap+xml", strings.NewReader(testNotifyMessage))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	listener.stop()
+	_, err = http.Post(baseURL, "application/soap+xml", strings.NewReader(testNotifyMessage))
+	assert.Error(t, err)
+
+	_, edgexErr = startNotificationListener(driver.lc, handler, "invalid-interface", port)
+	assert.Error(t, edgexErr)
+}
@@ -14,6 +14,8 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
@@ -50,6 +52,42 @@ type OnvifClient struct {
 	CameraEventResource     models.DeviceResource
 	pullPointManager        *PullPointManager
 	baseNotificationManager *BaseNotificationManager
+
+	// maintenanceUntil is the end time of the active maintenance window, or the zero time if there is none
+	maintenanceUntil time.Time
+	maintenanceTimer *time.Timer
+	maintenanceMu    sync.Mutex
+
+	// eventStates holds the current state of the camera's property events
+	eventStates eventStateTable
+	// eventFilter drops the duplicate, debounced and rate limited events of the camera
+	eventFilter eventFilter
+	// eventTopics is the cached topic catalog of the camera, or nil if it is not loaded yet
+	eventTopics   *eventTopicCatalog
+	eventTopicsMu sync.Mutex
+	// mediaProfiles caches the media profiles used to take the snapshots of events
+	mediaProfiles mediaProfileCache
+	// snapshotQueue takes the snapshots of events without blocking the event subscriptions
+	snapshotQueue snapshotQueue
+	// replayMu serializes the event replays, so that the searches of one camera do not overlap
+	replayMu sync.Mutex
+	// clock is the measured offset of the camera clock, which corrects the time of the events
+	clock cameraClock
+	// eventBuffer queues the readings of the camera while the message bus is unavailable
+	eventBuffer *eventBuffer
+	// metadataStream reads the RTSP metadata stream of the camera, or is nil if it is not open
+	metadataStream   *metadataStream
+	metadataStreamMu sync.Mutex
+
+	// nameMu guards DeviceName, which changes when the device is renamed, see deviceName
+	nameMu sync.RWMutex
+	// locked indicates the AdminState of the device is LOCKED, so that the camera is neither polled nor subscribed
+	locked atomic.Bool
+	// adminStateMu serializes the suspension and resumption of the event subscriptions, see applyAdminState
+	adminStateMu sync.Mutex
+	// pendingSubscriptions holds the names of the subscriptions being created, see reserveSubscription
+	pendingSubscriptions map[string]bool
+	subscriptionsMu      sync.Mutex
 }
 
 // newOnvifClient returns a new OnvifClient for communicating with a single camera with all of the additional
@@ -112,6 +150,13 @@ func (d *Driver) newOnvifClientInternal(device models.Device, temporary bool) (*
 	// Create BaseNotificationManager to control multiple notification consumer
 	baseNotificationManager := NewBaseNotificationManager(d.lc)
 	client.baseNotificationManager = baseNotificationManager
+
+	// the event buffer is started once the client is registered, see getOrCreateOnvifClient
+	client.eventBuffer = d.newEventBuffer(device.Name)
+	client.eventFilter.deliver = client.publishPendingEvents
+
+	client.locked.Store(device.AdminState == models.Locked)
+	d.restoreMaintenance(client, device)
 	return client, nil
 }
 
@@ -179,19 +224,91 @@ func (d *Driver) getOrCreateOnvifClient(device models.Device) (*OnvifClient, err
 	}
 
 	d.clientsMu.Lock()
+	if existing, ok := d.onvifClients[device.Name]; ok {
+		// the client was created concurrently, so this one is discarded before its event buffer is started
+		d.clientsMu.Unlock()
+		onvifClient.discardMaintenance()
+		return existing, nil
+	}
 	d.onvifClients[device.Name] = onvifClient
 	d.clientsMu.Unlock()
+	onvifClient.eventBuffer.start()
 	return onvifClient, nil
 }
 
-func (d *Driver) removeOnvifClient(deviceName string) {
+func (d *Driver) getOnvifClient(deviceName string) (*OnvifClient, bool) {
+	d.clientsMu.RLock()
+	defer d.clientsMu.RUnlock()
+	onvifClient, ok := d.onvifClients[deviceName]
+	return onvifClient, ok
+}
+
+// moveOnvifClient re-keys the onvif client of a renamed device, keeping its managers and subscriptions
+func (d *Driver) moveOnvifClient(oldName, newName string) {
 	d.clientsMu.Lock()
 	defer d.clientsMu.Unlock()
-	// note: delete on non-existing keys is a no-op
+	onvifClient, ok := d.onvifClients[oldName]
+	if !ok {
+		return
+	}
+	delete(d.onvifClients, oldName)
+	onvifClient.nameMu.Lock()
+	onvifClient.DeviceName = newName
+	onvifClient.nameMu.Unlock()
+	d.onvifClients[newName] = onvifClient
+	d.forgetDeviceProtocols(oldName)
+	if onvifClient.eventBuffer != nil {
+		onvifClient.eventBuffer.rename(newName)
+	}
+}
+
+// removeOnvifClient removes the onvif client of a removed device, unsubscribes its subscriptions from the camera, and
+// discards its pending and buffered events since they can not be published anymore
+func (d *Driver) removeOnvifClient(deviceName string) {
+	d.clientsMu.Lock()
+	onvifClient, ok := d.onvifClients[deviceName]
 	delete(d.onvifClients, deviceName)
+	d.clientsMu.Unlock()
+	d.forgetDeviceProtocols(deviceName)
+	if !ok {
+		return
+	}
+	onvifClient.discardMaintenance()
+	if onvifClient.pullPointManager != nil {
+		onvifClient.pullPointManager.UnsubscribeAll()
+	}
+	if onvifClient.baseNotificationManager != nil {
+		onvifClient.baseNotificationManager.UnsubscribeAll()
+	}
+	onvifClient.eventFilter.stop()
+	onvifClient.stopSnapshotQueue()
+	onvifClient.stopMetadataStream()
+	if onvifClient.eventBuffer != nil {
+		onvifClient.eventBuffer.stop(false)
+	}
+}
+
+// deviceName returns the current name of the camera's device
+func (onvifClient *OnvifClient) deviceName() string {
+	onvifClient.nameMu.RLock()
+	defer onvifClient.nameMu.RUnlock()
+	return onvifClient.DeviceName
+}
+
+// device returns the onvif device of the camera, which is replaced when the connection of the device is updated
+func (onvifClient *OnvifClient) device() OnvifDevice {
+	onvifClient.driver.clientsMu.RLock()
+	defer onvifClient.driver.clientsMu.RUnlock()
+	return onvifClient.onvifDevice
 }
 
 func (d *Driver) getCameraEventResourceByDeviceName(deviceName string) (r models.DeviceResource, edgexErr errors.EdgeX) {
+	return d.getDeviceResourceByGetFunction(deviceName, CameraEvent)
+}
+
+// getDeviceResourceByGetFunction returns the first device resource of the device's profile whose getFunction
+// attribute matches the specified function name
+func (d *Driver) getDeviceResourceByGetFunction(deviceName string, functionName string) (r models.DeviceResource, edgexErr errors.EdgeX) {
 	device, err := d.sdkService.GetDeviceByName(deviceName)
 	if err != nil {
 		return r, errors.NewCommonEdgeXWrapper(err)
@@ -202,11 +319,11 @@ func (d *Driver) getCameraEventResourceByDeviceName(deviceName string) (r models
 	}
 	for _, r := range profile.DeviceResources {
 		val, ok := r.Attributes[GetFunction]
-		if ok && fmt.Sprint(val) == CameraEvent {
+		if ok && fmt.Sprint(val) == functionName {
 			return r, nil
 		}
 	}
-	return r, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("device resource with Getfunciton '%s' not found", CameraEvent), nil)
+	return r, errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("device resource with Getfunciton '%s' not found", functionName), nil)
 }
 
 // CallOnvifFunction send the request to the camera via onvif client
@@ -236,6 +353,12 @@ func (onvifClient *OnvifClient) CallOnvifFunction(req sdkModel.CommandRequest, f
 	} else if functionName == onvif.SystemReboot {
 		onvifClient.RebootNeeded = false
 	}
+	if maintenanceFunctions[functionName] {
+		// the camera will be unavailable for a while, so suppress the status checks and alerts
+		if edgexErr := onvifClient.driver.startMaintenance(onvifClient, time.Now().Add(onvifClient.driver.defaultMaintenanceWindow())); edgexErr != nil {
+			onvifClient.lc.Warnf("Failed to start the maintenance window of device %s after '%s', %v", onvifClient.deviceName(), functionName, edgexErr)
+		}
+	}
 	cv, err := sdkModel.NewCommandValue(req.DeviceResourceName, common.ValueTypeObject, responseContent)
 	if err != nil {
 		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the function '%s' of web service '%s' ", functionName, serviceName), err)
@@ -247,7 +370,7 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 	var err error
 	switch functionName {
 	case GetCustomMetadata:
-		deviceName := onvifClient.DeviceName
+		deviceName := onvifClient.deviceName()
 		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
@@ -255,7 +378,7 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 
 		metadataObj, edgexError := onvifClient.getCustomMetadata(device, data)
 		if edgexError != nil {
-			onvifClient.driver.lc.Errorf("Failed to get customMetadata from the device %s", onvifClient.DeviceName)
+			onvifClient.driver.lc.Errorf("Failed to get customMetadata from the device %s", onvifClient.deviceName())
 			return nil, edgexError
 		}
 		cv, err = sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, metadataObj)
@@ -265,8 +388,10 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 
 		attributes[URLRawQuery] = "" // flush out the query so it resets with new calls
 	case SetCustomMetadata:
-		deviceName := onvifClient.DeviceName
-		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
+		deviceName := onvifClient.deviceName()
+		unlock := onvifClient.driver.lockDeviceProtocols(deviceName)
+		defer unlock()
+		device, err := onvifClient.driver.latestDevice(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
 		}
@@ -281,8 +406,10 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", deviceName), err)
 		}
 	case DeleteCustomMetadata:
-		deviceName := onvifClient.DeviceName
-		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
+		deviceName := onvifClient.deviceName()
+		unlock := onvifClient.driver.lockDeviceProtocols(deviceName)
+		defer unlock()
+		device, err := onvifClient.driver.latestDevice(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
 		}
@@ -308,10 +435,78 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 		}
 	case UnsubscribeCameraEvent:
 		go func() {
-			onvifClient.lc.Debugf("Unsubscribe camera event for the device '%v'", onvifClient.DeviceName)
+			onvifClient.lc.Debugf("Unsubscribe camera event for the device '%v'", onvifClient.deviceName())
 			onvifClient.pullPointManager.UnsubscribeAll()
 			onvifClient.baseNotificationManager.UnsubscribeAll()
+			if err := onvifClient.driver.cancelSubscriptions(onvifClient.deviceName()); err != nil {
+				onvifClient.lc.Warnf("Unable to clear the persisted subscriptions of device %s, %v", onvifClient.deviceName(), err)
+			}
 		}()
+	case GetEventState:
+		cv, err = onvifClient.callGetEventStateFunction(resourceName, attributes, data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case GetDroppedEvents:
+		cv, err = onvifClient.callGetDroppedEventsFunction(resourceName)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case GetSubscriptions:
+		cv, err = onvifClient.callGetSubscriptionsFunction(resourceName)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case CancelSubscription:
+		err = onvifClient.cancelSubscription(data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case UpdateSubscriptionFilter:
+		err = onvifClient.updateSubscriptionFilter(data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case SetSynchronizationPoint:
+		err = onvifClient.setSynchronizationPoint(data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case GetEventTopics:
+		cv, err = onvifClient.callGetEventTopicsFunction(resourceName)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case ReplayEvents:
+		err = onvifClient.callReplayEventsFunction(data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case GetSubscriptionHealth:
+		cv, err = onvifClient.callGetSubscriptionHealthFunction(resourceName)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case GetMaintenanceMode:
+		cv, err = sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, onvifClient.maintenanceStatus())
+		if err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", EdgeXWebService, functionName), err)
+		}
+	case SetMaintenanceMode:
+		err = onvifClient.driver.setMaintenanceMode(onvifClient, data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
+	case GetMetadataStream:
+		cv, err = sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, onvifClient.metadataStreamStatus())
+		if err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", EdgeXWebService, functionName), err)
+		}
+	case SetMetadataStream:
+		err = onvifClient.setMetadataStream(data)
+		if err != nil {
+			return nil, errors.NewCommonEdgeXWrapper(err)
+		}
 	case GetSnapshot:
 		res, edgexErr := onvifClient.callGetSnapshotFunction(data)
 		if edgexErr != nil {
@@ -322,8 +517,10 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", EdgeXWebService, functionName), err)
 		}
 	case SetFriendlyName:
-		deviceName := onvifClient.DeviceName
-		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
+		deviceName := onvifClient.deviceName()
+		unlock := onvifClient.driver.lockDeviceProtocols(deviceName)
+		defer unlock()
+		device, err := onvifClient.driver.latestDevice(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
 		}
@@ -333,12 +530,12 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "no data in request body", nil)
 		}
 		device.Protocols[OnvifProtocol][FriendlyName] = friendlyName // create or update friendly name field
-		err = onvifClient.driver.sdkService.UpdateDevice(device)
+		err = onvifClient.driver.updateDevice(device)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", deviceName), err)
 		}
 	case GetFriendlyName:
-		deviceName := onvifClient.DeviceName
+		deviceName := onvifClient.deviceName()
 		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
@@ -352,8 +549,10 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", EdgeXWebService, functionName), err)
 		}
 	case SetMACAddress:
-		deviceName := onvifClient.DeviceName
-		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
+		deviceName := onvifClient.deviceName()
+		unlock := onvifClient.driver.lockDeviceProtocols(deviceName)
+		defer unlock()
+		device, err := onvifClient.driver.latestDevice(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
 		}
@@ -369,12 +568,12 @@ func (onvifClient *OnvifClient) callCustomFunction(resourceName, functionName st
 		}
 
 		device.Protocols[OnvifProtocol][MACAddress] = mac // create or update mac address field
-		err = onvifClient.driver.sdkService.UpdateDevice(device)
+		err = onvifClient.driver.updateDevice(device)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", deviceName), err)
 		}
 	case GetMACAddress:
-		deviceName := onvifClient.DeviceName
+		deviceName := onvifClient.deviceName()
 		device, err := onvifClient.driver.sdkService.GetDeviceByName(deviceName)
 		if err != nil {
 			return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
@@ -398,21 +597,41 @@ func (onvifClient *OnvifClient) callSubscribeCameraEventFunction(resourceName, s
 	if edgexErr != nil {
 		return errors.NewCommonEdgeXWrapper(edgexErr)
 	}
-	switch subscribeType {
-	case PullPoint:
-		edgexErr = onvifClient.pullPointManager.NewSubscriber(onvifClient, resourceName, attributes, data)
-		if edgexErr != nil {
-			return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", serviceName, functionName), edgexErr)
+	request, edgexErr := newSubscriptionRequest(attributes, data, onvifClient.topicValidator)
+	if edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	name := request.subscriptionName(resourceName)
+	subscription := persistedSubscription{SubscribeType: subscribeType, Request: request}
+	edgexErr = onvifClient.subscribe(name, subscription)
+	if edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", serviceName, functionName), edgexErr)
+	}
+
+	// remember the subscription, so that it is re-established when the device service restarts
+	edgexErr = onvifClient.driver.saveSubscription(onvifClient.deviceName(), name, &subscription)
+	if edgexErr != nil {
+		onvifClient.lc.Warnf("Unable to persist the '%s' subscription of device %s, %v", name, onvifClient.deviceName(), edgexErr)
+	}
+	return nil
+}
+
+// subscribe creates the named subscription with the manager of its subscribeType
+func (onvifClient *OnvifClient) subscribe(name string, subscription persistedSubscription) errors.EdgeX {
+	if target := subscription.Request.Resource; target != nil {
+		if _, ok := onvifClient.driver.sdkService.DeviceResource(onvifClient.deviceName(), *target); !ok {
+			return errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("the target resource '%s' of the subscription '%s' is not found", *target, name), nil)
 		}
+	}
+
+	switch subscription.SubscribeType {
+	case PullPoint:
+		return onvifClient.pullPointManager.NewSubscriber(onvifClient, name, subscription.Request)
 	case BaseNotification:
-		edgexErr = onvifClient.baseNotificationManager.NewConsumer(onvifClient, resourceName, attributes, data)
-		if edgexErr != nil {
-			return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the web service '%s' function '%s'", serviceName, functionName), edgexErr)
-		}
+		return onvifClient.baseNotificationManager.NewConsumer(onvifClient, name, subscription.Request)
 	default:
-		return errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("unsupported subscribeType '%s'", subscribeType), nil)
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("unsupported subscribeType '%s'", subscription.SubscribeType), nil)
 	}
-	return nil
 }
 
 // callGetSnapshotFunction returns a snapshot from the camera as a slice of bytes
@@ -425,7 +644,7 @@ func (onvifClient *OnvifClient) callGetSnapshotFunction(data []byte) ([]byte, er
 	}
 	uriResponse, ok := respContent.(*media.GetSnapshotUriResponse)
 	if !ok {
-		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid GetSnapshotUriResponse of type %T for the camera %s", respContent, onvifClient.DeviceName), nil)
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid GetSnapshotUriResponse of type %T for the camera %s", respContent, onvifClient.deviceName()), nil)
 	}
 	url := uriResponse.MediaUri.Uri
 
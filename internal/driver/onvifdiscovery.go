@@ -19,6 +19,7 @@ import (
 	wsdiscovery "github.com/IOTechSystems/onvif/ws-discovery"
 	"github.com/edgexfoundry/device-onvif-camera/internal/netscan"
 	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
 	contract "github.com/edgexfoundry/go-mod-core-contracts/v3/models"
 )
@@ -175,12 +176,50 @@ func mapProbeResults(host, port string, devices []onvif.Device) (res []netscan.P
 // probe message directly over the connection and listening for any responses. Those
 // responses are then converted into a slice of onvif.Device.
 func executeRawProbe(conn net.Conn, params netscan.Params) ([]onvif.Device, error) {
+	addr := conn.RemoteAddr().String()
+
+	responses, err := sendRawProbe(conn, params.Timeout, params.Logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(responses) == 0 {
+		// log as trace because when using UDP this will be logged for all devices that are probed
+		// that do not respond or refuse the connection.
+		params.Logger.Tracef("%s: No Response", addr)
+		return nil, nil
+	}
+	for i, resp := range responses {
+		params.Logger.Debugf("%s: Response %d of %d: %s", addr, i+1, len(responses), resp)
+	}
+
+	devices, err := wsdiscovery.DevicesFromProbeResponses(responses)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		params.Logger.Debugf("%s: no devices matched from probe response", addr)
+		return nil, nil
+	}
+
+	return devices, nil
+}
+
+// sendRawProbe writes a ws-discovery probe message to the connection and returns all the raw
+// responses received before the timeout expires.
+func sendRawProbe(conn net.Conn, timeout time.Duration, lc logger.LoggingClient) ([]string, error) {
+	return sendRawProbeUntil(conn, timeout, lc, nil)
+}
+
+// sendRawProbeUntil writes a ws-discovery probe message to the connection and returns the raw responses received
+// before the timeout expires, or until a response is accepted by the done function if it is not nil.
+func sendRawProbeUntil(conn net.Conn, timeout time.Duration, lc logger.LoggingClient, done func(response string) bool) ([]string, error) {
 	probeSOAP := wsdiscovery.BuildProbeMessage(uuid.NewString(), nil, []string{"dn:NetworkVideoTransmitter"},
 		map[string]string{"dn": "http://www.onvif.org/ver10/network/wsdl"})
 
 	addr := conn.RemoteAddr().String()
 
-	if err := conn.SetDeadline(time.Now().Add(params.Timeout)); err != nil {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
 		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("%s: failed to set read/write deadline", addr), err)
 	}
 
@@ -196,33 +235,16 @@ func executeRawProbe(conn net.Conn, params netscan.Params) ([]onvif.Device, erro
 		if err != nil {
 			// ErrDeadlineExceeded is expected once the read timeout is expired
 			if !stdErrors.Is(err, os.ErrDeadlineExceeded) {
-				params.Logger.Debugf("Unexpected error occurred while reading ws-discovery responses: %s", err.Error())
+				lc.Debugf("Unexpected error occurred while reading ws-discovery responses: %s", err.Error())
 			}
 			break
 		}
 		responses = append(responses, string(buf[0:n]))
+		if done != nil && done(responses[len(responses)-1]) {
+			break
+		}
 	}
-
-	if len(responses) == 0 {
-		// log as trace because when using UDP this will be logged for all devices that are probed
-		// that do not respond or refuse the connection.
-		params.Logger.Tracef("%s: No Response", addr)
-		return nil, nil
-	}
-	for i, resp := range responses {
-		params.Logger.Debugf("%s: Response %d of %d: %s", addr, i+1, len(responses), resp)
-	}
-
-	devices, err := wsdiscovery.DevicesFromProbeResponses(responses)
-	if err != nil {
-		return nil, err
-	}
-	if len(devices) == 0 {
-		params.Logger.Debugf("%s: no devices matched from probe response", addr)
-		return nil, nil
-	}
-
-	return devices, nil
+	return responses, nil
 }
 
 // makeDeviceMacMap creates a lookup table of existing devices by MacAddress.
@@ -334,6 +356,16 @@ func (d *Driver) discoverFilter(discoveredDevices []sdkModel.DiscoveredDevice) [
 // updateExistingDevice compares a discovered device and a matching existing device, and updates the existing
 // device network address and port if necessary
 func (d *Driver) updateExistingDevice(device contract.Device, discDev sdkModel.DiscoveredDevice) error {
+	if device.AdminState == contract.Locked {
+		d.lc.Debugf("Skip updating the re-discovered device %s, because it is locked", device.Name)
+		return nil
+	}
+
+	// use the latest protocol properties so that the changes written since the discovery started are kept
+	unlock := d.lockDeviceProtocols(device.Name)
+	defer unlock()
+	device = d.withProtocolWrites(device)
+
 	shouldUpdate := false
 	if device.OperatingState == contract.Down {
 		device.OperatingState = contract.Up
@@ -373,7 +405,7 @@ func (d *Driver) updateExistingDevice(device contract.Device, discDev sdkModel.D
 		return nil
 	}
 
-	err := d.sdkService.UpdateDevice(device)
+	err := d.updateDevice(device)
 	if err != nil {
 		d.lc.Errorf("There was an error updating the network address for device %s: %s", device.Name, err.Error())
 		return errors.NewCommonEdgeXWrapper(err)
@@ -16,8 +16,6 @@ import (
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
 
 	"github.com/IOTechSystems/onvif"
-	"github.com/IOTechSystems/onvif/event"
-	"github.com/IOTechSystems/onvif/xsd"
 )
 
 // PullPointManager manages the subscribers to pull event from specified PullPoints
@@ -25,6 +23,8 @@ type PullPointManager struct {
 	lc          logger.LoggingClient
 	lock        *sync.RWMutex
 	subscribers map[string]*Subscriber
+	// suspended holds the subscription requests of the subscribers stopped by Suspend
+	suspended map[string]*SubscriptionRequest
 }
 
 // newPullPointManager create a new PullPointManager entity
@@ -32,44 +32,39 @@ func newPullPointManager(lc logger.LoggingClient) *PullPointManager {
 	return &PullPointManager{
 		lc:          lc,
 		subscribers: make(map[string]*Subscriber),
+		suspended:   make(map[string]*SubscriptionRequest),
 		lock:        new(sync.RWMutex),
 	}
 }
 
 // NewSubscriber creates a new subscriber entity and start pulling the event from the camera
-func (manager *PullPointManager) NewSubscriber(onvifClient *OnvifClient, resourceName string, attributes map[string]interface{}, data []byte) errors.EdgeX {
-	_, ok := manager.subscribers[resourceName]
-	if ok {
-		manager.lc.Warnf("'%s' resource's Pull point subscriber already exists, skip adding new subscriber.", resourceName)
-		return nil
-	}
-
-	request, edgexErr := newSubscriptionRequest(attributes, data)
+func (manager *PullPointManager) NewSubscriber(onvifClient *OnvifClient, name string, request *SubscriptionRequest) errors.EdgeX {
+	release, edgexErr := onvifClient.reserveSubscription(name)
 	if edgexErr != nil {
 		return errors.NewCommonEdgeXWrapper(edgexErr)
 	}
+	defer release()
+	return manager.createSubscriber(onvifClient, name, request)
+}
 
-	onvifClient.driver.configMu.RLock()
-	requestTimeout := onvifClient.driver.config.AppCustom.RequestTimeout
-	onvifClient.driver.configMu.RUnlock()
-
-	onvifDevice, err := manager.newSubscriberOnvifDevice(onvifClient.onvifDevice, *request.MessageTimeout, requestTimeout)
-	if edgexErr != nil {
-		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create onvif device for pulling event, %v", err), edgexErr)
+// createSubscriber creates the subscriber of a name reserved by reserveSubscription
+func (manager *PullPointManager) createSubscriber(onvifClient *OnvifClient, name string, request *SubscriptionRequest) errors.EdgeX {
+	onvifDevice, err := manager.newSubscriberOnvifDevice(onvifClient, request.messageTimeout())
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to create onvif device for pulling event", err)
 	}
 	sub := &Subscriber{
-		Name:                resourceName,
-		manager:             manager,
-		onvifClient:         onvifClient,
-		onvifDevice:         onvifDevice,
-		subscriptionRequest: request,
-		pullMessageRequestBody: event.PullMessages{
-			Timeout:      xsd.Duration(*request.MessageTimeout),
-			MessageLimit: xsd.Int(*request.MessageLimit),
-		},
-		Stopped: make(chan bool),
-	}
-	edgexErr = sub.createPullPoint()
+		Name:                   name,
+		manager:                manager,
+		onvifClient:            onvifClient,
+		onvifDevice:            onvifDevice,
+		subscriptionRequest:    request,
+		pullMessageRequestBody: newPullMessages(request),
+		Stopped:                make(chan bool),
+		done:                   make(chan struct{}),
+		recreateRequests:       make(chan chan errors.EdgeX, 1),
+	}
+	edgexErr := sub.createPullPoint()
 	if edgexErr != nil {
 		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to create the PullPoint subscription for resource '%s'", sub.Name), edgexErr)
 	}
@@ -78,19 +73,44 @@ func (manager *PullPointManager) NewSubscriber(onvifClient *OnvifClient, resourc
 	return nil
 }
 
-func (manager *PullPointManager) newSubscriberOnvifDevice(device OnvifDevice, messageTimeout string, httpRequestTimeout int) (OnvifDevice, error) {
+// newSubscriberOnvifDevice creates an onvif device for the camera, with an http timeout which allows the camera to
+// block the PullMessages request for the message timeout
+func (manager *PullPointManager) newSubscriberOnvifDevice(onvifClient *OnvifClient, messageTimeout string) (OnvifDevice, error) {
 	timeout, err := ParseISO8601(messageTimeout)
 	if err != nil {
 		return nil, err
 	}
-	timeout = timeout + time.Duration(httpRequestTimeout)*time.Second
-	params := device.GetDeviceParams()
+	onvifClient.driver.configMu.RLock()
+	requestTimeout := onvifClient.driver.config.AppCustom.RequestTimeout
+	onvifClient.driver.configMu.RUnlock()
+	timeout = timeout + time.Duration(requestTimeout)*time.Second
+
+	params := onvifClient.device().GetDeviceParams()
 	params.HttpClient = &http.Client{
 		Timeout: timeout,
 	}
 	return onvif.NewDevice(params)
 }
 
+// health returns the health of the subscriptions by resource name
+func (manager *PullPointManager) health() map[string]SubscriptionHealth {
+	result := make(map[string]SubscriptionHealth)
+	for _, sub := range manager.listSubscribers() {
+		result[sub.Name] = sub.health.snapshot()
+	}
+	return result
+}
+
+func (manager *PullPointManager) listSubscribers() []*Subscriber {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	subscribers := make([]*Subscriber, 0, len(manager.subscribers))
+	for _, sub := range manager.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers
+}
+
 func (manager *PullPointManager) addSubscriber(sub *Subscriber) {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
@@ -103,11 +123,171 @@ func (manager *PullPointManager) removeSubscriber(sub *Subscriber) {
 	delete(manager.subscribers, sub.Name)
 }
 
+// ResubscribeAll replaces the pull point of every subscriber, which is required after the camera reboots. The pull
+// points are re-created by the pull message loops, which retry with backoff if they can not be re-created now.
+func (manager *PullPointManager) ResubscribeAll() {
+	subscribers := manager.listSubscribers()
+	for _, sub := range subscribers {
+		_ = sub.recreate(false)
+	}
+}
+
 // UnsubscribeAll stops all subscriptions
 func (manager *PullPointManager) UnsubscribeAll() {
-	for _, sub := range manager.subscribers {
-		// subscriber will stop to pull message and unsubscribe the subscription when receiving the Stopped signal
-		sub.Stopped <- true
+	subscribers := manager.listSubscribers()
+	for _, sub := range subscribers {
+		manager.stopSubscriber(sub)
 	}
 	manager.lc.Debug("Unsubscribe all subscriptions")
 }
+
+// Suspend stops all subscriptions and keeps their requests, so that they can be re-created by Resume
+func (manager *PullPointManager) Suspend() {
+	subscribers := manager.listSubscribers()
+	for _, sub := range subscribers {
+		manager.suspend(sub.Name, sub.request())
+	}
+	manager.UnsubscribeAll()
+}
+
+// Unsubscribe stops the named subscription, and returns false if there is no active or suspended subscription
+// with the name
+func (manager *PullPointManager) Unsubscribe(name string) bool {
+	manager.lock.Lock()
+	sub, active := manager.subscribers[name]
+	_, suspended := manager.suspended[name]
+	delete(manager.suspended, name)
+	manager.lock.Unlock()
+
+	if active {
+		manager.stopSubscriber(sub)
+	}
+	return active || suspended
+}
+
+// SetSynchronizationPoint requests the current state from the named active subscription, or from every active
+// subscription if the name is empty. Returns false if there is no matching active subscription.
+func (manager *PullPointManager) SetSynchronizationPoint(name string) (bool, errors.EdgeX) {
+	found := false
+	for _, sub := range manager.listSubscribers() {
+		if name != "" && sub.Name != name {
+			continue
+		}
+		found = true
+		if edgexErr := sub.setSynchronizationPoint(); edgexErr != nil {
+			return true, errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+	}
+	return found, nil
+}
+
+// stopSubscriber stops the pull message loop of the subscriber, which unsubscribes from the camera
+func (manager *PullPointManager) stopSubscriber(sub *Subscriber) {
+	select {
+	case sub.Stopped <- true:
+		<-sub.done
+	case <-sub.done:
+		// the subscription has already terminated
+	}
+}
+
+// UpdateRequest replaces the request of the named subscription, and re-creates the subscription on the camera.
+// Returns false if there is no active or suspended subscription with the name.
+func (manager *PullPointManager) UpdateRequest(name string, request *SubscriptionRequest) (bool, errors.EdgeX) {
+	manager.lock.Lock()
+	sub, active := manager.subscribers[name]
+	_, suspended := manager.suspended[name]
+	if suspended {
+		manager.suspended[name] = request
+	}
+	manager.lock.Unlock()
+
+	if !active {
+		return suspended, nil
+	}
+	sub.mu.Lock()
+	sub.subscriptionRequest = request
+	sub.mu.Unlock()
+	if edgexErr := sub.recreate(true); edgexErr != nil {
+		return true, errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	return true, nil
+}
+
+// request returns the request of the named active or suspended subscription
+func (manager *PullPointManager) request(name string) (*SubscriptionRequest, bool) {
+	manager.lock.RLock()
+	sub, active := manager.subscribers[name]
+	request, suspended := manager.suspended[name]
+	manager.lock.RUnlock()
+	if active {
+		return sub.request(), true
+	}
+	return request, suspended
+}
+
+// subscriptions returns the active and suspended subscriptions
+func (manager *PullPointManager) subscriptions() []SubscriptionInfo {
+	var result []SubscriptionInfo
+	for _, sub := range manager.listSubscribers() {
+		result = append(result, newSubscriptionInfo(sub.Name, PullPoint, sub.request(), sub.address(), sub.deadline(), sub.health.snapshot()))
+	}
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	for name, request := range manager.suspended {
+		result = append(result, newSubscriptionInfo(name, PullPoint, request, "", time.Time{}, SubscriptionHealth{State: SubscriptionSuspended}))
+	}
+	return result
+}
+
+// hasSubscription returns true if there is an active or suspended subscription with the name
+func (manager *PullPointManager) hasSubscription(name string) bool {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	_, active := manager.subscribers[name]
+	_, suspended := manager.suspended[name]
+	return active || suspended
+}
+
+// suspend keeps the request of a subscription, which is created when Resume is called
+func (manager *PullPointManager) suspend(name string, request *SubscriptionRequest) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.suspended[name] = request
+}
+
+// Resume re-creates the subscriptions stopped by Suspend. The subscriptions which can not be re-created stay
+// suspended, so that they can be resumed again.
+func (manager *PullPointManager) Resume(onvifClient *OnvifClient) {
+	for _, name := range manager.suspendedNames() {
+		request, release, ok := onvifClient.reserveSuspendedSubscription(name, manager.takeSuspended)
+		if !ok {
+			continue
+		}
+		if edgexErr := manager.createSubscriber(onvifClient, name, request); edgexErr != nil {
+			manager.lc.Errorf("Failed to resume the PullPoint subscription for resource '%s', %v", name, edgexErr)
+			manager.suspend(name, request)
+		}
+		release()
+	}
+}
+
+// suspendedNames returns the names of the suspended subscriptions
+func (manager *PullPointManager) suspendedNames() []string {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	names := make([]string, 0, len(manager.suspended))
+	for name := range manager.suspended {
+		names = append(names, name)
+	}
+	return names
+}
+
+// takeSuspended removes the named subscription from the suspended subscriptions and returns its request
+func (manager *PullPointManager) takeSuspended(name string) (*SubscriptionRequest, bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	request, ok := manager.suspended[name]
+	delete(manager.suspended, name)
+	return request, ok
+}
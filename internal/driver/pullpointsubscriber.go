@@ -12,9 +12,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
-	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
-	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
 
 	"github.com/IOTechSystems/onvif"
@@ -31,60 +31,224 @@ type Subscriber struct {
 	onvifDevice OnvifDevice
 	// SubscriptionAddress is used to pull the event from the camera
 	SubscriptionAddress string
+	// terminationDeadline is the local time at which the pull point terminates
+	terminationDeadline time.Time
+	// mu protects the onvifDevice, subscriptionRequest, pullMessageRequestBody, SubscriptionAddress and
+	// terminationDeadline, which change when the pull point is re-created or its filters are updated
+	mu sync.Mutex
 	// subscriptionRequest is used to create the PullPoint subscription
 	subscriptionRequest *SubscriptionRequest
 	// pullMessageRequestBody is the pullMessage onvif function's request body
 	pullMessageRequestBody event.PullMessages
+	// health tracks the state, the last message time and the errors of the subscription
+	health subscriptionHealth
 	// Stopped indicates the Subscriber should stop the PullMessageLoop
 	Stopped chan bool
+	// done is closed when the PullMessageLoop has exited
+	done chan struct{}
+	// recreateRequests hands the requests to re-create the pull point to the PullMessageLoop, which sends the result
+	// to the channel of the request
+	recreateRequests chan chan errors.EdgeX
+	// recreateReplies are the requests to re-create the pull point which are not processed yet. It is only accessed
+	// by the PullMessageLoop.
+	recreateReplies []chan errors.EdgeX
 }
 
-// StartPullMessageLoop implements the long-polling strategy to pull the camera event
+// newPullMessages returns the PullMessages request body of the subscription request
+func newPullMessages(request *SubscriptionRequest) event.PullMessages {
+	return event.PullMessages{
+		Timeout:      xsd.Duration(request.messageTimeout()),
+		MessageLimit: xsd.Int(*request.MessageLimit),
+	}
+}
+
+// StartPullMessageLoop implements the long-polling strategy to pull the camera event. Failed requests are retried with
+// exponential backoff, and the pull point is re-created if it fails repeatedly or no longer exists, which happens when
+// the camera reboots. The pull point is renewed before its termination time if AutoRenew is enabled, otherwise the
+// subscription is removed when it terminates. The pull point is only re-created by the loop, so requests from other
+// goroutines are handed to it with recreate.
 func (sub *Subscriber) StartPullMessageLoop() {
 	sub.onvifClient.lc.Infof("Subscriber starts the PullMessage loop for '%s'", sub.Name)
-	// Remove self when subscription finished or pull message failed
+	defer close(sub.done)
+	// Remove self when the subscription is stopped or terminated
 	defer sub.manager.removeSubscriber(sub)
+
+	attempt := 0
+	recreate := false
 	for {
 		select {
 		case <-sub.Stopped:
-			sub.onvifClient.lc.Infof("Removing the subscription '%s'", sub.Name)
-			edgexErr := sub.unsubscribe()
-			if edgexErr != nil {
-				sub.onvifClient.lc.Warnf(edgexErr.Message())
+			sub.stop()
+			return
+		case reply := <-sub.recreateRequests:
+			sub.recreateReplies = append(sub.recreateReplies, reply)
+		default:
+		}
+		if len(sub.recreateReplies) > 0 {
+			recreate = true
+		}
+
+		if sub.onvifClient.inMaintenance() {
+			// the camera is expected to be unavailable, the pull point is re-created when the maintenance window ends
+			sub.health.setState(SubscriptionPaused)
+			sub.replyRecreate(nil)
+			if sub.wait(maintenancePollInterval) {
+				sub.stop()
 				return
 			}
+			continue
+		}
+
+		now := time.Now()
+		deadline := sub.deadline()
+		if !sub.request().autoRenew() && !now.Before(deadline) {
+			sub.onvifClient.lc.Infof("The pull point subscription '%s' has terminated", sub.Name)
 			return
+		}
+
+		var edgexErr errors.EdgeX
+		switch {
+		case recreate:
+			edgexErr = sub.recreatePullPoint()
+			sub.replyRecreate(edgexErr)
+		case sub.request().autoRenew() && !now.Before(deadline.Add(-renewMargin)):
+			if edgexErr = sub.renew(); edgexErr != nil {
+				sub.onvifClient.lc.Warnf("Failed to renew the pull point for resource '%s', try to create a new one, %v", sub.Name, edgexErr)
+				edgexErr = sub.recreatePullPoint()
+			}
 		default:
-			sub.onvifClient.lc.Debugf("Pull the event from '%s' for resource '%s'", sub.SubscriptionAddress, sub.Name)
 			// The camera will block the request according to the SubscribeCameraEvent's MessageTimeout
-			// and the device service will renew the expired subscription if AutoRenew is enabled.
-			edgexErr := sub.pullMessage()
-			if edgexErr != nil {
-				sub.onvifClient.lc.Warnf(edgexErr.Message())
-				return
-			}
+			edgexErr = sub.pullMessage()
+		}
+
+		if edgexErr == nil {
+			attempt = 0
+			recreate = false
+			continue
+		}
+		if sub.onvifClient.inMaintenance() {
+			sub.onvifClient.lc.Debugf("Ignoring the pull message error during the maintenance window, %s", edgexErr.Message())
+			continue
+		}
+
+		sub.health.failed(edgexErr)
+		attempt++
+		// a pull point which fails repeatedly is assumed to be lost, for example because the camera rebooted
+		recreate = recreate || attempt > 1 || errors.Kind(edgexErr) == errors.KindEntityDoesNotExist
+		delay := retryBackoff(attempt)
+		sub.onvifClient.lc.Warnf("%s, retrying in %v", edgexErr.Message(), delay)
+		if sub.wait(delay) {
+			sub.stop()
+			return
 		}
 	}
 }
 
+// wait pauses the loop for the specified duration, and returns true if the subscriber was stopped meanwhile. The
+// pause ends early if the pull point should be re-created.
+func (sub *Subscriber) wait(duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-sub.Stopped:
+		return true
+	case reply := <-sub.recreateRequests:
+		sub.recreateReplies = append(sub.recreateReplies, reply)
+		return false
+	case <-timer.C:
+		return false
+	}
+}
+
+// recreate hands a request to re-create the pull point to the PullMessageLoop, which re-creates it once the pending
+// PullMessages request returns. If wait is true, the result is returned once the pull point is re-created, otherwise
+// the result is only logged by the loop.
+func (sub *Subscriber) recreate(wait bool) errors.EdgeX {
+	reply := make(chan errors.EdgeX, 1)
+	if !wait {
+		select {
+		case sub.recreateRequests <- reply:
+		default:
+			// a request is already pending
+		}
+		return nil
+	}
+	select {
+	case sub.recreateRequests <- reply:
+	case <-sub.done:
+		// the subscription has already terminated
+		return nil
+	}
+	select {
+	case edgexErr := <-reply:
+		return edgexErr
+	case <-sub.done:
+		return nil
+	}
+}
+
+// replyRecreate sends the result to the pending requests to re-create the pull point
+func (sub *Subscriber) replyRecreate(edgexErr errors.EdgeX) {
+	for _, reply := range sub.recreateReplies {
+		reply <- edgexErr
+	}
+	sub.recreateReplies = nil
+}
+
+func (sub *Subscriber) stop() {
+	sub.onvifClient.lc.Infof("Removing the subscription '%s'", sub.Name)
+	if edgexErr := sub.unsubscribe(); edgexErr != nil {
+		sub.onvifClient.lc.Warnf(edgexErr.Message())
+	}
+}
+
+func (sub *Subscriber) request() *SubscriptionRequest {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.subscriptionRequest
+}
+
+func (sub *Subscriber) address() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.SubscriptionAddress
+}
+
+func (sub *Subscriber) deadline() time.Time {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.terminationDeadline
+}
+
+func (sub *Subscriber) device() OnvifDevice {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.onvifDevice
+}
+
+// requestedLifetime returns the remaining lifetime requested by the InitialTerminationTime of the request
+func (sub *Subscriber) requestedLifetime() time.Duration {
+	duration, _ := sub.request().lifetime(time.Now())
+	return duration
+}
+
 func (sub *Subscriber) pullMessage() errors.EdgeX {
-	requestBody, err := xml.Marshal(sub.pullMessageRequestBody)
+	sub.mu.Lock()
+	pullMessages := sub.pullMessageRequestBody
+	sub.mu.Unlock()
+	requestBody, err := xml.Marshal(pullMessages)
 	if err != nil {
 		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to marshal the PullMessage request for '%s', %v", sub.Name, err), err)
 	}
-	servResp, err := sub.onvifDevice.SendSoap(sub.SubscriptionAddress, string(requestBody))
+	address := sub.address()
+	sub.onvifClient.lc.Debugf("Pull the event from '%s' for resource '%s'", address, sub.Name)
+	servResp, err := sub.device().SendSoap(address, string(requestBody))
 	if err != nil {
 		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to send the '%s' pull event message request, %v", onvif.PullMessages, err), err)
 	}
 	defer servResp.Body.Close()
-	if *sub.subscriptionRequest.AutoRenew && (servResp.StatusCode == http.StatusNotFound || servResp.StatusCode == http.StatusBadRequest) {
-		sub.onvifClient.lc.Warnf("The pull point expired, try to create a new one")
-
-		edgexErr := sub.createPullPoint()
-		if edgexErr != nil {
-			return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to create the PullPoint subscription for resource '%s'", sub.Name), edgexErr)
-		}
-		return nil
+	if servResp.StatusCode == http.StatusNotFound || servResp.StatusCode == http.StatusBadRequest {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("the pull point for '%s' expired or dropped, status code: %d", sub.Name, servResp.StatusCode), nil)
 	}
 
 	rsp, err := io.ReadAll(servResp.Body)
@@ -100,21 +264,21 @@ func (sub *Subscriber) pullMessage() errors.EdgeX {
 
 	res, ok := response.Body.Content.(*event.PullMessagesResponse)
 	if !ok {
-		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid PullMessagesResponse of type %T for the camera %s", response.Body.Content, sub.onvifClient.DeviceName), nil)
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid PullMessagesResponse of type %T for the camera %s", response.Body.Content, sub.onvifClient.deviceName()), nil)
+	}
+	now := time.Now()
+	if res.CurrentTime != nil {
+		sub.onvifClient.clock.update(string(*res.CurrentTime), now)
 	}
+	sub.health.succeeded(len(res.NotificationMessage), now)
 	if len(res.NotificationMessage) == 0 {
 		return nil
 	}
-	cv, err := sdkModel.NewCommandValue(sub.onvifClient.CameraEventResource.Name, common.ValueTypeObject, response.Body.Content)
-	if err != nil {
-		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue  for '%s', %v", sub.Name, err), err)
-	}
-	asyncValues := &sdkModel.AsyncValues{
-		DeviceName:    sub.onvifClient.DeviceName,
-		CommandValues: []*sdkModel.CommandValue{cv},
+	readings, edgexErr := parseEventReadings(rsp)
+	if edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to parse the PullMessage response for '%s'", sub.Name), edgexErr)
 	}
-
-	sub.onvifClient.driver.sdkService.AsyncValuesChannel() <- asyncValues
+	sub.onvifClient.driver.publishEventReadings(sub.onvifClient.deviceName(), sub.request().eventRoute(sub.onvifClient.CameraEventResource.Name), readings)
 	return nil
 }
 
@@ -132,39 +296,95 @@ func (sub *Subscriber) createPullPoint() errors.EdgeX {
 	}
 	subscriptionResponse, ok := respContent.(*event.CreatePullPointSubscriptionResponse)
 	if !ok {
-		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid CreatePullPointSubscriptionResponse of type %T for the camera %s", respContent, sub.onvifClient.DeviceName), nil)
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("invalid CreatePullPointSubscriptionResponse of type %T for the camera %s", respContent, sub.onvifClient.deviceName()), nil)
 	}
+
+	// the onvif library does not unmarshal the termination time of the response, so the requested lifetime is used
+	// until the pull point is renewed. A subscription without AutoRenew keeps the deadline of the first pull point.
+	deadline := terminationDeadline(time.Now(), "", "", sub.requestedLifetime())
+	sub.mu.Lock()
 	sub.SubscriptionAddress = fmt.Sprint(subscriptionResponse.SubscriptionReference.Address)
+	if sub.terminationDeadline.IsZero() || sub.subscriptionRequest.autoRenew() {
+		sub.terminationDeadline = deadline
+	}
+	sub.mu.Unlock()
+
+	// the initial state is queued in the new pull point, and received by the next PullMessages request
+	sub.synchronize()
 	return nil
 }
 
-func (sub *Subscriber) createPullPointSubscription() *event.CreatePullPointSubscription {
-	filter := &event.FilterType{}
-	if sub.subscriptionRequest.TopicFilter != nil {
-		filter.TopicExpression = &event.TopicExpressionType{TopicKinds: xsd.String(*sub.subscriptionRequest.TopicFilter)}
-	}
-	if sub.subscriptionRequest.MessageContentFilter != nil {
-		filter.MessageContent = &event.QueryExpressionType{MessageKind: xsd.String(*sub.subscriptionRequest.MessageContentFilter)}
+// synchronize requests the initial state of a new pull point. Not every camera supports it, so a failure is only logged.
+func (sub *Subscriber) synchronize() {
+	if edgexErr := sub.setSynchronizationPoint(); edgexErr != nil {
+		sub.onvifClient.lc.Warnf("Failed to request the initial state for resource '%s', %v", sub.Name, edgexErr)
 	}
-	InitialTerminationTime := xsd.String(*sub.subscriptionRequest.InitialTerminationTime)
-	subscriptionPolicy := xsd.String(*sub.subscriptionRequest.SubscriptionPolicy)
-	return &event.CreatePullPointSubscription{
-		Filter:                 filter,
-		InitialTerminationTime: &InitialTerminationTime,
-		SubscriptionPolicy:     &subscriptionPolicy,
+}
+
+// setSynchronizationPoint requests the pull point to send the current state of the properties which match its filter
+func (sub *Subscriber) setSynchronizationPoint() errors.EdgeX {
+	if edgexErr := sendSetSynchronizationPoint(sub.device(), sub.address()); edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to set the synchronization point of '%s'", sub.Name), edgexErr)
 	}
+	return nil
 }
 
-func (sub *Subscriber) unsubscribe() errors.EdgeX {
-	request := event.Unsubscribe{}
-	requestBody, err := xml.Marshal(request)
+// recreatePullPoint replaces the pull point of the subscriber. The onvif device and the PullMessages request body are
+// also re-created, since the address of the camera or the MessageTimeout and MessageLimit of the request may have
+// changed. It must only be called by the PullMessageLoop, see recreate.
+func (sub *Subscriber) recreatePullPoint() errors.EdgeX {
+	sub.onvifClient.lc.Infof("Creating a new pull point for resource '%s'", sub.Name)
+	if edgexErr := sub.unsubscribe(); edgexErr != nil {
+		sub.onvifClient.lc.Debugf("Failed to unsubscribe the previous pull point for resource '%s', %v", sub.Name, edgexErr)
+	}
+	request := sub.request()
+	onvifDevice, err := sub.manager.newSubscriberOnvifDevice(sub.onvifClient, request.messageTimeout())
 	if err != nil {
-		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to marshal the unsubscribe request for '%s', %v", sub.Name, err), err)
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to create onvif device for pulling event", err)
 	}
-	_, edgexErr := sub.onvifClient.onvifDevice.SendSoap(sub.SubscriptionAddress, string(requestBody))
+	sub.mu.Lock()
+	sub.onvifDevice = onvifDevice
+	sub.pullMessageRequestBody = newPullMessages(request)
+	sub.mu.Unlock()
+
+	edgexErr := sub.createPullPoint()
+	if edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to create the PullPoint subscription for resource '%s'", sub.Name), edgexErr)
+	}
+	sub.health.setState(SubscriptionActive)
+	return nil
+}
+
+func (sub *Subscriber) renew() errors.EdgeX {
+	sub.onvifClient.lc.Debugf("Renewing the pull point '%s' for resource '%s'", sub.address(), sub.Name)
+	currentTime, terminationTime, edgexErr := sendRenew(sub.device(), sub.address(), sub.request().terminationTime(time.Now()))
 	if edgexErr != nil {
 		return errors.NewCommonEdgeXWrapper(edgexErr)
 	}
-	sub.onvifClient.lc.Debugf("Unsubscribe the subscription '%s' from %s", sub.Name, sub.SubscriptionAddress)
+	now := time.Now()
+	sub.onvifClient.clock.update(currentTime, now)
+	deadline := terminationDeadline(now, currentTime, terminationTime, sub.requestedLifetime())
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.terminationDeadline = deadline
+	return nil
+}
+
+func (sub *Subscriber) createPullPointSubscription() *event.CreatePullPointSubscription {
+	request := sub.request()
+	InitialTerminationTime := xsd.String(request.terminationTime(time.Now()))
+	return &event.CreatePullPointSubscription{
+		Filter:                 eventFilterType(request.TopicFilter, request.MessageContentFilter),
+		InitialTerminationTime: &InitialTerminationTime,
+		SubscriptionPolicy:     request.subscriptionPolicy(),
+	}
+}
+
+func (sub *Subscriber) unsubscribe() errors.EdgeX {
+	address := sub.address()
+	if edgexErr := sendUnsubscribe(sub.device(), address); edgexErr != nil {
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to unsubscribe '%s'", sub.Name), edgexErr)
+	}
+	sub.onvifClient.lc.Debugf("Unsubscribe the subscription '%s' from %s", sub.Name, address)
 	return nil
 }
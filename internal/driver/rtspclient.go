@@ -0,0 +1,538 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rtspVersion     = "RTSP/1.0"
+	rtspDefaultPort = "554"
+	rtspUserAgent   = "device-onvif-camera"
+	// rtspDefaultSessionTimeout is the session timeout of RFC 2326, which applies if the camera does not report one
+	rtspDefaultSessionTimeout = 60 * time.Second
+	// rtspMaxBodySize limits the size of the responses of the camera
+	rtspMaxBodySize = 1 << 20
+
+	// onvifMetadataEncoding is the RTP encoding name of the onvif metadata track in the SDP of the stream
+	onvifMetadataEncoding = "vnd.onvif.metadata"
+)
+
+// rtspResponse is a response of the RTSP server
+type rtspResponse struct {
+	statusCode int
+	status     string
+	header     textproto.MIMEHeader
+	body       []byte
+}
+
+// rtpPacket is an RTP packet received on an interleaved channel of the RTSP connection
+type rtpPacket struct {
+	marker   bool
+	sequence uint16
+	payload  []byte
+}
+
+// sdpMedia is a media description of an SDP session
+type sdpMedia struct {
+	mediaType string
+	encoding  string
+	control   string
+}
+
+// rtspAuth is the authentication challenge of the RTSP server
+type rtspAuth struct {
+	digest    bool
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        int
+}
+
+// rtspClient is a minimal RTSP client, which plays a single track with RTP interleaved in the RTSP connection. It
+// supports the Basic and Digest authentication used by the cameras. The packets are read by a single goroutine, while
+// the keepalive and teardown requests may be sent by others.
+type rtspClient struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	timeout  time.Duration
+	url      string
+	username string
+	password string
+
+	// mu serializes the requests written to the connection
+	mu             sync.Mutex
+	cseq           int
+	auth           *rtspAuth
+	session        string
+	sessionTimeout time.Duration
+	// channel is the interleaved channel of the RTP packets of the track
+	channel byte
+}
+
+// dialRTSP connects to the RTSP server of the stream uri. The credentials in the uri take precedence over the
+// specified ones, and are removed from the uri which is requested.
+func dialRTSP(uri, username, password string, timeout time.Duration) (*rtspClient, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream uri, %w", err)
+	}
+	if !strings.EqualFold(u.Scheme, "rtsp") {
+		return nil, fmt.Errorf("unsupported stream uri scheme '%s'", u.Scheme)
+	}
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+		u.User = nil
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), rtspDefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &rtspClient{
+		conn:           conn,
+		reader:         bufio.NewReader(conn),
+		timeout:        timeout,
+		url:            u.String(),
+		username:       username,
+		password:       password,
+		sessionTimeout: rtspDefaultSessionTimeout,
+	}, nil
+}
+
+// playTrack describes the stream, and plays the first track with the specified encoding, without the other tracks
+func (c *rtspClient) playTrack(encoding string) error {
+	res, err := c.do("DESCRIBE", c.url, map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return err
+	}
+	base := c.url
+	if value := res.header.Get("Content-Base"); value != "" {
+		base = value
+	} else if value := res.header.Get("Content-Location"); value != "" {
+		base = value
+	}
+	sessionControl, medias := parseSDP(res.body)
+
+	var track *sdpMedia
+	for i := range medias {
+		if strings.EqualFold(medias[i].encoding, encoding) {
+			track = &medias[i]
+			break
+		}
+	}
+	if track == nil {
+		return fmt.Errorf("the stream has no %s track", encoding)
+	}
+
+	res, err = c.do("SETUP", resolveRTSPControl(base, track.control), map[string]string{"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1"})
+	if err != nil {
+		return err
+	}
+	if err = c.setSession(res.header.Get("Session")); err != nil {
+		return err
+	}
+	c.channel = interleavedChannel(res.header.Get("Transport"))
+
+	_, err = c.do("PLAY", resolveRTSPControl(base, sessionControl), map[string]string{"Range": "npt=0.000-"})
+	return err
+}
+
+// setSession stores the session id and timeout of the SETUP response, e.g. 12345678;timeout=60
+func (c *rtspClient) setSession(value string) error {
+	parts := strings.Split(value, ";")
+	id := strings.TrimSpace(parts[0])
+	if id == "" {
+		return fmt.Errorf("the SETUP response has no session")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = id
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if seconds, err := strconv.Atoi(value); strings.EqualFold(key, "timeout") && err == nil && seconds > 0 {
+			c.sessionTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	return nil
+}
+
+func (c *rtspClient) keepAliveInterval() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionTimeout / 2
+}
+
+// do sends a request and reads its response, which is retried with the credentials if the server requests them
+func (c *rtspClient) do(method, uri string, header map[string]string) (*rtspResponse, error) {
+	if err := c.write(method, uri, header); err != nil {
+		return nil, err
+	}
+	res, err := c.readResponse()
+	if err != nil {
+		return nil, err
+	}
+	if res.statusCode == 401 && c.username != "" {
+		// the request is retried once with the new challenge, since the nonce of the previous one may have expired
+		c.mu.Lock()
+		c.auth = parseRTSPAuthenticate(res.header.Values("WWW-Authenticate"))
+		c.mu.Unlock()
+		if err = c.write(method, uri, header); err != nil {
+			return nil, err
+		}
+		if res, err = c.readResponse(); err != nil {
+			return nil, err
+		}
+	}
+	if res.statusCode != 200 {
+		return nil, fmt.Errorf("the %s request failed with status %d %s", method, res.statusCode, res.status)
+	}
+	return res, nil
+}
+
+// write sends a request without waiting for its response
+func (c *rtspClient) write(method, uri string, header map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\r\n", method, uri, rtspVersion)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", c.cseq)
+	fmt.Fprintf(&b, "User-Agent: %s\r\n", rtspUserAgent)
+	if c.auth != nil {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", c.auth.authorization(method, uri, c.username, c.password))
+	}
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	for key, value := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// readResponse reads the next response of the server, skipping the interleaved packets received before it
+func (c *rtspClient) readResponse() (*rtspResponse, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	for {
+		res, _, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			return res, nil
+		}
+	}
+}
+
+// readPacket returns the next RTP packet of the track, skipping the RTCP packets and the responses of the keepalive
+// requests. It fails if no message is received within the timeout, so the keepalive requests should be sent more often.
+func (c *rtspClient) readPacket(timeout time.Duration) (rtpPacket, error) {
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return rtpPacket{}, err
+		}
+		_, frame, err := c.readMessage()
+		if err != nil {
+			return rtpPacket{}, err
+		}
+		if frame == nil || frame.channel != c.channel {
+			continue
+		}
+		return parseRTPPacket(frame.data)
+	}
+}
+
+type interleavedFrame struct {
+	channel byte
+	data    []byte
+}
+
+// readMessage reads either an RTSP response or an interleaved frame from the connection. Both are nil if the message
+// is a request of the server, such as a SET_PARAMETER, which is not supported.
+func (c *rtspClient) readMessage() (*rtspResponse, *interleavedFrame, error) {
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first[0] == '$' {
+		var header [4]byte
+		if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+			return nil, nil, err
+		}
+		data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+		if _, err = io.ReadFull(c.reader, data); err != nil {
+			return nil, nil, err
+		}
+		return nil, &interleavedFrame{channel: header[1], data: data}, nil
+	}
+
+	tp := textproto.NewReader(c.reader)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	var body []byte
+	if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+		if length > rtspMaxBodySize {
+			return nil, nil, fmt.Errorf("the RTSP message body of %d bytes is too large", length)
+		}
+		body = make([]byte, length)
+		if _, err = io.ReadFull(c.reader, body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	version, status, _ := strings.Cut(line, " ")
+	if !strings.HasPrefix(version, "RTSP/") {
+		return nil, nil, nil
+	}
+	code, reason, _ := strings.Cut(status, " ")
+	statusCode, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid RTSP status line '%s'", line)
+	}
+	return &rtspResponse{statusCode: statusCode, status: reason, header: header, body: body}, nil, nil
+}
+
+// keepAlive sends a GET_PARAMETER request to keep the session alive, its response is skipped by readPacket
+func (c *rtspClient) keepAlive() error {
+	return c.write("GET_PARAMETER", c.url, nil)
+}
+
+// close tears down the session and closes the connection, which unblocks a pending readPacket
+func (c *rtspClient) close() {
+	c.mu.Lock()
+	playing := c.session != ""
+	c.mu.Unlock()
+	if playing {
+		_ = c.write("TEARDOWN", c.url, nil)
+	}
+	_ = c.conn.Close()
+}
+
+// parseRTPPacket parses an RTP packet as specified by RFC 3550
+func parseRTPPacket(data []byte) (rtpPacket, error) {
+	if len(data) < 12 || data[0]>>6 != 2 {
+		return rtpPacket{}, fmt.Errorf("invalid RTP packet")
+	}
+	offset := 12 + 4*int(data[0]&0x0f)
+	if data[0]&0x10 != 0 {
+		if len(data) < offset+4 {
+			return rtpPacket{}, fmt.Errorf("invalid RTP header extension")
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:]))
+	}
+	end := len(data)
+	if data[0]&0x20 != 0 && end > 0 {
+		end -= int(data[end-1])
+	}
+	if offset > end {
+		return rtpPacket{}, fmt.Errorf("invalid RTP packet length")
+	}
+	return rtpPacket{
+		marker:   data[1]&0x80 != 0,
+		sequence: binary.BigEndian.Uint16(data[2:]),
+		payload:  data[offset:end],
+	}, nil
+}
+
+// parseSDP returns the session control and the media descriptions of an SDP session
+func parseSDP(data []byte) (string, []sdpMedia) {
+	var sessionControl string
+	var medias []sdpMedia
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "m":
+			mediaType, _, _ := strings.Cut(value, " ")
+			medias = append(medias, sdpMedia{mediaType: mediaType})
+		case key == "a" && strings.HasPrefix(value, "control:"):
+			control := strings.TrimPrefix(value, "control:")
+			if len(medias) == 0 {
+				sessionControl = control
+			} else {
+				medias[len(medias)-1].control = control
+			}
+		case key == "a" && strings.HasPrefix(value, "rtpmap:") && len(medias) > 0:
+			// e.g. rtpmap:107 vnd.onvif.metadata/90000
+			fields := strings.Fields(strings.TrimPrefix(value, "rtpmap:"))
+			if len(fields) > 1 {
+				medias[len(medias)-1].encoding, _, _ = strings.Cut(fields[1], "/")
+			}
+		}
+	}
+	return sessionControl, medias
+}
+
+// resolveRTSPControl returns the uri of a control attribute, which is either absolute or relative to the base uri
+func resolveRTSPControl(base, control string) string {
+	switch {
+	case control == "" || control == "*":
+		return base
+	case strings.HasPrefix(strings.ToLower(control), "rtsp://"):
+		return control
+	case strings.HasSuffix(base, "/"):
+		return base + control
+	default:
+		return base + "/" + control
+	}
+}
+
+// interleavedChannel returns the RTP channel of the Transport header of the SETUP response
+func interleavedChannel(transport string) byte {
+	for _, part := range strings.Split(transport, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if key != "interleaved" {
+			continue
+		}
+		first, _, _ := strings.Cut(value, "-")
+		if channel, err := strconv.ParseUint(first, 10, 8); err == nil {
+			return byte(channel)
+		}
+	}
+	return 0
+}
+
+// parseRTSPAuthenticate returns the authentication challenge of the WWW-Authenticate headers, preferring Digest
+func parseRTSPAuthenticate(values []string) *rtspAuth {
+	auth := &rtspAuth{}
+	for _, value := range values {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(value), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		auth.digest = true
+		for key, value := range parseAuthParams(params) {
+			switch strings.ToLower(key) {
+			case "realm":
+				auth.realm = value
+			case "nonce":
+				auth.nonce = value
+			case "opaque":
+				auth.opaque = value
+			case "algorithm":
+				auth.algorithm = value
+			case "qop":
+				// only the auth quality of protection is supported
+				for _, qop := range strings.Split(value, ",") {
+					if strings.TrimSpace(qop) == "auth" {
+						auth.qop = "auth"
+					}
+				}
+			}
+		}
+		break
+	}
+	return auth
+}
+
+// parseAuthParams parses the comma separated parameters of a challenge, whose values may be quoted
+func parseAuthParams(params string) map[string]string {
+	result := make(map[string]string)
+	for params = strings.TrimSpace(params); params != ""; params = strings.TrimSpace(params) {
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+		rest = strings.TrimSpace(rest)
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+1:]
+			if len(rest) > 0 {
+				rest = rest[1:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		result[key] = value
+		params = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return result
+}
+
+// authorization returns the Authorization header of a request
+func (auth *rtspAuth) authorization(method, uri, username, password string) string {
+	if !auth.digest {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	ha1 := md5Hex(username + ":" + auth.realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, username, auth.realm, auth.nonce, uri)
+	if auth.qop != "" {
+		auth.nc++
+		nc := fmt.Sprintf("%08x", auth.nc)
+		cnonce := newCnonce()
+		response := md5Hex(ha1 + ":" + auth.nonce + ":" + nc + ":" + cnonce + ":" + auth.qop + ":" + ha2)
+		header += fmt.Sprintf(`, response="%s", qop=%s, nc=%s, cnonce="%s"`, response, auth.qop, nc, cnonce)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+auth.nonce+":"+ha2))
+	}
+	if auth.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, auth.opaque)
+	}
+	if auth.algorithm != "" {
+		header += fmt.Sprintf(`, algorithm=%s`, auth.algorithm)
+	}
+	return header
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func newCnonce() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
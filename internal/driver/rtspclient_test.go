@@ -0,0 +1,257 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRTSPSessionDescription = `v=0
+o=- 0 0 IN IP4 127.0.0.1
+s=Onvif Stream
+t=0 0
+a=control:*
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=control:trackID=1
+m=application 0 RTP/AVP 107
+a=rtpmap:107 vnd.onvif.metadata/90000
+a=control:trackID=2
+`
+
+// testRTSPServer is a local stand-in for the RTSP server of a camera. It requires Digest authentication, describes
+// a video and a metadata track, and sends the documents split across RTP packets when the stream is played.
+type testRTSPServer struct {
+	t         *testing.T
+	listener  net.Listener
+	username  string
+	password  string
+	documents []string
+	// packetSize is the maximum payload size of the RTP packets
+	packetSize int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestRTSPServer(t *testing.T, username, password string, documents ...string) *testRTSPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &testRTSPServer{t: t, listener: listener, username: username, password: password, documents: documents, packetSize: 64}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *testRTSPServer) uri() string {
+	return fmt.Sprintf("rtsp://%s/onvif/stream", server.listener.Addr())
+}
+
+// requested returns the requests received by the server, as the method and the uri
+func (server *testRTSPServer) requested() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]string(nil), server.requests...)
+}
+
+func (server *testRTSPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return
+		}
+		method, uri := fields[0], fields[1]
+		cseq := header.Get("CSeq")
+
+		if !server.authorized(method, uri, header.Get("Authorization")) {
+			fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nWWW-Authenticate: Basic realm=\"camera\"\r\nWWW-Authenticate: Digest realm=\"camera\", nonce=\"abc123\", qop=\"auth\"\r\n\r\n", cseq)
+			continue
+		}
+		server.mu.Lock()
+		server.requests = append(server.requests, method+" "+uri)
+		server.mu.Unlock()
+
+		switch method {
+		case "DESCRIBE":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Base: %s/\r\nContent-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s",
+				cseq, server.uri(), len(testRTSPSessionDescription), testRTSPSessionDescription)
+		case "SETUP":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: 12345678;timeout=2\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n\r\n", cseq)
+		case "PLAY":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: 12345678\r\n\r\n", cseq)
+			server.sendDocuments(conn)
+		case "TEARDOWN":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\n\r\n", cseq)
+			return
+		default:
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\n\r\n", cseq)
+		}
+	}
+}
+
+// authorized validates the Digest authorization of a request
+func (server *testRTSPServer) authorized(method, uri, authorization string) bool {
+	if server.username == "" {
+		return true
+	}
+	scheme, params, _ := strings.Cut(authorization, " ")
+	if scheme != "Digest" {
+		return false
+	}
+	values := parseAuthParams(params)
+	ha1 := md5Hex(server.username + ":camera:" + server.password)
+	ha2 := md5Hex(method + ":" + uri)
+	expected := md5Hex(ha1 + ":abc123:" + values["nc"] + ":" + values["cnonce"] + ":auth:" + ha2)
+	return values["username"] == server.username && values["uri"] == uri && values["response"] == expected
+}
+
+// sendDocuments sends the documents on the interleaved channel 0, with an RTCP packet on channel 1 before them
+func (server *testRTSPServer) sendDocuments(conn net.Conn) {
+	_, _ = conn.Write(interleave(1, []byte{0x80, 200, 0, 6, 0, 0, 0, 0}))
+	sequence := uint16(1000)
+	for _, document := range server.documents {
+		for offset := 0; offset < len(document); offset += server.packetSize {
+			end := offset + server.packetSize
+			if end > len(document) {
+				end = len(document)
+			}
+			_, _ = conn.Write(interleave(0, newTestRTPPacket(sequence, end == len(document), []byte(document[offset:end]))))
+			sequence++
+		}
+	}
+}
+
+func interleave(channel byte, data []byte) []byte {
+	frame := []byte{'$', channel, 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+	return append(frame, data...)
+}
+
+func newTestRTPPacket(sequence uint16, marker bool, payload []byte) []byte {
+	packet := make([]byte, 12, 12+len(payload))
+	packet[0] = 0x80
+	packet[1] = 107
+	if marker {
+		packet[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(packet[2:], sequence)
+	binary.BigEndian.PutUint32(packet[8:], 0x1234)
+	return append(packet, payload...)
+}
+
+func TestRTSPClient_playTrack(t *testing.T) {
+	document := `<?xml version="1.0" encoding="UTF-8"?><tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema"><tt:VideoAnalytics><tt:Frame UtcTime="2023-09-21T08:00:00Z"/></tt:VideoAnalytics></tt:MetadataStream>`
+	server := newTestRTSPServer(t, "admin", "password", document)
+
+	client, err := dialRTSP(server.uri(), "admin", "password", 2*time.Second)
+	require.NoError(t, err)
+	defer client.close()
+	require.NoError(t, client.playTrack(onvifMetadataEncoding))
+	assert.Equal(t, time.Second, client.keepAliveInterval())
+
+	assembler := metadataAssembler{}
+	var received []byte
+	for received == nil {
+		packet, err := client.readPacket(2 * time.Second)
+		require.NoError(t, err)
+		if data, ok := assembler.push(packet); ok {
+			received = data
+		}
+	}
+	assert.Equal(t, document, string(received))
+
+	// only the metadata track is set up, the video is not streamed
+	assert.Equal(t, []string{
+		"DESCRIBE " + server.uri(),
+		"SETUP " + server.uri() + "/trackID=2",
+		"PLAY " + server.uri() + "/",
+	}, server.requested())
+}
+
+func TestRTSPClient_unauthorized(t *testing.T) {
+	server := newTestRTSPServer(t, "admin", "password")
+
+	// the credentials of the uri take precedence
+	uri := strings.Replace(server.uri(), "rtsp://", "rtsp://admin:wrong@", 1)
+	client, err := dialRTSP(uri, "admin", "password", 2*time.Second)
+	require.NoError(t, err)
+	defer client.close()
+	err = client.playTrack(onvifMetadataEncoding)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+func TestParseSDP(t *testing.T) {
+	sessionControl, medias := parseSDP([]byte(strings.ReplaceAll(testRTSPSessionDescription, "\n", "\r\n")))
+	assert.Equal(t, "*", sessionControl)
+	assert.Equal(t, []sdpMedia{
+		{mediaType: "video", encoding: "H264", control: "trackID=1"},
+		{mediaType: "application", encoding: "vnd.onvif.metadata", control: "trackID=2"},
+	}, medias)
+}
+
+func TestResolveRTSPControl(t *testing.T) {
+	tests := []struct {
+		base     string
+		control  string
+		expected string
+	}{
+		{base: "rtsp://camera/stream", control: "*", expected: "rtsp://camera/stream"},
+		{base: "rtsp://camera/stream", control: "", expected: "rtsp://camera/stream"},
+		{base: "rtsp://camera/stream", control: "trackID=2", expected: "rtsp://camera/stream/trackID=2"},
+		{base: "rtsp://camera/stream/", control: "trackID=2", expected: "rtsp://camera/stream/trackID=2"},
+		{base: "rtsp://camera/stream", control: "rtsp://camera/metadata", expected: "rtsp://camera/metadata"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, resolveRTSPControl(test.base, test.control), test.control)
+	}
+}
+
+func TestParseRTPPacket(t *testing.T) {
+	// a packet with a CSRC, a header extension and padding
+	data := []byte{0xb1, 0x80 | 107, 0x03, 0xe8, 0, 0, 0, 0, 0, 0, 0x12, 0x34, 0, 0, 0, 1, 0xbe, 0xde, 0, 1, 1, 2, 3, 4}
+	data = append(data, []byte("<xml/>")...)
+	data = append(data, 0, 0, 3)
+
+	packet, err := parseRTPPacket(data)
+	require.NoError(t, err)
+	assert.True(t, packet.marker)
+	assert.Equal(t, uint16(1000), packet.sequence)
+	assert.Equal(t, "<xml/>", string(packet.payload))
+
+	_, err = parseRTPPacket([]byte{0x80, 107})
+	require.Error(t, err)
+}
@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	GetSubscriptionHealth = "GetSubscriptionHealth"
+
+	// SubscriptionActive indicates the subscription is receiving events from the camera
+	SubscriptionActive = "Active"
+	// SubscriptionRecovering indicates the last request for the subscription failed, and it is being retried
+	SubscriptionRecovering = "Recovering"
+	// SubscriptionPaused indicates the subscription is paused during the camera's maintenance window
+	SubscriptionPaused = "Paused"
+
+	// maxEventGaps is the number of event gaps recorded per subscription, the oldest gaps are merged beyond it
+	maxEventGaps = 16
+)
+
+// SubscriptionHealth is the health of an event subscription, returned by the GetSubscriptionHealth command
+type SubscriptionHealth struct {
+	State string
+	// LastMessageTime is the RFC 3339 time at which the last notification message was received
+	LastMessageTime string `json:",omitempty"`
+	// MessageCount is the number of notification messages received since the subscription was created
+	MessageCount uint64
+	// ErrorCount is the number of failed requests since the subscription was created
+	ErrorCount uint64
+	LastError  string `json:",omitempty"`
+	// EventGaps are the periods during which the events of the subscription may have been lost, which are replayed
+	// by the ReplayEvents command
+	EventGaps []EventGap `json:",omitempty"`
+}
+
+// EventGap is the RFC 3339 time range between the last message received before a subscription failed and its recovery
+type EventGap struct {
+	Start string
+	End   string
+}
+
+type eventGap struct {
+	start time.Time
+	end   time.Time
+}
+
+// subscriptionHealth tracks the health of a subscription, it is safe for concurrent use
+type subscriptionHealth struct {
+	mu           sync.Mutex
+	state        string
+	lastMessage  time.Time
+	messageCount uint64
+	errorCount   uint64
+	lastError    string
+	// gapStart is the start of the current gap while the subscription is failing, or the zero time
+	gapStart time.Time
+	gaps     []eventGap
+}
+
+func (health *subscriptionHealth) setState(state string) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.state = state
+	if state == SubscriptionActive {
+		health.closeGap(time.Now())
+	}
+}
+
+// succeeded marks the subscription as active, and records the time of the last message if messages were received
+func (health *subscriptionHealth) succeeded(messages int, now time.Time) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.state = SubscriptionActive
+	health.closeGap(now)
+	if messages > 0 {
+		health.lastMessage = now
+		health.messageCount += uint64(messages)
+	}
+}
+
+func (health *subscriptionHealth) failed(err errors.EdgeX) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.state = SubscriptionRecovering
+	health.errorCount++
+	health.lastError = err.Error()
+	if health.gapStart.IsZero() {
+		// the events may have been lost since the last message, or since the failure if no message was received
+		health.gapStart = health.lastMessage
+		if health.gapStart.IsZero() {
+			health.gapStart = time.Now()
+		}
+	}
+}
+
+// closeGap records the current gap when the subscription recovers, the caller must hold the lock
+func (health *subscriptionHealth) closeGap(now time.Time) {
+	if health.gapStart.IsZero() {
+		return
+	}
+	if now.After(health.gapStart) {
+		health.addGaps([]eventGap{{start: health.gapStart, end: now}})
+	}
+	health.gapStart = time.Time{}
+}
+
+// addGaps appends the gaps in order, merging the oldest gaps beyond maxEventGaps, the caller must hold the lock
+func (health *subscriptionHealth) addGaps(gaps []eventGap) {
+	health.gaps = append(health.gaps, gaps...)
+	sort.Slice(health.gaps, func(i, j int) bool {
+		return health.gaps[i].start.Before(health.gaps[j].start)
+	})
+	for len(health.gaps) > maxEventGaps {
+		health.gaps[1].start = health.gaps[0].start
+		health.gaps = health.gaps[1:]
+	}
+}
+
+// lastMessageTime returns the time at which the last message was received, or the zero time if there is none
+func (health *subscriptionHealth) lastMessageTime() time.Time {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.lastMessage
+}
+
+// takeGaps returns the recorded gaps and clears them, so that each gap is replayed once
+func (health *subscriptionHealth) takeGaps() []eventGap {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	gaps := health.gaps
+	health.gaps = nil
+	return gaps
+}
+
+// restoreGaps records the gaps again after they could not be replayed
+func (health *subscriptionHealth) restoreGaps(gaps []eventGap) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.addGaps(gaps)
+}
+
+func (health *subscriptionHealth) snapshot() SubscriptionHealth {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	result := SubscriptionHealth{
+		State:        health.state,
+		MessageCount: health.messageCount,
+		ErrorCount:   health.errorCount,
+		LastError:    health.lastError,
+	}
+	if result.State == "" {
+		result.State = SubscriptionActive
+	}
+	if !health.lastMessage.IsZero() {
+		result.LastMessageTime = health.lastMessage.UTC().Format(time.RFC3339)
+	}
+	for _, gap := range health.gaps {
+		result.EventGaps = append(result.EventGaps, EventGap{Start: gap.start.UTC().Format(time.RFC3339), End: gap.end.UTC().Format(time.RFC3339)})
+	}
+	return result
+}
+
+// callGetSubscriptionHealthFunction returns the health of the camera's event subscriptions by resource name
+func (onvifClient *OnvifClient) callGetSubscriptionHealthFunction(resourceName string) (*sdkModel.CommandValue, errors.EdgeX) {
+	result := make(map[string]SubscriptionHealth)
+	if onvifClient.pullPointManager != nil {
+		for name, health := range onvifClient.pullPointManager.health() {
+			result[name] = health
+		}
+	}
+	if onvifClient.baseNotificationManager != nil {
+		for name, health := range onvifClient.baseNotificationManager.health() {
+			result[name] = health
+		}
+	}
+	cv, err := sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, result)
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the function '%s'", GetSubscriptionHealth), err)
+	}
+	return cv, nil
+}
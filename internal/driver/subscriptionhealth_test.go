@@ -0,0 +1,234 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IOTechSystems/onvif"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testEmptyPullMessagesResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tev="http://www.onvif.org/ver10/events/wsdl">
+  <env:Body>
+    <tev:PullMessagesResponse>
+      <tev:CurrentTime>2023-09-21T08:00:05Z</tev:CurrentTime>
+      <tev:TerminationTime>2023-09-21T09:00:05Z</tev:TerminationTime>
+    </tev:PullMessagesResponse>
+  </env:Body>
+</env:Envelope>`
+
+func TestSubscriptionHealth(t *testing.T) {
+	health := subscriptionHealth{}
+	assert.Equal(t, SubscriptionHealth{State: SubscriptionActive}, health.snapshot())
+
+	health.failed(errors.NewCommonEdgeX(errors.KindServerError, "timeout", nil))
+	health.failed(errors.NewCommonEdgeX(errors.KindServerError, "connection refused", nil))
+	snapshot := health.snapshot()
+	assert.Equal(t, SubscriptionRecovering, snapshot.State)
+	assert.Equal(t, uint64(2), snapshot.ErrorCount)
+	assert.Equal(t, "connection refused", snapshot.LastError)
+	assert.Empty(t, snapshot.LastMessageTime)
+
+	now := time.Date(2023, 9, 21, 8, 0, 5, 0, time.UTC)
+	health.succeeded(0, now)
+	assert.Empty(t, health.snapshot().LastMessageTime)
+	health.succeeded(2, now)
+	snapshot = health.snapshot()
+	assert.Equal(t, SubscriptionActive, snapshot.State)
+	assert.Equal(t, "2023-09-21T08:00:05Z", snapshot.LastMessageTime)
+	assert.Equal(t, uint64(2), snapshot.ErrorCount, "the error count is kept after recovering")
+}
+
+func TestSubscriber_pullMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		body         string
+		expectedKind errors.ErrKind
+	}{
+		{
+			name:       "no messages",
+			statusCode: http.StatusOK,
+			body:       testEmptyPullMessagesResponse,
+		},
+		{
+			name:         "pull point not found",
+			statusCode:   http.StatusNotFound,
+			expectedKind: errors.KindEntityDoesNotExist,
+		},
+		{
+			name:         "invalid response",
+			statusCode:   http.StatusOK,
+			body:         "invalid",
+			expectedKind: errors.KindServerError,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			driver, _ := createDriverWithMockService()
+			client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+			request := &SubscriptionRequest{}
+			sub := &Subscriber{
+				Name:                CameraEvent,
+				manager:             newPullPointManager(logger.NewMockClient()),
+				onvifClient:         client,
+				onvifDevice:         mockDevice,
+				SubscriptionAddress: "http://192.168.1.10/onvif/PullPoint",
+				subscriptionRequest: request,
+			}
+			mockDevice.On("SendSoap", sub.SubscriptionAddress, mock.Anything).Return(&http.Response{
+				StatusCode: test.statusCode,
+				Body:       io.NopCloser(strings.NewReader(test.body)),
+			}, nil).Once()
+
+			edgexErr := sub.pullMessage()
+			if test.expectedKind != "" {
+				require.Error(t, edgexErr)
+				assert.Equal(t, test.expectedKind, errors.Kind(edgexErr))
+				return
+			}
+			require.NoError(t, edgexErr)
+			assert.Equal(t, SubscriptionActive, sub.health.snapshot().State)
+		})
+	}
+}
+
+func TestPullPointManager_UnsubscribeAllTerminated(t *testing.T) {
+	manager := newPullPointManager(logger.NewMockClient())
+	sub := &Subscriber{Name: CameraEvent, manager: manager, Stopped: make(chan bool), done: make(chan struct{})}
+	manager.addSubscriber(sub)
+	// the loop of a terminated subscription has exited, so nobody receives the Stopped signal
+	close(sub.done)
+
+	finished := make(chan struct{})
+	go func() {
+		manager.UnsubscribeAll()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("UnsubscribeAll blocked on a terminated subscription")
+	}
+}
+
+// TestPullPointManager_UpdateRequest verifies that the pull point is re-created by the pull message loop
+func TestPullPointManager_UpdateRequest(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	// the loop is paused by the maintenance window, so the pull point is re-created when the window ends
+	client.maintenanceUntil = time.Now().Add(time.Hour)
+	manager := newPullPointManager(logger.NewMockClient())
+	sub := &Subscriber{
+		Name:                CameraEvent,
+		manager:             manager,
+		onvifClient:         client,
+		onvifDevice:         mockDevice,
+		subscriptionRequest: &SubscriptionRequest{},
+		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+		recreateRequests:    make(chan chan errors.EdgeX, 1),
+	}
+	mockDevice.On("SendSoap", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("unavailable"))
+	manager.addSubscriber(sub)
+	go sub.StartPullMessageLoop()
+	defer manager.UnsubscribeAll()
+
+	topicFilter := testMotionTopic
+	updated := &SubscriptionRequest{TopicFilter: &topicFilter}
+	result := make(chan errors.EdgeX)
+	go func() {
+		_, edgexErr := manager.UpdateRequest(CameraEvent, updated)
+		result <- edgexErr
+	}()
+	select {
+	case edgexErr := <-result:
+		require.NoError(t, edgexErr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pull message loop did not handle the request to re-create the pull point")
+	}
+	assert.Equal(t, updated, sub.request())
+	mockDevice.AssertNotCalled(t, "SendSoap", mock.Anything, mock.Anything)
+}
+
+// TestBaseNotificationManager_UpdateRequest verifies that the subscription is re-created by the renew loop
+func TestBaseNotificationManager_UpdateRequest(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	// the loop is paused by the maintenance window, so the subscription is re-created when the window ends
+	client.maintenanceUntil = time.Now().Add(time.Hour)
+	manager := NewBaseNotificationManager(logger.NewMockClient())
+	consumer := &Consumer{
+		Name:                CameraEvent,
+		lc:                  client.lc,
+		manager:             manager,
+		onvifClient:         client,
+		subscriptionRequest: &SubscriptionRequest{},
+		terminationDeadline: time.Now().Add(time.Hour),
+		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+		resubscribeRequests: make(chan chan errors.EdgeX, 1),
+	}
+	mockDevice.On("SendSoap", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("unavailable"))
+	manager.addConsumer(consumer)
+	go consumer.StartRenewLoop()
+	defer manager.UnsubscribeAll()
+
+	topicFilter := testMotionTopic
+	updated := &SubscriptionRequest{TopicFilter: &topicFilter}
+	result := make(chan errors.EdgeX)
+	go func() {
+		_, edgexErr := manager.UpdateRequest(CameraEvent, updated)
+		result <- edgexErr
+	}()
+	select {
+	case edgexErr := <-result:
+		require.NoError(t, edgexErr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the renew loop did not handle the request to re-create the subscription")
+	}
+	assert.Equal(t, updated, consumer.request())
+	mockDevice.AssertNotCalled(t, "SendSoap", mock.Anything, mock.Anything)
+}
+
+// TestBaseNotificationManager_NewConsumer verifies that the consumer accepts the notifications while the camera is
+// subscribed, and is removed if the subscription fails
+func TestBaseNotificationManager_NewConsumer(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	manager := NewBaseNotificationManager(logger.NewMockClient())
+	client.baseNotificationManager = manager
+	mockDevice.On("GetDeviceParams").Return(onvif.DeviceParams{Xaddr: "192.168.1.10:80"})
+	mockDevice.On("GetEndpointByRequestStruct", mock.Anything).Run(func(mock.Arguments) {
+		assert.Len(t, manager.listConsumers(), 1, "the consumer should be registered while the camera is subscribed")
+	}).Return("", fmt.Errorf("unavailable"))
+
+	terminationTime := "PT1H"
+	request := &SubscriptionRequest{InitialTerminationTime: &terminationTime}
+	edgexErr := manager.NewConsumer(client, CameraEvent, request)
+	require.Error(t, edgexErr)
+	mockDevice.AssertCalled(t, "GetEndpointByRequestStruct", mock.Anything)
+	assert.Empty(t, manager.listConsumers())
+
+	// the name can be subscribed again
+	edgexErr = manager.NewConsumer(client, CameraEvent, request)
+	require.Error(t, edgexErr)
+	assert.NotEqual(t, errors.KindDuplicateName, errors.Kind(edgexErr))
+}
@@ -0,0 +1,157 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IOTechSystems/onvif/event"
+	"github.com/IOTechSystems/onvif/gosoap"
+	"github.com/IOTechSystems/onvif/xsd"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	// renewMargin is the time before the termination time of a subscription at which it is renewed
+	renewMargin = 10 * time.Second
+	// minRenewInterval prevents the device service from flooding the camera with Renew requests when the
+	// remaining lifetime of a subscription is shorter than the renewMargin
+	minRenewInterval = time.Second
+
+	// retryInitialInterval and retryMaxInterval bound the exponential backoff between the attempts to recover a subscription
+	retryInitialInterval = time.Second
+	retryMaxInterval     = time.Minute
+)
+
+// renewResponseEnvelope is the minimal representation of the response to a Renew request. The RenewResponse of
+// the onvif library can not be unmarshalled because of the namespace prefixes in its field tags.
+type renewResponseEnvelope struct {
+	Body struct {
+		Fault         *gosoap.SOAPFault
+		RenewResponse struct {
+			TerminationTime string
+			CurrentTime     string
+		}
+	}
+}
+
+// retryBackoff returns the delay before the specified retry attempt, starting from one
+func retryBackoff(attempt int) time.Duration {
+	delay := retryInitialInterval
+	for i := 1; i < attempt && delay < retryMaxInterval; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxInterval {
+		delay = retryMaxInterval
+	}
+	return delay
+}
+
+// parseXsdDateTime parses an xsd:dateTime value, which is in UTC when the time zone is omitted
+func parseXsdDateTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05.999999999", value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// terminationDeadline returns the local time at which a subscription terminates. The termination time reported by the
+// camera is honored, and it is corrected by the camera's current time when available, so that the deadline is not
+// affected by the difference between the clocks. The requested lifetime is used if the camera does not report it.
+func terminationDeadline(now time.Time, currentTime, terminationTime string, requested time.Duration) time.Time {
+	termination, ok := parseXsdDateTime(terminationTime)
+	if !ok {
+		return now.Add(requested)
+	}
+	if current, ok := parseXsdDateTime(currentTime); ok {
+		return now.Add(termination.Sub(current))
+	}
+	return termination
+}
+
+// renewDelay returns the delay until a subscription with the specified deadline should be renewed
+func renewDelay(now, deadline time.Time) time.Duration {
+	delay := deadline.Sub(now) - renewMargin
+	if delay < minRenewInterval {
+		delay = minRenewInterval
+	}
+	return delay
+}
+
+// sendRenew renews the subscription at the specified address, and returns the current and termination time reported
+// by the camera, which are empty if the camera does not report them
+func sendRenew(device OnvifDevice, address, terminationTime string) (string, string, errors.EdgeX) {
+	requestBody, err := xml.Marshal(&event.Renew{TerminationTime: xsd.String(terminationTime)})
+	if err != nil {
+		return "", "", errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the renew request", err)
+	}
+	servResp, err := device.SendSoap(address, string(requestBody))
+	if err != nil {
+		return "", "", errors.NewCommonEdgeX(errors.KindServiceUnavailable, "failed to send the renew request", err)
+	}
+	defer servResp.Body.Close()
+
+	rsp, err := io.ReadAll(servResp.Body)
+	if err != nil {
+		return "", "", errors.NewCommonEdgeX(errors.KindServerError, "failed to read the renew response", err)
+	}
+	response := renewResponseEnvelope{}
+	if err = xml.Unmarshal(rsp, &response); err != nil && servResp.StatusCode < http.StatusBadRequest {
+		return "", "", errors.NewCommonEdgeX(errors.KindServerError, "failed to parse the renew response", err)
+	}
+	if response.Body.Fault != nil {
+		return "", "", errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("status code: %d, %s", servResp.StatusCode, response.Body.Fault.String()), nil)
+	}
+	if servResp.StatusCode >= http.StatusBadRequest {
+		return "", "", errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("status code: %d", servResp.StatusCode), nil)
+	}
+	return response.Body.RenewResponse.CurrentTime, response.Body.RenewResponse.TerminationTime, nil
+}
+
+// sendUnsubscribe terminates the subscription at the specified address
+func sendUnsubscribe(device OnvifDevice, address string) errors.EdgeX {
+	requestBody, err := xml.Marshal(event.Unsubscribe{})
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the unsubscribe request", err)
+	}
+	servResp, err := device.SendSoap(address, string(requestBody))
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServiceUnavailable, fmt.Sprintf("failed to send the unsubscribe request to %s", address), err)
+	}
+	defer servResp.Body.Close()
+	if servResp.StatusCode >= http.StatusBadRequest {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to unsubscribe from %s, status code: %d", address, servResp.StatusCode), nil)
+	}
+	return nil
+}
+
+// sendSetSynchronizationPoint requests the subscription at the specified address to send the current state of the
+// properties which match its filter, which the camera reports as events with the Initialized property operation
+func sendSetSynchronizationPoint(device OnvifDevice, address string) errors.EdgeX {
+	requestBody, err := xml.Marshal(event.SetSynchronizationPoint{})
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the SetSynchronizationPoint request", err)
+	}
+	servResp, err := device.SendSoap(address, string(requestBody))
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServiceUnavailable, fmt.Sprintf("failed to send the SetSynchronizationPoint request to %s", address), err)
+	}
+	defer servResp.Body.Close()
+	if servResp.StatusCode >= http.StatusBadRequest {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to set the synchronization point of %s, status code: %d", address, servResp.StatusCode), nil)
+	}
+	return nil
+}
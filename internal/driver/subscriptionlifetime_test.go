@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, retryBackoff(1))
+	assert.Equal(t, 2*time.Second, retryBackoff(2))
+	assert.Equal(t, 8*time.Second, retryBackoff(4))
+	assert.Equal(t, time.Minute, retryBackoff(7))
+	assert.Equal(t, time.Minute, retryBackoff(100))
+}
+
+func TestTerminationDeadline(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		currentTime     string
+		terminationTime string
+		expected        time.Time
+	}{
+		{
+			name:            "camera clock ahead",
+			currentTime:     "2023-06-01T12:05:00Z",
+			terminationTime: "2023-06-01T12:06:00Z",
+			expected:        now.Add(time.Minute),
+		},
+		{
+			name:            "without current time",
+			terminationTime: "2023-06-01T12:00:30",
+			expected:        now.Add(30 * time.Second),
+		},
+		{
+			name:            "shorter than requested",
+			currentTime:     "2023-06-01T12:00:00.5+02:00",
+			terminationTime: "2023-06-01T12:00:20.5+02:00",
+			expected:        now.Add(20 * time.Second),
+		},
+		{
+			name:     "not reported",
+			expected: now.Add(10 * time.Minute),
+		},
+		{
+			name:            "invalid termination time",
+			terminationTime: "PT60S",
+			expected:        now.Add(10 * time.Minute),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			deadline := terminationDeadline(now, test.currentTime, test.terminationTime, 10*time.Minute)
+			assert.True(t, test.expected.Equal(deadline), "expected %v, got %v", test.expected, deadline)
+		})
+	}
+}
+
+func TestRenewDelay(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 50*time.Second, renewDelay(now, now.Add(time.Minute)))
+	assert.Equal(t, minRenewInterval, renewDelay(now, now.Add(5*time.Second)))
+	assert.Equal(t, minRenewInterval, renewDelay(now, now.Add(-time.Minute)))
+}
+
+func TestRenewResponseEnvelope(t *testing.T) {
+	response := `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2">
+  <SOAP-ENV:Body>
+    <wsnt:RenewResponse>
+      <wsnt:TerminationTime>2023-06-01T12:01:00Z</wsnt:TerminationTime>
+      <wsnt:CurrentTime>2023-06-01T12:00:00Z</wsnt:CurrentTime>
+    </wsnt:RenewResponse>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+	envelope := renewResponseEnvelope{}
+	require.NoError(t, xml.Unmarshal([]byte(response), &envelope))
+	assert.Nil(t, envelope.Body.Fault)
+	assert.Equal(t, "2023-06-01T12:01:00Z", envelope.Body.RenewResponse.TerminationTime)
+	assert.Equal(t, "2023-06-01T12:00:00Z", envelope.Body.RenewResponse.CurrentTime)
+}
@@ -0,0 +1,281 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v3/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+)
+
+const (
+	GetSubscriptions         = "GetSubscriptions"
+	CancelSubscription       = "CancelSubscription"
+	UpdateSubscriptionFilter = "UpdateSubscriptionFilter"
+	SetSynchronizationPoint  = "SetSynchronizationPoint"
+
+	// SubscriptionSuspended indicates the subscription is suspended while the device is locked
+	SubscriptionSuspended = "Suspended"
+)
+
+// SubscriptionInfo describes an event subscription of the camera, returned by the GetSubscriptions command
+type SubscriptionInfo struct {
+	// Name is the name of the subscription
+	Name          string
+	SubscribeType string
+	// Resource is the resource which receives all event readings of the subscription, if it is not routed by topic
+	Resource             string `json:",omitempty"`
+	TopicFilter          string `json:",omitempty"`
+	MessageContentFilter string `json:",omitempty"`
+	AutoRenew            bool
+	// SubscriptionAddress is the address of the subscription on the camera
+	SubscriptionAddress string `json:",omitempty"`
+	// TerminationTime is the RFC 3339 time at which the subscription terminates unless it is renewed
+	TerminationTime string `json:",omitempty"`
+	SubscriptionHealth
+}
+
+// SubscriptionNameRequest is the request body of the CancelSubscription and SetSynchronizationPoint commands
+type SubscriptionNameRequest struct {
+	// Name is the name of the subscription, which may be empty for SetSynchronizationPoint to apply to all subscriptions
+	Name string
+}
+
+// SubscriptionFilterRequest is the request body of the UpdateSubscriptionFilter command. The filters which are not
+// specified are unchanged, and an empty filter removes it.
+type SubscriptionFilterRequest struct {
+	// Name is the name of the subscription
+	Name                 string
+	TopicFilter          *string
+	MessageContentFilter *string
+}
+
+func newSubscriptionInfo(name, subscribeType string, request *SubscriptionRequest, address string, deadline time.Time, health SubscriptionHealth) SubscriptionInfo {
+	info := SubscriptionInfo{
+		Name:                name,
+		SubscribeType:       subscribeType,
+		SubscriptionAddress: address,
+		SubscriptionHealth:  health,
+	}
+	if request.Resource != nil {
+		info.Resource = *request.Resource
+	}
+	if request.TopicFilter != nil {
+		info.TopicFilter = *request.TopicFilter
+	}
+	if request.MessageContentFilter != nil {
+		info.MessageContentFilter = *request.MessageContentFilter
+	}
+	if request.AutoRenew != nil {
+		info.AutoRenew = *request.AutoRenew
+	}
+	if !deadline.IsZero() {
+		info.TerminationTime = deadline.UTC().Format(time.RFC3339)
+	}
+	return info
+}
+
+// subscriptions returns the active and suspended subscriptions of the camera, sorted by name
+func (onvifClient *OnvifClient) subscriptions() []SubscriptionInfo {
+	result := make([]SubscriptionInfo, 0)
+	if onvifClient.pullPointManager != nil {
+		result = append(result, onvifClient.pullPointManager.subscriptions()...)
+	}
+	if onvifClient.baseNotificationManager != nil {
+		result = append(result, onvifClient.baseNotificationManager.subscriptions()...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (onvifClient *OnvifClient) callGetSubscriptionsFunction(resourceName string) (*sdkModel.CommandValue, errors.EdgeX) {
+	cv, err := sdkModel.NewCommandValue(resourceName, common.ValueTypeObject, onvifClient.subscriptions())
+	if err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to create commandValue for the function '%s'", GetSubscriptions), err)
+	}
+	return cv, nil
+}
+
+// cancelSubscription unsubscribes a single subscription from the camera, and removes it from the persisted subscriptions.
+// A cancelled default subscription is not re-created until it is subscribed again.
+func (onvifClient *OnvifClient) cancelSubscription(data []byte) errors.EdgeX {
+	var request SubscriptionNameRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+	}
+	if request.Name == "" {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "the subscription Name is required", nil)
+	}
+
+	found := false
+	if onvifClient.pullPointManager != nil && onvifClient.pullPointManager.Unsubscribe(request.Name) {
+		found = true
+	}
+	if onvifClient.baseNotificationManager != nil && onvifClient.baseNotificationManager.Unsubscribe(request.Name) {
+		found = true
+	}
+	if !found {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("subscription '%s' not found for device '%s'", request.Name, onvifClient.deviceName()), nil)
+	}
+	onvifClient.lc.Infof("Cancelled the '%s' event subscription of device %s", request.Name, onvifClient.deviceName())
+
+	if edgexErr := onvifClient.driver.cancelSubscriptions(onvifClient.deviceName(), request.Name); edgexErr != nil {
+		onvifClient.lc.Warnf("Unable to remove the persisted '%s' subscription of device %s, %v", request.Name, onvifClient.deviceName(), edgexErr)
+	}
+	return nil
+}
+
+// setSynchronizationPoint requests the camera to send the current state of the properties, such as the alarm inputs,
+// the motion and the tamper state, to the named subscription or to all subscriptions if no name is specified
+func (onvifClient *OnvifClient) setSynchronizationPoint(data []byte) errors.EdgeX {
+	var request SubscriptionNameRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &request); err != nil {
+			return errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+		}
+	}
+
+	found := false
+	if onvifClient.pullPointManager != nil {
+		ok, edgexErr := onvifClient.pullPointManager.SetSynchronizationPoint(request.Name)
+		if edgexErr != nil {
+			return errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+		found = found || ok
+	}
+	if onvifClient.baseNotificationManager != nil {
+		ok, edgexErr := onvifClient.baseNotificationManager.SetSynchronizationPoint(request.Name)
+		if edgexErr != nil {
+			return errors.NewCommonEdgeXWrapper(edgexErr)
+		}
+		found = found || ok
+	}
+	if !found && request.Name != "" {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("active subscription '%s' not found for device '%s'", request.Name, onvifClient.deviceName()), nil)
+	}
+	if !found {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("no active subscription found for device '%s'", onvifClient.deviceName()), nil)
+	}
+	return nil
+}
+
+// updateSubscriptionFilter replaces the filters of a single subscription. Since the filters of a subscription can not
+// be modified on the camera, the subscription is re-created with the same name, keeping its health and counters.
+func (onvifClient *OnvifClient) updateSubscriptionFilter(data []byte) errors.EdgeX {
+	var request SubscriptionFilterRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "failed to unmarshal the json request body", err)
+	}
+	if request.Name == "" {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "the subscription Name is required", nil)
+	}
+	if request.TopicFilter == nil && request.MessageContentFilter == nil {
+		return errors.NewCommonEdgeX(errors.KindContractInvalid, "either TopicFilter or MessageContentFilter should be specified", nil)
+	}
+
+	subscribeType, current, ok := onvifClient.findSubscription(request.Name)
+	if !ok {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("subscription '%s' not found for device '%s'", request.Name, onvifClient.deviceName()), nil)
+	}
+	if request.TopicFilter != nil && strings.TrimSpace(*request.TopicFilter) != "" {
+		if catalog := onvifClient.topicValidator(); catalog != nil {
+			if edgexErr := catalog.validateTopicFilter(*request.TopicFilter); edgexErr != nil {
+				return errors.NewCommonEdgeXWrapper(edgexErr)
+			}
+		}
+	}
+	// the request is copied, since it is read concurrently by the subscription loop
+	updated := *current
+	if request.TopicFilter != nil {
+		updated.TopicFilter = request.TopicFilter
+	}
+	if request.MessageContentFilter != nil {
+		updated.MessageContentFilter = request.MessageContentFilter
+	}
+
+	var edgexErr errors.EdgeX
+	switch subscribeType {
+	case PullPoint:
+		ok, edgexErr = onvifClient.pullPointManager.UpdateRequest(request.Name, &updated)
+	case BaseNotification:
+		ok, edgexErr = onvifClient.baseNotificationManager.UpdateRequest(request.Name, &updated)
+	}
+	if !ok {
+		return errors.NewCommonEdgeX(errors.KindEntityDoesNotExist, fmt.Sprintf("subscription '%s' not found for device '%s'", request.Name, onvifClient.deviceName()), nil)
+	}
+
+	persistErr := onvifClient.driver.saveSubscription(onvifClient.deviceName(), request.Name, &persistedSubscription{SubscribeType: subscribeType, Request: &updated})
+	if persistErr != nil {
+		onvifClient.lc.Warnf("Unable to persist the '%s' subscription of device %s, %v", request.Name, onvifClient.deviceName(), persistErr)
+	}
+	if edgexErr != nil {
+		// the subscription loop keeps retrying with the updated filters
+		return errors.NewCommonEdgeX(errors.Kind(edgexErr), fmt.Sprintf("failed to re-create the '%s' subscription with the updated filters", request.Name), edgexErr)
+	}
+	return nil
+}
+
+// findSubscription returns the subscribe type and the request of the active or suspended subscription of the resource
+func (onvifClient *OnvifClient) findSubscription(resourceName string) (string, *SubscriptionRequest, bool) {
+	if onvifClient.pullPointManager != nil {
+		if request, ok := onvifClient.pullPointManager.request(resourceName); ok {
+			return PullPoint, request, true
+		}
+	}
+	if onvifClient.baseNotificationManager != nil {
+		if request, ok := onvifClient.baseNotificationManager.request(resourceName); ok {
+			return BaseNotification, request, true
+		}
+	}
+	return "", nil, false
+}
+
+// reserveSubscription reserves the name of a new subscription until the returned release function is called, so that
+// the concurrent requests can not create two subscriptions of the same name while the camera is being subscribed
+func (onvifClient *OnvifClient) reserveSubscription(name string) (func(), errors.EdgeX) {
+	onvifClient.subscriptionsMu.Lock()
+	defer onvifClient.subscriptionsMu.Unlock()
+	if subscribeType, _, ok := onvifClient.findSubscription(name); ok {
+		return nil, errors.NewCommonEdgeX(errors.KindDuplicateName, fmt.Sprintf("the %s subscription '%s' already exists", subscribeType, name), nil)
+	}
+	if onvifClient.pendingSubscriptions[name] {
+		return nil, errors.NewCommonEdgeX(errors.KindDuplicateName, fmt.Sprintf("the subscription '%s' is already being created", name), nil)
+	}
+	return onvifClient.reserveSubscriptionLocked(name), nil
+}
+
+// reserveSuspendedSubscription takes the request of a suspended subscription and reserves its name, so that the
+// subscription can not be created by another request while it is resumed
+func (onvifClient *OnvifClient) reserveSuspendedSubscription(name string, take func(name string) (*SubscriptionRequest, bool)) (*SubscriptionRequest, func(), bool) {
+	onvifClient.subscriptionsMu.Lock()
+	defer onvifClient.subscriptionsMu.Unlock()
+	request, ok := take(name)
+	if !ok {
+		return nil, nil, false
+	}
+	return request, onvifClient.reserveSubscriptionLocked(name), true
+}
+
+func (onvifClient *OnvifClient) reserveSubscriptionLocked(name string) func() {
+	if onvifClient.pendingSubscriptions == nil {
+		onvifClient.pendingSubscriptions = make(map[string]bool)
+	}
+	onvifClient.pendingSubscriptions[name] = true
+	return func() {
+		onvifClient.subscriptionsMu.Lock()
+		defer onvifClient.subscriptionsMu.Unlock()
+		delete(onvifClient.pendingSubscriptions, name)
+	}
+}
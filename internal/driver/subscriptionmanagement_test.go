@@ -0,0 +1,221 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func createOnvifClientWithSubscriptions(driver *Driver) *OnvifClient {
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+
+	topicFilter := "tns1:RuleEngine/CellMotionDetector/Motion"
+	autoRenew := true
+	client.pullPointManager.suspend("PullPointSubscription", &SubscriptionRequest{TopicFilter: &topicFilter, AutoRenew: &autoRenew})
+
+	consumer := &Consumer{
+		Name:                "BaseNotificationSubscription",
+		manager:             client.baseNotificationManager,
+		subscriptionRequest: &SubscriptionRequest{},
+		SubscriptionAddress: "http://192.168.1.10/onvif/Subscription?Idx=1",
+		terminationDeadline: time.Date(2023, 9, 21, 9, 0, 0, 0, time.UTC),
+		Stopped:             make(chan bool),
+		done:                make(chan struct{}),
+	}
+	consumer.health.succeeded(3, time.Date(2023, 9, 21, 8, 0, 0, 0, time.UTC))
+	client.baseNotificationManager.addConsumer(consumer)
+	return client
+}
+
+func TestOnvifClient_subscriptions(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+
+	subscriptions := client.subscriptions()
+	require.Len(t, subscriptions, 2)
+	assert.Equal(t, SubscriptionInfo{
+		Name:                "BaseNotificationSubscription",
+		SubscribeType:       BaseNotification,
+		SubscriptionAddress: "http://192.168.1.10/onvif/Subscription?Idx=1",
+		TerminationTime:     "2023-09-21T09:00:00Z",
+		SubscriptionHealth: SubscriptionHealth{
+			State:           SubscriptionActive,
+			LastMessageTime: "2023-09-21T08:00:00Z",
+			MessageCount:    3,
+		},
+	}, subscriptions[0])
+	assert.Equal(t, SubscriptionInfo{
+		Name:               "PullPointSubscription",
+		SubscribeType:      PullPoint,
+		TopicFilter:        "tns1:RuleEngine/CellMotionDetector/Motion",
+		AutoRenew:          true,
+		SubscriptionHealth: SubscriptionHealth{State: SubscriptionSuspended},
+	}, subscriptions[1])
+}
+
+func TestOnvifClient_cancelSubscription(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"PullPointSubscription":{"SubscribeType":"PullPoint","Request":{}},"BaseNotificationSubscription":{"SubscribeType":"BaseNotification","Request":{}}}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	var saved models.Device
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		saved = models.Device{Name: *update.Name, Protocols: dtos.ToProtocolModels(update.Protocols)}
+	}).Return(nil)
+
+	err := client.cancelSubscription([]byte(`{"Name": "PullPointSubscription"}`))
+	require.NoError(t, err)
+	assert.False(t, client.pullPointManager.hasSubscription("PullPointSubscription"))
+	assert.True(t, client.baseNotificationManager.hasSubscription("BaseNotificationSubscription"))
+
+	subscriptions, err := loadSubscriptions(saved)
+	require.NoError(t, err)
+	assert.NotContains(t, subscriptions, "PullPointSubscription")
+	assert.Contains(t, subscriptions, "BaseNotificationSubscription")
+
+	err = client.cancelSubscription([]byte(`{"Name": "PullPointSubscription"}`))
+	require.Error(t, err)
+	assert.Equal(t, errors.KindEntityDoesNotExist, errors.Kind(err))
+
+	err = client.cancelSubscription([]byte(`{}`))
+	require.Error(t, err)
+	assert.Equal(t, errors.KindContractInvalid, errors.Kind(err))
+}
+
+func TestOnvifClient_setSynchronizationPoint(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	for i, name := range []string{"MotionSubscription", "TamperSubscription"} {
+		client.baseNotificationManager.addConsumer(&Consumer{
+			Name:                name,
+			lc:                  client.lc,
+			onvifClient:         client,
+			manager:             client.baseNotificationManager,
+			subscriptionRequest: &SubscriptionRequest{},
+			SubscriptionAddress: fmt.Sprintf("http://192.168.1.10/onvif/Subscription?Idx=%d", i),
+			Stopped:             make(chan bool),
+			done:                make(chan struct{}),
+		})
+	}
+	isSynchronizationPoint := mock.MatchedBy(func(body string) bool { return strings.Contains(body, "tev:SetSynchronizationPoint") })
+	okResponse := func() *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}
+	}
+
+	mockDevice.On("SendSoap", "http://192.168.1.10/onvif/Subscription?Idx=1", isSynchronizationPoint).Return(okResponse(), nil).Once()
+	err := client.setSynchronizationPoint([]byte(`{"Name": "TamperSubscription"}`))
+	require.NoError(t, err)
+	mockDevice.AssertExpectations(t)
+
+	mockDevice.On("SendSoap", "http://192.168.1.10/onvif/Subscription?Idx=0", isSynchronizationPoint).Return(okResponse(), nil).Once()
+	mockDevice.On("SendSoap", "http://192.168.1.10/onvif/Subscription?Idx=1", isSynchronizationPoint).Return(okResponse(), nil).Once()
+	err = client.setSynchronizationPoint([]byte(`{}`))
+	require.NoError(t, err)
+	mockDevice.AssertExpectations(t)
+
+	mockDevice.On("SendSoap", "http://192.168.1.10/onvif/Subscription?Idx=0", isSynchronizationPoint).
+		Return(&http.Response{StatusCode: http.StatusBadRequest, Body: io.NopCloser(strings.NewReader(""))}, nil).Once()
+	err = client.setSynchronizationPoint([]byte(`{"Name": "MotionSubscription"}`))
+	require.Error(t, err)
+
+	err = client.setSynchronizationPoint([]byte(`{"Name": "Unknown"}`))
+	require.Error(t, err)
+	assert.Equal(t, errors.KindEntityDoesNotExist, errors.Kind(err))
+}
+
+func TestOnvifClient_updateSubscriptionFilter(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+
+	device := createTestDevice()
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	var saved models.Device
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		saved = models.Device{Name: *update.Name, Protocols: dtos.ToProtocolModels(update.Protocols)}
+	}).Return(nil)
+
+	previous, ok := client.pullPointManager.request("PullPointSubscription")
+	require.True(t, ok)
+
+	err := client.updateSubscriptionFilter([]byte(`{"Name": "PullPointSubscription", "MessageContentFilter": "boolean(//tt:SimpleItem[@Name=\"IsMotion\"])"}`))
+	require.NoError(t, err)
+
+	request, ok := client.pullPointManager.request("PullPointSubscription")
+	require.True(t, ok)
+	assert.NotSame(t, previous, request, "the request should be replaced rather than modified")
+	assert.Equal(t, "tns1:RuleEngine/CellMotionDetector/Motion", *request.TopicFilter, "unspecified filters are unchanged")
+	assert.Equal(t, `boolean(//tt:SimpleItem[@Name="IsMotion"])`, *request.MessageContentFilter)
+
+	subscriptions, err := loadSubscriptions(saved)
+	require.NoError(t, err)
+	require.Contains(t, subscriptions, "PullPointSubscription")
+	assert.Equal(t, PullPoint, subscriptions["PullPointSubscription"].SubscribeType)
+	assert.Equal(t, *request.MessageContentFilter, *subscriptions["PullPointSubscription"].Request.MessageContentFilter)
+
+	err = client.updateSubscriptionFilter([]byte(`{"Name": "PullPointSubscription"}`))
+	require.Error(t, err)
+	assert.Equal(t, errors.KindContractInvalid, errors.Kind(err))
+
+	err = client.updateSubscriptionFilter([]byte(`{"Name": "Unknown", "TopicFilter": ""}`))
+	require.Error(t, err)
+	assert.Equal(t, errors.KindEntityDoesNotExist, errors.Kind(err))
+}
+
+func TestOnvifClient_subscribe_named(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+	mockService.On("DeviceResource", testDeviceName, "Unknown").Return(models.DeviceResource{}, false)
+
+	// the names are unique across the subscribe types
+	err := client.subscribe("PullPointSubscription", persistedSubscription{SubscribeType: BaseNotification, Request: &SubscriptionRequest{}})
+	require.Error(t, err)
+	assert.Equal(t, errors.KindDuplicateName, errors.Kind(err))
+
+	target := "Unknown"
+	err = client.subscribe("Motion", persistedSubscription{SubscribeType: PullPoint, Request: &SubscriptionRequest{Resource: &target}})
+	require.Error(t, err)
+	assert.Equal(t, errors.KindContractInvalid, errors.Kind(err))
+}
+
+func TestOnvifClient_reserveSubscription(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+
+	_, err := client.reserveSubscription("PullPointSubscription")
+	require.Error(t, err)
+	assert.Equal(t, errors.KindDuplicateName, errors.Kind(err))
+
+	// the name is reserved across the subscribe types until it is released
+	release, err := client.reserveSubscription("Motion")
+	require.NoError(t, err)
+	err = client.subscribe("Motion", persistedSubscription{SubscribeType: BaseNotification, Request: &SubscriptionRequest{}})
+	require.Error(t, err)
+	assert.Equal(t, errors.KindDuplicateName, errors.Kind(err))
+	release()
+	release, err = client.reserveSubscription("Motion")
+	require.NoError(t, err)
+	release()
+}
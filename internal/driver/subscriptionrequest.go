@@ -11,8 +11,11 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/IOTechSystems/onvif/event"
+	"github.com/IOTechSystems/onvif/xsd"
 	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
 )
 
@@ -20,6 +23,16 @@ import (
 const MinimumInitialTerminationTime = 11
 
 type SubscriptionRequest struct {
+	// Name is the optional name of the subscription, which allows a resource to create several subscriptions with
+	// different filters. The name of the subscribing resource is used if it is not specified.
+	Name *string
+	// Resource is the optional name of the resource which receives all event readings of the subscription. If it is
+	// not specified, the readings are sent to the resources whose eventTopic attribute matches their topic, or to the
+	// CameraEvent resource.
+	Resource *string
+	// Snapshot indicates the snapshot of the event's video source is attached to the event readings of the subscription
+	Snapshot *bool
+
 	// AutoRenew indicate the device service should renew the subscription
 	AutoRenew *bool
 
@@ -38,18 +51,33 @@ type SubscriptionRequest struct {
 	MessageLimit *int
 }
 
-func newSubscriptionRequest(attributes map[string]interface{}, requestData []byte) (*SubscriptionRequest, errors.EdgeX) {
+// newSubscriptionRequest parses the subscription request, using the attributes of the resource as defaults. The
+// TopicFilter is validated with the topic catalog returned by topics, unless topics is nil or returns nil.
+func newSubscriptionRequest(attributes map[string]interface{}, requestData []byte, topics func() *eventTopicCatalog) (*SubscriptionRequest, errors.EdgeX) {
 	request := &SubscriptionRequest{}
 	err := json.Unmarshal(requestData, request)
 	if err != nil {
 		return nil, errors.NewCommonEdgeX(errors.KindServerError, "failed to unmarshal the json request body", err)
 	}
+	if request.Name != nil && strings.TrimSpace(*request.Name) == "" {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "the subscription Name should not be empty", nil)
+	}
+	if request.Resource != nil && strings.TrimSpace(*request.Resource) == "" {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "the subscription Resource should not be empty", nil)
+	}
 
 	topicFilter, ok := attributes[DefaultTopicFilter]
 	if request.TopicFilter == nil && ok {
 		val := fmt.Sprint(topicFilter)
 		request.TopicFilter = &val
 	}
+	if request.TopicFilter != nil && strings.TrimSpace(*request.TopicFilter) != "" && topics != nil {
+		if catalog := topics(); catalog != nil {
+			if edgexErr := catalog.validateTopicFilter(*request.TopicFilter); edgexErr != nil {
+				return nil, errors.NewCommonEdgeXWrapper(edgexErr)
+			}
+		}
+	}
 
 	messageContentFilter, ok := attributes[DefaultMessageContentFilter]
 	if request.MessageContentFilter == nil && ok {
@@ -62,7 +90,10 @@ func newSubscriptionRequest(attributes map[string]interface{}, requestData []byt
 		val := fmt.Sprint(initialTerminationTime)
 		request.InitialTerminationTime = &val
 	}
-	duration, err := ParseISO8601(*request.InitialTerminationTime)
+	if request.InitialTerminationTime == nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, "the InitialTerminationTime is required", nil)
+	}
+	duration, _, err := parseTerminationTime(*request.InitialTerminationTime, time.Now())
 	if err != nil {
 		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid initial terminationTime, %v", err), err)
 	}
@@ -90,6 +121,11 @@ func newSubscriptionRequest(attributes map[string]interface{}, requestData []byt
 		val := fmt.Sprint(messageTimeout)
 		request.MessageTimeout = &val
 	}
+	if request.MessageTimeout != nil {
+		if _, err := ParseISO8601(*request.MessageTimeout); err != nil {
+			return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid message timeout, %v", err), err)
+		}
+	}
 
 	messageLimit, ok := attributes[DefaultMessageLimit]
 	if request.MessageLimit == nil && ok {
@@ -102,16 +138,34 @@ func newSubscriptionRequest(attributes map[string]interface{}, requestData []byt
 	return request, nil
 }
 
-var pattern = regexp.MustCompile(`^P((?P<year>\d+)Y)?((?P<month>\d+)M)?((?P<week>\d+)W)?((?P<day>\d+)D)?(T((?P<hour>\d+)H)?((?P<minute>\d+)M)?((?P<second>\d+)S)?)?$`)
+// subscriptionName returns the name of the subscription created by the resource
+func (request *SubscriptionRequest) subscriptionName(resourceName string) string {
+	if request.Name != nil {
+		return *request.Name
+	}
+	return resourceName
+}
+
+// eventRoute returns the route of the event readings of the subscription
+func (request *SubscriptionRequest) eventRoute(defaultResourceName string) eventRoute {
+	snapshot := request.Snapshot != nil && *request.Snapshot
+	if request.Resource != nil {
+		return eventRoute{resourceName: *request.Resource, snapshot: snapshot}
+	}
+	return eventRoute{resourceName: defaultResourceName, byTopic: true, snapshot: snapshot}
+}
+
+var pattern = regexp.MustCompile(`^P((?P<year>\d+)Y)?((?P<month>\d+)M)?((?P<week>\d+)W)?((?P<day>\d+)D)?(T((?P<hour>\d+)H)?((?P<minute>\d+)M)?((?P<second>\d+([.,]\d+)?)S)?)?$`)
 
-// ParseISO8601 parses an ISO8601 duration string.
+// ParseISO8601 parses an ISO8601 duration string. The calendar components are converted with their nominal length,
+// a year is 365 days and a month is 30 days, and the seconds may have a decimal fraction, for example P1DT0.5S.
 // https://github.com/senseyeio/duration/blob/master/duration.go
 // https://en.wikipedia.org/wiki/ISO_8601#Durations
 func ParseISO8601(from string) (time.Duration, error) {
 	var match []string
 	var d Duration
 
-	if pattern.MatchString(from) {
+	if pattern.MatchString(from) && from != "P" && !strings.HasSuffix(from, "T") {
 		match = pattern.FindStringSubmatch(from)
 	} else {
 		return 0, errors.NewCommonEdgeX(errors.KindContractInvalid, "invalid time duration, the format shoulb be like 'PT180S' ", nil)
@@ -123,6 +177,14 @@ func ParseISO8601(from string) (time.Duration, error) {
 			continue
 		}
 
+		if name == "second" {
+			val, err := strconv.ParseFloat(strings.Replace(part, ",", ".", 1), 64)
+			if err != nil {
+				return 0, err
+			}
+			d.TS = val
+			continue
+		}
 		val, err := strconv.Atoi(part)
 		if err != nil {
 			return 0, err
@@ -140,8 +202,6 @@ func ParseISO8601(from string) (time.Duration, error) {
 			d.TH = val
 		case "minute":
 			d.TM = val
-		case "second":
-			d.TS = val
 		default:
 			return 0, fmt.Errorf("unknown field %s", name)
 		}
@@ -150,6 +210,12 @@ func ParseISO8601(from string) (time.Duration, error) {
 	return d.timeDuration(), nil
 }
 
+// FormatISO8601 formats a duration as an ISO8601 duration in seconds, for example PT90S or PT0.5S, which is the
+// form supported by all cameras
+func FormatISO8601(d time.Duration) string {
+	return fmt.Sprintf("PT%sS", strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+}
+
 // Duration represents an ISO8601 Duration
 type Duration struct {
 	Y int
@@ -159,13 +225,102 @@ type Duration struct {
 	// Time Component
 	TH int
 	TM int
-	TS int
+	TS float64
 }
 
 func (d Duration) timeDuration() time.Duration {
+	const day = 24 * time.Hour
 	var dur time.Duration
+	dur = dur + (time.Duration(d.Y) * 365 * day)
+	dur = dur + (time.Duration(d.M) * 30 * day)
+	dur = dur + (time.Duration(d.W) * 7 * day)
+	dur = dur + (time.Duration(d.D) * day)
 	dur = dur + (time.Duration(d.TH) * time.Hour)
 	dur = dur + (time.Duration(d.TM) * time.Minute)
-	dur = dur + (time.Duration(d.TS) * time.Second)
+	dur = dur + time.Duration(d.TS*float64(time.Second))
 	return dur
 }
+
+// parseTerminationTime parses an xsd:duration or an absolute xsd:dateTime termination time, and returns the lifetime of
+// the subscription from now, and true if the termination time is absolute
+func parseTerminationTime(value string, now time.Time) (time.Duration, bool, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "P") {
+		duration, err := ParseISO8601(value)
+		return duration, false, err
+	}
+	t, ok := parseXsdDateTime(value)
+	if !ok {
+		return 0, false, errors.NewCommonEdgeX(errors.KindContractInvalid, "invalid termination time, the format should be a duration like 'PT1H' or a date time like '2023-09-21T08:00:00Z'", nil)
+	}
+	return t.Sub(now), true, nil
+}
+
+// lifetime returns the remaining lifetime of the subscription, and true if its InitialTerminationTime is absolute.
+// The InitialTerminationTime was validated when the request was created.
+func (request *SubscriptionRequest) lifetime(now time.Time) (time.Duration, bool) {
+	duration, absolute, _ := parseTerminationTime(*request.InitialTerminationTime, now)
+	return duration, absolute
+}
+
+// terminationTime returns the termination time sent to the camera when the subscription is created or renewed. An
+// absolute time is sent in UTC, and a duration is normalized to seconds.
+func (request *SubscriptionRequest) terminationTime(now time.Time) string {
+	duration, absolute := request.lifetime(now)
+	if absolute {
+		return now.Add(duration).UTC().Format(time.RFC3339Nano)
+	}
+	return FormatISO8601(duration)
+}
+
+// expired indicates the absolute InitialTerminationTime of the subscription has passed
+func (request *SubscriptionRequest) expired(now time.Time) bool {
+	if request.InitialTerminationTime == nil {
+		return false
+	}
+	duration, absolute, err := parseTerminationTime(*request.InitialTerminationTime, now)
+	return err == nil && absolute && duration <= 0
+}
+
+// autoRenew indicates the subscription is renewed before it terminates. A subscription with an absolute termination
+// time is never renewed, since renewing it would not extend its lifetime.
+func (request *SubscriptionRequest) autoRenew() bool {
+	if request.AutoRenew == nil || !*request.AutoRenew {
+		return false
+	}
+	_, absolute := request.lifetime(time.Now())
+	return !absolute
+}
+
+// messageTimeout returns the MessageTimeout of the PullMessages requests, normalized to seconds
+func (request *SubscriptionRequest) messageTimeout() string {
+	if request.MessageTimeout == nil {
+		return ""
+	}
+	timeout, err := ParseISO8601(*request.MessageTimeout)
+	if err != nil {
+		return *request.MessageTimeout
+	}
+	return FormatISO8601(timeout)
+}
+
+// subscriptionPolicy returns the SubscriptionPolicy sent to the camera, or nil if the request does not specify one
+func (request *SubscriptionRequest) subscriptionPolicy() *xsd.String {
+	if request.SubscriptionPolicy == nil || strings.TrimSpace(*request.SubscriptionPolicy) == "" {
+		return nil
+	}
+	policy := xsd.String(*request.SubscriptionPolicy)
+	return &policy
+}
+
+// eventFilterType returns the filter of the events sent by the camera, which is empty if no expression is specified
+func eventFilterType(topicFilter, messageContentFilter *string) *event.FilterType {
+	filter := &event.FilterType{}
+	if topicFilter != nil && strings.TrimSpace(*topicFilter) != "" {
+		filter.TopicExpression = &event.TopicExpressionType{TopicKinds: xsd.String(*topicFilter)}
+	}
+	if messageContentFilter != nil && strings.TrimSpace(*messageContentFilter) != "" {
+		filter.MessageContent = &event.QueryExpressionType{MessageKind: xsd.String(*messageContentFilter)}
+	}
+	return filter
+}
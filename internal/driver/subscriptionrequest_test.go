@@ -7,9 +7,12 @@
 package driver
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/IOTechSystems/onvif"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,20 +30,44 @@ func TestParseISO8601(t *testing.T) {
 		},
 		{
 			input:    "P1Y2M3W4DT5H6M7S",
-			expected: 18367000000000,
+			expected: (365+60+21+4)*24*time.Hour + 5*time.Hour + 6*time.Minute + 7*time.Second,
 		},
 		{
 			input:    "P1YT5H",
-			expected: 18000000000000,
+			expected: 365*24*time.Hour + 5*time.Hour,
 		},
 		{
 			input:    "P5DT2M",
-			expected: 120000000000,
+			expected: 5*24*time.Hour + 2*time.Minute,
+		},
+		{
+			input:    "P1D",
+			expected: 24 * time.Hour,
+		},
+		{
+			input:    "PT0.5S",
+			expected: 500 * time.Millisecond,
+		},
+		{
+			input:    "PT1M2,25S",
+			expected: time.Minute + 2250*time.Millisecond,
 		},
 		{
 			input:         "3Y6M4DT12H30M5S",
 			errorExpected: true,
 		},
+		{
+			input:         "P",
+			errorExpected: true,
+		},
+		{
+			input:         "P1DT",
+			errorExpected: true,
+		},
+		{
+			input:         "PT1.5M",
+			errorExpected: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -57,3 +84,118 @@ func TestParseISO8601(t *testing.T) {
 		})
 	}
 }
+
+func TestNewSubscriptionRequest_named(t *testing.T) {
+	attributes := map[string]interface{}{DefaultInitialTerminationTime: "PT1H"}
+
+	request, err := newSubscriptionRequest(attributes, []byte(`{}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "PullPointSubscription", request.subscriptionName("PullPointSubscription"))
+	assert.Equal(t, eventRoute{resourceName: CameraEvent, byTopic: true}, request.eventRoute(CameraEvent))
+
+	request, err = newSubscriptionRequest(attributes, []byte(`{"Name": "Motion", "Resource": "MotionDetected"}`), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Motion", request.subscriptionName("PullPointSubscription"))
+	assert.Equal(t, eventRoute{resourceName: "MotionDetected"}, request.eventRoute(CameraEvent))
+
+	_, err = newSubscriptionRequest(attributes, []byte(`{"Name": " "}`), nil)
+	require.Error(t, err)
+	_, err = newSubscriptionRequest(attributes, []byte(`{"Resource": ""}`), nil)
+	require.Error(t, err)
+}
+
+func TestFormatISO8601(t *testing.T) {
+	assert.Equal(t, "PT86400S", FormatISO8601(24*time.Hour))
+	assert.Equal(t, "PT0.5S", FormatISO8601(500*time.Millisecond))
+	assert.Equal(t, "PT0S", FormatISO8601(0))
+}
+
+func TestParseTerminationTime(t *testing.T) {
+	now := time.Date(2023, 9, 21, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input            string
+		expected         time.Duration
+		expectedAbsolute bool
+		errorExpected    bool
+	}{
+		{input: "PT1H", expected: time.Hour},
+		{input: "P1D", expected: 24 * time.Hour},
+		{input: "2023-09-21T09:00:00Z", expected: time.Hour, expectedAbsolute: true},
+		{input: "2023-09-21T10:00:00.5+02:00", expected: 500 * time.Millisecond, expectedAbsolute: true},
+		{input: "2023-09-21T08:30:00", expected: 30 * time.Minute, expectedAbsolute: true},
+		{input: "tomorrow", errorExpected: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.input, func(t *testing.T) {
+			duration, absolute, err := parseTerminationTime(test.input, now)
+			if test.errorExpected {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, duration)
+			assert.Equal(t, test.expectedAbsolute, absolute)
+		})
+	}
+}
+
+func TestNewSubscriptionRequest_terminationTime(t *testing.T) {
+	attributes := map[string]interface{}{DefaultAutoRenew: true, DefaultMessageTimeout: "PT0.5S"}
+
+	request, err := newSubscriptionRequest(attributes, []byte(`{"InitialTerminationTime": "P1D"}`), nil)
+	require.NoError(t, err)
+	assert.True(t, request.autoRenew())
+	assert.Equal(t, "PT86400S", request.terminationTime(time.Now()))
+	assert.Equal(t, "PT0.5S", request.messageTimeout())
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	request, err = newSubscriptionRequest(attributes, []byte(fmt.Sprintf(`{"InitialTerminationTime": "%s"}`, until.Format(time.RFC3339))), nil)
+	require.NoError(t, err)
+	assert.False(t, request.autoRenew(), "a subscription with an absolute termination time should not be renewed")
+	assert.Equal(t, until.Format(time.RFC3339Nano), request.terminationTime(time.Now()))
+	lifetime, absolute := request.lifetime(time.Now())
+	assert.True(t, absolute)
+	assert.InDelta(t, time.Hour.Seconds(), lifetime.Seconds(), 2)
+
+	_, err = newSubscriptionRequest(attributes, []byte(`{"InitialTerminationTime": "2020-01-01T00:00:00Z"}`), nil)
+	require.Error(t, err, "a termination time in the past should be rejected")
+	_, err = newSubscriptionRequest(attributes, []byte(`{"InitialTerminationTime": "PT5.5S"}`), nil)
+	require.Error(t, err)
+	_, err = newSubscriptionRequest(attributes, []byte(`{"InitialTerminationTime": "PT1H", "MessageTimeout": "5S"}`), nil)
+	require.Error(t, err)
+}
+
+// TestSubscriptionRequest_optionalFields verifies that the subscriptions are created without the filters and the
+// subscription policy which are not specified by the request
+func TestSubscriptionRequest_optionalFields(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, mockDevice := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.CameraEventResource = models.DeviceResource{Name: CameraEvent}
+	mockDevice.On("GetDeviceParams").Return(onvif.DeviceParams{Xaddr: "192.168.1.10:80"})
+	terminationTime := "PT1H"
+	request := &SubscriptionRequest{InitialTerminationTime: &terminationTime}
+
+	consumer := &Consumer{Name: CameraEvent, onvifClient: client, subscriptionRequest: request}
+	subscribe := consumer.subscribeRequest()
+	assert.Nil(t, subscribe.Filter.TopicExpression)
+	assert.Nil(t, subscribe.Filter.MessageContent)
+	assert.Nil(t, subscribe.SubscriptionPolicy)
+
+	sub := &Subscriber{Name: CameraEvent, onvifClient: client, subscriptionRequest: request}
+	create := sub.createPullPointSubscription()
+	assert.Nil(t, create.Filter.TopicExpression)
+	assert.Nil(t, create.Filter.MessageContent)
+	assert.Nil(t, create.SubscriptionPolicy)
+
+	topicFilter := testMotionTopic
+	policy := "tev:ProducerProperties"
+	request.TopicFilter = &topicFilter
+	request.SubscriptionPolicy = &policy
+	subscribe = consumer.subscribeRequest()
+	require.NotNil(t, subscribe.Filter.TopicExpression)
+	assert.EqualValues(t, testMotionTopic, subscribe.Filter.TopicExpression.TopicKinds)
+	require.NotNil(t, subscribe.SubscriptionPolicy)
+	assert.EqualValues(t, policy, *subscribe.SubscriptionPolicy)
+}
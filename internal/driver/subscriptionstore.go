@@ -0,0 +1,342 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/errors"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+)
+
+const (
+	// EventSubscriptions is the protocol property which holds the json encoded event subscriptions of the camera,
+	// keyed by the name of the subscription
+	EventSubscriptions = "EventSubscriptions"
+	// CancelledSubscriptions is the protocol property which holds the json encoded names of the default subscriptions
+	// which were cancelled by the CancelSubscription or UnsubscribeCameraEvent commands
+	CancelledSubscriptions = "CancelledSubscriptions"
+	// LastMessageTimes is the protocol property which holds the json encoded RFC 3339 time of the last message received
+	// by each subscription of the camera, keyed by the name of the subscription
+	LastMessageTimes = "LastMessageTimes"
+)
+
+// persistedSubscription is an event subscription which is re-established when the device service restarts
+type persistedSubscription struct {
+	SubscribeType string
+	Request       *SubscriptionRequest
+}
+
+// loadSubscriptions returns the event subscriptions stored in the protocol properties of the device
+func loadSubscriptions(device models.Device) (map[string]persistedSubscription, errors.EdgeX) {
+	subscriptions := make(map[string]persistedSubscription)
+	value := protocolValue(device.Protocols[OnvifProtocol], EventSubscriptions)
+	if value == "" {
+		return subscriptions, nil
+	}
+	if err := json.Unmarshal([]byte(value), &subscriptions); err != nil {
+		return nil, errors.NewCommonEdgeX(errors.KindContractInvalid, fmt.Sprintf("invalid %s value of device '%s'", EventSubscriptions, device.Name), err)
+	}
+	return subscriptions, nil
+}
+
+// loadCancelledSubscriptions returns the names of the default subscriptions which were cancelled by the user
+func loadCancelledSubscriptions(device models.Device) map[string]bool {
+	cancelled := make(map[string]bool)
+	value := protocolValue(device.Protocols[OnvifProtocol], CancelledSubscriptions)
+	if value == "" {
+		return cancelled
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(value), &names); err != nil {
+		return cancelled
+	}
+	for _, name := range names {
+		cancelled[name] = true
+	}
+	return cancelled
+}
+
+// loadLastMessageTimes returns the time of the last message received by each subscription before the device service
+// stopped, see saveLastMessageTimes
+func loadLastMessageTimes(device models.Device) map[string]time.Time {
+	times := make(map[string]time.Time)
+	value := protocolValue(device.Protocols[OnvifProtocol], LastMessageTimes)
+	if value == "" {
+		return times
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return times
+	}
+	for name, value := range values {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			times[name] = t
+		}
+	}
+	return times
+}
+
+// saveSubscription stores the named subscription in the protocol properties of the device. A default subscription
+// which was cancelled is reconciled again once it is subscribed with the same name.
+func (d *Driver) saveSubscription(deviceName, name string, subscription *persistedSubscription) errors.EdgeX {
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
+	}
+	subscriptions, edgexErr := loadSubscriptions(device)
+	if edgexErr != nil {
+		// overwrite the invalid value rather than failing every subscription of the device
+		d.lc.Warn(edgexErr.Error())
+		subscriptions = make(map[string]persistedSubscription)
+	}
+	subscriptions[name] = *subscription
+	cancelled := loadCancelledSubscriptions(device)
+	delete(cancelled, name)
+	return d.storeSubscriptions(device, subscriptions, cancelled)
+}
+
+// cancelSubscriptions removes the named subscriptions, or every subscription if no name is specified, from the
+// protocol properties of the device. The cancelled default subscriptions are recorded, so that they are not
+// re-created by reconcileDefaultSubscriptions.
+func (d *Driver) cancelSubscriptions(deviceName string, names ...string) errors.EdgeX {
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
+	}
+	subscriptions, edgexErr := loadSubscriptions(device)
+	if edgexErr != nil {
+		d.lc.Warn(edgexErr.Error())
+		subscriptions = make(map[string]persistedSubscription)
+	}
+	cancelled := loadCancelledSubscriptions(device)
+
+	all := len(names) == 0
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+	for name := range subscriptions {
+		if all || selected[name] {
+			delete(subscriptions, name)
+		}
+	}
+	for _, subscription := range d.declaredSubscriptions(device, nil) {
+		if all || selected[subscription.name] {
+			cancelled[subscription.name] = true
+		}
+	}
+	times := loadLastMessageTimes(device)
+	for name := range times {
+		if all || selected[name] {
+			delete(times, name)
+		}
+	}
+	if edgexErr = storeLastMessageTimes(device, times); edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	return d.storeSubscriptions(device, subscriptions, cancelled)
+}
+
+// storeSubscriptions writes the subscriptions and the cancelled default subscriptions to the protocol properties of
+// the device. It should be called while the lock of lockDeviceProtocols is held.
+func (d *Driver) storeSubscriptions(device models.Device, subscriptions map[string]persistedSubscription, cancelled map[string]bool) errors.EdgeX {
+	if len(subscriptions) == 0 {
+		delete(device.Protocols[OnvifProtocol], EventSubscriptions)
+	} else {
+		data, err := json.Marshal(subscriptions)
+		if err != nil {
+			return errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the event subscriptions", err)
+		}
+		device.Protocols[OnvifProtocol][EventSubscriptions] = string(data)
+	}
+	if len(cancelled) == 0 {
+		delete(device.Protocols[OnvifProtocol], CancelledSubscriptions)
+	} else {
+		names := make([]string, 0, len(cancelled))
+		for name := range cancelled {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		data, err := json.Marshal(names)
+		if err != nil {
+			return errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the cancelled subscriptions", err)
+		}
+		device.Protocols[OnvifProtocol][CancelledSubscriptions] = string(data)
+	}
+	if err := d.patchDeviceProtocols(device.Name, device.Protocols); err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", device.Name), err)
+	}
+	return nil
+}
+
+// restoreSubscriptions re-establishes the event subscriptions stored in the protocol properties of the device.
+// The subscriptions of a locked device are suspended until the device is unlocked. The subscriptions whose absolute
+// termination time has passed are removed instead. The events which may have been lost since the last message of a
+// restored subscription are recorded as a gap, which is replayed by the ReplayEvents command.
+func (d *Driver) restoreSubscriptions(onvifClient *OnvifClient, device models.Device) {
+	subscriptions, edgexErr := loadSubscriptions(device)
+	if edgexErr != nil {
+		d.lc.Warnf("Unable to restore the event subscriptions of device %s, %v", device.Name, edgexErr)
+		return
+	}
+
+	lastMessageTimes := loadLastMessageTimes(device)
+	var expired []string
+	for name, subscription := range subscriptions {
+		if subscription.Request == nil {
+			continue
+		}
+		if subscription.Request.expired(time.Now()) {
+			d.lc.Infof("The '%s' event subscription of device %s has expired, it is not restored", name, device.Name)
+			expired = append(expired, name)
+			continue
+		}
+		if onvifClient.locked.Load() {
+			switch subscription.SubscribeType {
+			case PullPoint:
+				onvifClient.pullPointManager.suspend(name, subscription.Request)
+			case BaseNotification:
+				onvifClient.baseNotificationManager.suspend(name, subscription.Request)
+			}
+			continue
+		}
+
+		d.lc.Infof("Restoring the '%s' event subscription of device %s", name, device.Name)
+		if edgexErr = onvifClient.subscribe(name, subscription); edgexErr != nil {
+			d.lc.Errorf("Failed to restore the '%s' event subscription of device %s, %v", name, device.Name, edgexErr)
+			continue
+		}
+		onvifClient.recordDowntimeGap(name, lastMessageTimes[name], time.Now())
+	}
+	if len(expired) > 0 {
+		if edgexErr = d.removeSubscriptions(device.Name, expired); edgexErr != nil {
+			d.lc.Warnf("Unable to remove the expired event subscriptions of device %s, %v", device.Name, edgexErr)
+		}
+	}
+}
+
+// removeSubscriptions removes the named subscriptions from the protocol properties of the device. Unlike
+// cancelSubscriptions, the default subscriptions are not recorded as cancelled.
+func (d *Driver) removeSubscriptions(deviceName string, names []string) errors.EdgeX {
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
+	}
+	subscriptions, edgexErr := loadSubscriptions(device)
+	if edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	times := loadLastMessageTimes(device)
+	for _, name := range names {
+		delete(subscriptions, name)
+		delete(times, name)
+	}
+	if edgexErr = storeLastMessageTimes(device, times); edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	return d.storeSubscriptions(device, subscriptions, loadCancelledSubscriptions(device))
+}
+
+// storeLastMessageTimes writes the time of the last message of each subscription to the protocol properties of the
+// device, without saving them
+func storeLastMessageTimes(device models.Device, times map[string]time.Time) errors.EdgeX {
+	if len(times) == 0 {
+		delete(device.Protocols[OnvifProtocol], LastMessageTimes)
+		return nil
+	}
+	values := make(map[string]string, len(times))
+	for name, t := range times {
+		values[name] = t.UTC().Format(time.RFC3339)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, "failed to marshal the last message times", err)
+	}
+	device.Protocols[OnvifProtocol][LastMessageTimes] = string(data)
+	return nil
+}
+
+// saveLastMessageTimes stores the time of the last message received by each subscription of the camera in the
+// protocol properties of the device, so that the events lost while the device service is stopped can be replayed
+// once the subscriptions are restored. It is called periodically by the task loop and when the device service stops.
+func (d *Driver) saveLastMessageTimes(onvifClient *OnvifClient) errors.EdgeX {
+	current := make(map[string]time.Time)
+	for _, source := range onvifClient.replaySources() {
+		if last := source.health.lastMessageTime(); !last.IsZero() {
+			current[source.name] = last.Truncate(time.Second)
+		}
+	}
+	if len(current) == 0 {
+		return nil
+	}
+
+	deviceName := onvifClient.deviceName()
+	unlock := d.lockDeviceProtocols(deviceName)
+	defer unlock()
+	device, err := d.latestDevice(deviceName)
+	if err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to get device '%s'", deviceName), err)
+	}
+	times := loadLastMessageTimes(device)
+	changed := false
+	for name, last := range current {
+		if !last.After(times[name]) {
+			continue
+		}
+		times[name] = last
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if edgexErr := storeLastMessageTimes(device, times); edgexErr != nil {
+		return errors.NewCommonEdgeXWrapper(edgexErr)
+	}
+	if err = d.patchDeviceProtocols(device.Name, device.Protocols); err != nil {
+		return errors.NewCommonEdgeX(errors.KindServerError, fmt.Sprintf("failed to update device '%s'", device.Name), err)
+	}
+	return nil
+}
+
+// saveAllLastMessageTimes stores the time of the last message received by the subscriptions of every camera
+func (d *Driver) saveAllLastMessageTimes() {
+	d.clientsMu.RLock()
+	clients := make([]*OnvifClient, 0, len(d.onvifClients))
+	for _, client := range d.onvifClients {
+		clients = append(clients, client)
+	}
+	d.clientsMu.RUnlock()
+	for _, client := range clients {
+		if edgexErr := d.saveLastMessageTimes(client); edgexErr != nil {
+			d.lc.Warnf("Unable to persist the last message times of the subscriptions of device %s, %v", client.deviceName(), edgexErr)
+		}
+	}
+}
+
+// recordDowntimeGap records the gap between the last message the named subscription received before the device
+// service stopped and the time it was re-created, during which its events may have been lost
+func (onvifClient *OnvifClient) recordDowntimeGap(name string, lastMessage, now time.Time) {
+	if lastMessage.IsZero() || !lastMessage.Before(now) {
+		return
+	}
+	for _, source := range onvifClient.replaySources() {
+		if source.name == name {
+			source.health.restoreGaps([]eventGap{{start: lastMessage, end: now}})
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2023 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSubscriptions(t *testing.T) {
+	device := createTestDevice()
+	subscriptions, err := loadSubscriptions(device)
+	require.NoError(t, err)
+	assert.Empty(t, subscriptions)
+
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"PullPointSubscription":{"SubscribeType":"PullPoint","Request":{"TopicFilter":"tns1:RuleEngine/CellMotionDetector/Motion","MessageLimit":10}}}`
+	subscriptions, err = loadSubscriptions(device)
+	require.NoError(t, err)
+	require.Contains(t, subscriptions, "PullPointSubscription")
+	subscription := subscriptions["PullPointSubscription"]
+	assert.Equal(t, PullPoint, subscription.SubscribeType)
+	assert.Equal(t, "tns1:RuleEngine/CellMotionDetector/Motion", *subscription.Request.TopicFilter)
+	assert.Equal(t, 10, *subscription.Request.MessageLimit)
+
+	device.Protocols[OnvifProtocol][EventSubscriptions] = "invalid"
+	_, err = loadSubscriptions(device)
+	require.Error(t, err)
+}
+
+func TestDriver_saveSubscription(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"BaseNotificationSubscription":{"SubscribeType":"BaseNotification","Request":{}}}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+
+	var saved models.Device
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		saved = models.Device{Name: *update.Name, Protocols: dtos.ToProtocolModels(update.Protocols)}
+	}).Return(nil)
+
+	topicFilter := "tns1:VideoSource/MotionAlarm"
+	err := driver.saveSubscription(testDeviceName, "PullPointSubscription",
+		&persistedSubscription{SubscribeType: PullPoint, Request: &SubscriptionRequest{TopicFilter: &topicFilter}})
+	require.NoError(t, err)
+
+	subscriptions, err := loadSubscriptions(saved)
+	require.NoError(t, err)
+	assert.Len(t, subscriptions, 2)
+	assert.Equal(t, BaseNotification, subscriptions["BaseNotificationSubscription"].SubscribeType)
+	assert.Equal(t, topicFilter, *subscriptions["PullPointSubscription"].Request.TopicFilter)
+}
+
+func TestDriver_restoreSubscriptions_locked(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	client.locked.Store(true)
+
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"PullPointSubscription":{"SubscribeType":"PullPoint","Request":{}},"BaseNotificationSubscription":{"SubscribeType":"BaseNotification","Request":{}}}`
+	driver.restoreSubscriptions(client, device)
+
+	// no requests are sent to the camera of a locked device
+	assert.Contains(t, client.pullPointManager.suspended, "PullPointSubscription")
+	assert.Contains(t, client.baseNotificationManager.suspended, "BaseNotificationSubscription")
+	assert.Empty(t, client.pullPointManager.subscribers)
+	assert.Empty(t, client.baseNotificationManager.consumers)
+}
+
+func TestDriver_restoreSubscriptions_expired(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client, _ := createOnvifClientWithMockDevice(driver, testDeviceName)
+	client.pullPointManager = newPullPointManager(driver.lc)
+	client.baseNotificationManager = NewBaseNotificationManager(driver.lc)
+	client.locked.Store(true)
+
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"Expired":{"SubscribeType":"PullPoint","Request":{"InitialTerminationTime":"2023-09-21T08:00:00Z"}},` +
+		`"Renewed":{"SubscribeType":"PullPoint","Request":{"InitialTerminationTime":"PT1H"}}}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	var saved models.Device
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		saved = models.Device{Name: *update.Name, Protocols: dtos.ToProtocolModels(update.Protocols)}
+	}).Return(nil).Once()
+	driver.restoreSubscriptions(client, device)
+
+	assert.NotContains(t, client.pullPointManager.suspended, "Expired")
+	assert.Contains(t, client.pullPointManager.suspended, "Renewed")
+	subscriptions, err := loadSubscriptions(saved)
+	require.NoError(t, err)
+	assert.NotContains(t, subscriptions, "Expired", "the expired subscription is pruned")
+	assert.Contains(t, subscriptions, "Renewed")
+	assert.Empty(t, loadCancelledSubscriptions(saved))
+}
+
+func TestDriver_saveLastMessageTimes(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][LastMessageTimes] = `{"BaseNotificationSubscription":"2023-09-21T07:00:00Z","Cancelled":"2023-09-21T07:00:00Z"}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	var saved models.Device
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		saved = models.Device{Name: *update.Name, Protocols: dtos.ToProtocolModels(update.Protocols)}
+	}).Return(nil).Once()
+
+	// the consumer of createOnvifClientWithSubscriptions received its last message at 08:00
+	require.NoError(t, driver.saveLastMessageTimes(client))
+	times := loadLastMessageTimes(saved)
+	assert.Equal(t, time.Date(2023, 9, 21, 8, 0, 0, 0, time.UTC), times["BaseNotificationSubscription"].UTC())
+	assert.Contains(t, times, "Cancelled")
+
+	// the time is only saved again when a later message is received
+	mockService.On("GetDeviceByName", testDeviceName).Unset()
+	mockService.On("GetDeviceByName", testDeviceName).Return(saved, nil)
+	require.NoError(t, driver.saveLastMessageTimes(client))
+	mockService.AssertNumberOfCalls(t, "PatchDevice", 1)
+}
+
+func TestDriver_removeSubscriptions_lastMessageTimes(t *testing.T) {
+	driver, mockService := createDriverWithMockService()
+	device := createTestDevice()
+	device.Protocols[OnvifProtocol][EventSubscriptions] = `{"Motion":{"SubscribeType":"PullPoint","Request":{}}}`
+	device.Protocols[OnvifProtocol][LastMessageTimes] = `{"Motion":"2023-09-21T07:00:00Z"}`
+	mockService.On("GetDeviceByName", testDeviceName).Return(device, nil)
+	var saved models.Device
+	mockService.On("PatchDevice", mock.Anything).Run(func(args mock.Arguments) {
+		update := args.Get(0).(dtos.UpdateDevice)
+		saved = models.Device{Name: *update.Name, Protocols: dtos.ToProtocolModels(update.Protocols)}
+	}).Return(nil)
+
+	require.NoError(t, driver.removeSubscriptions(testDeviceName, []string{"Motion"}))
+	assert.NotContains(t, saved.Protocols[OnvifProtocol], LastMessageTimes)
+}
+
+func TestOnvifClient_recordDowntimeGap(t *testing.T) {
+	driver, _ := createDriverWithMockService()
+	client := createOnvifClientWithSubscriptions(driver)
+	lastMessage := time.Date(2023, 9, 21, 7, 0, 0, 0, time.UTC)
+	restored := time.Date(2023, 9, 21, 9, 0, 0, 0, time.UTC)
+
+	client.recordDowntimeGap("BaseNotificationSubscription", time.Time{}, restored)
+	client.recordDowntimeGap("Unknown", lastMessage, restored)
+	client.recordDowntimeGap("BaseNotificationSubscription", lastMessage, restored)
+
+	health := client.baseNotificationManager.health()["BaseNotificationSubscription"]
+	assert.Equal(t, []EventGap{{Start: "2023-09-21T07:00:00Z", End: "2023-09-21T09:00:00Z"}}, health.EventGaps)
+}